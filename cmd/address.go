@@ -0,0 +1,158 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package cmd
+
+import (
+	"log/slog"
+	"net/netip"
+	"netnsplan/config"
+	"netnsplan/iproute2"
+	"slices"
+	"strconv"
+)
+
+func SetAddress(ip IpCommand, name string, address config.Address, addrInfo []iproute2.AddressInfo) error {
+	options := addressOptions(address.AddressOptions)
+
+	i := slices.IndexFunc(addrInfo, func(ai iproute2.AddressInfo) bool {
+		return matchAddress(ai, address)
+	})
+	if i < 0 {
+		if ip.InNetns() {
+			slog.Info("add addresses", "name", name, "address", address.Address, "netns", ip.Netns())
+		} else {
+			slog.Info("add addresses", "name", name, "address", address.Address)
+		}
+
+		return ip.AddAddress(name, address.Address, options...)
+	}
+
+	current := addrInfo[i]
+	switch {
+	case address.Label != "" && address.Label != current.Label,
+		address.Scope != "" && address.Scope != current.Scope:
+		// label and scope cannot be changed with "ip address replace"
+		logWithNetns(ip, "re-add address", "name", name, "address", address.Address,
+			"current label", current.Label, "label", address.Label, "current scope", current.Scope, "scope", address.Scope)
+
+		err := ip.DelAddress(name, address.Address, peerOption(address.AddressOptions)...)
+		if err != nil {
+			return err
+		}
+		return ip.AddAddress(name, address.Address, options...)
+	case addressFlagsDrifted(current, address.AddressOptions):
+		logWithNetns(ip, "replace address", "name", name, "address", address.Address, "options", options)
+		return ip.ReplaceAddress(name, address.Address, options...)
+	}
+
+	slog.Debug("address is already exists", "name", name, "address", address.Address)
+	return nil
+}
+
+func addressOptions(o config.AddressOptions) []string {
+	options := peerOption(o)
+	if o.Label != "" {
+		options = append(options, "label", o.Label)
+	}
+	if o.Scope != "" {
+		options = append(options, "scope", o.Scope)
+	}
+	if o.ValidLifetime != "" {
+		options = append(options, "valid_lft", o.ValidLifetime)
+	}
+	if preferred := preferredLifetime(o); preferred != "" {
+		options = append(options, "preferred_lft", preferred)
+	}
+	if o.NoDad {
+		options = append(options, "nodad")
+	}
+	if o.NoPrefixRoute {
+		options = append(options, "noprefixroute")
+	}
+	return options
+}
+
+func peerOption(o config.AddressOptions) []string {
+	if o.Peer == "" {
+		return nil
+	}
+	return []string{"peer", o.Peer}
+}
+
+func matchAddress(ai iproute2.AddressInfo, address config.Address) bool {
+	local, prefixlen, ok := parseAddress(address.Address)
+	if !ok {
+		return address.Address == ai.Local+"/"+strconv.Itoa(ai.Prefixlen)
+	}
+
+	if address.Peer != "" {
+		// with a peer, the prefix length belongs to the peer address
+		var peer netip.Addr
+		peer, prefixlen, ok = parseAddress(address.Peer)
+		if !ok || !sameAddr(ai.Address, peer) {
+			return false
+		}
+	}
+
+	return sameAddr(ai.Local, local) && ai.Prefixlen == prefixlen
+}
+
+// parseAddress parses "addr" or "addr/prefixlen". A missing prefix length
+// means a host address, as with "ip address add".
+func parseAddress(s string) (netip.Addr, int, bool) {
+	if p, err := netip.ParsePrefix(s); err == nil {
+		return p.Addr(), p.Bits(), true
+	}
+	if a, err := netip.ParseAddr(s); err == nil {
+		return a, a.BitLen(), true
+	}
+	return netip.Addr{}, 0, false
+}
+
+func sameAddr(s string, addr netip.Addr) bool {
+	a, err := netip.ParseAddr(s)
+	return err == nil && a == addr
+}
+
+// addressFlagsDrifted reports whether flags or lifetimes of the address
+// differ from the config. A finite lifetime counts down, so only the
+// difference between forever and finite is detected.
+func addressFlagsDrifted(ai iproute2.AddressInfo, o config.AddressOptions) bool {
+	if ai.NoDad != o.NoDad || ai.NoPrefixRoute != o.NoPrefixRoute {
+		return true
+	}
+	return lifetimeDrifted(o.ValidLifetime, ai.ValidLifeTime) || lifetimeDrifted(preferredLifetime(o), ai.PreferredLifeTime)
+}
+
+// preferredLifetime defaults to the valid lifetime, since the kernel
+// rejects a preferred lifetime longer than the valid one.
+func preferredLifetime(o config.AddressOptions) string {
+	if o.PreferredLifetime == "" {
+		return o.ValidLifetime
+	}
+	return o.PreferredLifetime
+}
+
+func lifetimeDrifted(want string, current uint64) bool {
+	forever := want == "" || want == "forever"
+	return forever != (current == iproute2.ForeverLifetime)
+}
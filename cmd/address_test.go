@@ -0,0 +1,270 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package cmd
+
+import (
+	"netnsplan/config"
+	"netnsplan/iproute2"
+	"reflect"
+	"testing"
+)
+
+func (f *fakeIp) AddAddress(name, address string, options ...string) error {
+	return f.record("add %s %s %v", name, address, options)
+}
+
+func (f *fakeIp) DelAddress(name, address string, options ...string) error {
+	return f.record("del %s %s %v", name, address, options)
+}
+
+func (f *fakeIp) ReplaceAddress(name, address string, options ...string) error {
+	return f.record("replace %s %s %v", name, address, options)
+}
+
+func TestMatchAddress(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		current  iproute2.AddressInfo
+		address  config.Address
+		expected bool
+	}{
+		{
+			desc:     "Same address",
+			current:  iproute2.AddressInfo{Local: "10.0.0.1", Prefixlen: 24},
+			address:  config.Address{Address: "10.0.0.1/24"},
+			expected: true,
+		},
+		{
+			desc:     "Other prefix length",
+			current:  iproute2.AddressInfo{Local: "10.0.0.1", Prefixlen: 24},
+			address:  config.Address{Address: "10.0.0.1/16"},
+			expected: false,
+		},
+		{
+			desc:     "Host address without prefix length",
+			current:  iproute2.AddressInfo{Local: "10.0.0.1", Prefixlen: 32},
+			address:  config.Address{Address: "10.0.0.1"},
+			expected: true,
+		},
+		{
+			desc:     "IPv6 in another notation",
+			current:  iproute2.AddressInfo{Local: "2001:db8::1", Prefixlen: 64},
+			address:  config.Address{Address: "2001:db8:0::1/64"},
+			expected: true,
+		},
+		{
+			desc:    "Peer",
+			current: iproute2.AddressInfo{Local: "10.0.0.1", Address: "10.0.0.2", Prefixlen: 32},
+			address: config.Address{
+				Address:        "10.0.0.1/32",
+				AddressOptions: config.AddressOptions{Peer: "10.0.0.2/32"},
+			},
+			expected: true,
+		},
+		{
+			desc:    "Prefix length of the peer",
+			current: iproute2.AddressInfo{Local: "10.0.0.1", Address: "10.0.1.0", Prefixlen: 24},
+			address: config.Address{
+				Address:        "10.0.0.1",
+				AddressOptions: config.AddressOptions{Peer: "10.0.1.0/24"},
+			},
+			expected: true,
+		},
+		{
+			desc:    "Other peer",
+			current: iproute2.AddressInfo{Local: "10.0.0.1", Address: "10.0.0.3", Prefixlen: 32},
+			address: config.Address{
+				Address:        "10.0.0.1/32",
+				AddressOptions: config.AddressOptions{Peer: "10.0.0.2/32"},
+			},
+			expected: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			if got := matchAddress(tc.current, tc.address); got != tc.expected {
+				t.Errorf("matchAddress() = %v, want %v", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestAddressFlagsDrifted(t *testing.T) {
+	forever := iproute2.AddressInfo{
+		Local: "10.0.0.1", Prefixlen: 24,
+		ValidLifeTime: iproute2.ForeverLifetime, PreferredLifeTime: iproute2.ForeverLifetime,
+	}
+	finite := iproute2.AddressInfo{
+		Local: "10.0.0.1", Prefixlen: 24,
+		ValidLifeTime: 3580, PreferredLifeTime: 1780,
+	}
+
+	testCases := []struct {
+		desc     string
+		current  iproute2.AddressInfo
+		options  config.AddressOptions
+		expected bool
+	}{
+		{
+			desc:     "No options",
+			current:  forever,
+			options:  config.AddressOptions{},
+			expected: false,
+		},
+		{
+			desc:     "nodad is added",
+			current:  forever,
+			options:  config.AddressOptions{NoDad: true},
+			expected: true,
+		},
+		{
+			desc:     "noprefixroute is removed",
+			current:  iproute2.AddressInfo{NoPrefixRoute: true, ValidLifeTime: iproute2.ForeverLifetime, PreferredLifeTime: iproute2.ForeverLifetime},
+			options:  config.AddressOptions{},
+			expected: true,
+		},
+		{
+			desc:     "Explicit forever",
+			current:  forever,
+			options:  config.AddressOptions{ValidLifetime: "forever", PreferredLifetime: "forever"},
+			expected: false,
+		},
+		{
+			desc:     "Forever becomes finite",
+			current:  forever,
+			options:  config.AddressOptions{ValidLifetime: "3600"},
+			expected: true,
+		},
+		{
+			desc:     "Finite lifetimes counting down",
+			current:  finite,
+			options:  config.AddressOptions{ValidLifetime: "3600", PreferredLifetime: "1800"},
+			expected: false,
+		},
+		{
+			desc:     "Finite becomes forever",
+			current:  finite,
+			options:  config.AddressOptions{},
+			expected: true,
+		},
+		{
+			desc:     "Only the preferred lifetime is finite",
+			current:  iproute2.AddressInfo{ValidLifeTime: iproute2.ForeverLifetime, PreferredLifeTime: 1780},
+			options:  config.AddressOptions{},
+			expected: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			if got := addressFlagsDrifted(tc.current, tc.options); got != tc.expected {
+				t.Errorf("addressFlagsDrifted() = %v, want %v", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestSetAddress(t *testing.T) {
+	addrInfo := []iproute2.AddressInfo{
+		{
+			Local: "10.0.0.1", Prefixlen: 24, Scope: "global", Label: "eth0",
+			ValidLifeTime: iproute2.ForeverLifetime, PreferredLifeTime: iproute2.ForeverLifetime,
+		},
+		{
+			Local: "10.0.1.1", Address: "10.0.1.2", Prefixlen: 32, Scope: "global", Label: "eth0",
+			ValidLifeTime: iproute2.ForeverLifetime, PreferredLifeTime: iproute2.ForeverLifetime,
+		},
+	}
+
+	testCases := []struct {
+		desc     string
+		address  config.Address
+		expected []string
+	}{
+		{
+			desc:     "Up to date",
+			address:  config.Address{Address: "10.0.0.1/24"},
+			expected: nil,
+		},
+		{
+			desc:     "New address",
+			address:  config.Address{Address: "10.0.2.1/24"},
+			expected: []string{"add eth0 10.0.2.1/24 []"},
+		},
+		{
+			desc: "Label changes",
+			address: config.Address{
+				Address:        "10.0.0.1/24",
+				AddressOptions: config.AddressOptions{Label: "eth0:1"},
+			},
+			expected: []string{"del eth0 10.0.0.1/24 []", "add eth0 10.0.0.1/24 [label eth0:1]"},
+		},
+		{
+			desc: "Scope of a peer address changes",
+			address: config.Address{
+				Address:        "10.0.1.1",
+				AddressOptions: config.AddressOptions{Peer: "10.0.1.2/32", Scope: "link"},
+			},
+			expected: []string{
+				"del eth0 10.0.1.1 [peer 10.0.1.2/32]",
+				"add eth0 10.0.1.1 [peer 10.0.1.2/32 scope link]",
+			},
+		},
+		{
+			desc: "nodad is added",
+			address: config.Address{
+				Address:        "10.0.0.1/24",
+				AddressOptions: config.AddressOptions{NoDad: true},
+			},
+			expected: []string{"replace eth0 10.0.0.1/24 [nodad]"},
+		},
+		{
+			desc: "noprefixroute is added",
+			address: config.Address{
+				Address:        "10.0.0.1/24",
+				AddressOptions: config.AddressOptions{NoPrefixRoute: true},
+			},
+			expected: []string{"replace eth0 10.0.0.1/24 [noprefixroute]"},
+		},
+		{
+			desc: "Lifetime becomes finite",
+			address: config.Address{
+				Address:        "10.0.0.1/24",
+				AddressOptions: config.AddressOptions{ValidLifetime: "3600", PreferredLifetime: "1800"},
+			},
+			expected: []string{"replace eth0 10.0.0.1/24 [valid_lft 3600 preferred_lft 1800]"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			ip := &fakeIp{}
+			if err := SetAddress(ip, "eth0", tc.address, addrInfo); err != nil {
+				t.Fatalf("SetAddress() error = %v", err)
+			}
+			if !reflect.DeepEqual(ip.calls, tc.expected) {
+				t.Errorf("calls = %v, want %v", ip.calls, tc.expected)
+			}
+		})
+	}
+}
@@ -26,7 +26,11 @@ import (
 	"log/slog"
 	"netnsplan/config"
 	"netnsplan/iproute2"
+	"os"
+	"path/filepath"
 	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/spf13/cobra"
 )
@@ -38,6 +42,16 @@ var applyCmd = &cobra.Command{
 	Short: "Apply netns networks configuration to running system",
 	Long:  "Apply netns networks configuration to running system",
 	RunE: func(cmd *cobra.Command, args []string) error {
+		err := checkRouteConflicts(cfg)
+		if err != nil {
+			return err
+		}
+
+		var topologyHosts []config.HostsEntry
+		if cfg.TopologyHosts.Enabled {
+			topologyHosts = TopologyHosts(cfg)
+		}
+
 		for netns, values := range cfg.Netns {
 			needPostScript := true
 			if ip.ExistsNetns(netns) {
@@ -51,7 +65,27 @@ var applyCmd = &cobra.Command{
 				}
 			}
 
-			err := SetupLoopback(netns, values.Loopback)
+			err := SetupRouteTables(netns, values.RouteTables)
+			if err != nil {
+				return err
+			}
+
+			err = SetupResolvConf(netns, values.DNS)
+			if err != nil {
+				return err
+			}
+
+			err = SetupHosts(netns, append(slices.Clone(values.Hosts), topologyHosts...))
+			if err != nil {
+				return err
+			}
+
+			err = SetMplsPlatformLabels(IntoNetns(netns), values.MplsPlatformLabels)
+			if err != nil {
+				return err
+			}
+
+			err = SetupLoopback(netns, values.Loopback)
 			if err != nil {
 				return err
 			}
@@ -66,11 +100,31 @@ var applyCmd = &cobra.Command{
 				return err
 			}
 
+			err = SetupGeneveDevices(netns, values.Geneves)
+			if err != nil {
+				return err
+			}
+
+			err = SetupBareUDPDevices(netns, values.BareUDP)
+			if err != nil {
+				return err
+			}
+
 			err = SetupVethDevices(netns, values.VethDevices)
 			if err != nil {
 				return err
 			}
 
+			err = SetupUplink(netns, values.Uplink)
+			if err != nil {
+				return err
+			}
+
+			err = SetupPortForwards(netns, values.PortForwards)
+			if err != nil {
+				return err
+			}
+
 			if needPostScript || alwaysRunPostScript {
 				err = RunPostScript(netns, values.PostScript)
 				if err != nil {
@@ -78,6 +132,68 @@ var applyCmd = &cobra.Command{
 				}
 			}
 		}
+
+		// the host's entries are removed once the option is turned off
+		var hostTopologyHosts []config.HostsEntry
+		if cfg.TopologyHosts.Host {
+			hostTopologyHosts = topologyHosts
+		}
+		err = SetupHostTopologyHosts(hostTopologyHosts)
+		if err != nil {
+			return err
+		}
+
+		// nexthops and namespace-level routes may go through a veth
+		// peer created by another netns, and rules and sysctls may
+		// refer to it
+		for netns, values := range cfg.Netns {
+			err := SetupSysctls(IntoNetns(netns), values.Sysctls)
+			if err != nil {
+				return err
+			}
+
+			err = SetupFirewall(IntoNetns(netns), netns, values.Firewall)
+			if err != nil {
+				return err
+			}
+
+			err = SetupNexthops(IntoNetns(netns), values.Nexthops)
+			if err != nil {
+				return err
+			}
+
+			err = SetupMplsRoutes(IntoNetns(netns), values.MplsRoutes)
+			if err != nil {
+				return err
+			}
+
+			err = SetupRoutes(IntoNetns(netns), "", values.Routes, values.RouteTables)
+			if err != nil {
+				return err
+			}
+
+			err = SetupRoutingPolicy(IntoNetns(netns), values.RoutingPolicy, values.RouteTables)
+			if err != nil {
+				return err
+			}
+		}
+
+		// leases are acquired after every netns is set up,
+		// since the DHCP server may live in another netns
+		for _, d := range ListDevices(cfg) {
+			n := IntoNetns(d.Netns)
+			ready, err := dhcpReady(n, d.Name, d.Values)
+			if err != nil {
+				return err
+			}
+			if !ready {
+				continue
+			}
+			err = SetupDHCP(n, d.Name, d.Values)
+			if err != nil {
+				return err
+			}
+		}
 		return nil
 	},
 }
@@ -85,21 +201,112 @@ var applyCmd = &cobra.Command{
 func init() {
 	rootCmd.AddCommand(applyCmd)
 	applyCmd.Flags().BoolVarP(&alwaysRunPostScript, "always-run-post-script", "R", false, "always run post-script. by default, runs only when a netns is created.")
+	applyCmd.Flags().BoolVar(&removeStaleRules, "remove-stale-rules", false, "remove policy routing rules that are not in the configuration.")
 }
 
 type IpCommand interface {
 	SetLinkUp(name string) error
+	SetLinkDown(name string) error
+	SetLinkMtu(name string, mtu int) error
+	SetLinkAddress(name, address string) error
+	SetLinkTxQueueLen(name string, txqueuelen int) error
+	SetLinkAlias(name, alias string) error
+	SetLinkGroup(name, group string) error
+	AddLinkAltName(name, altname string) error
+	SetLinkMaster(name, master string) error
+	SetLinkNoMaster(name string) error
 	ShowLink(name string) (*iproute2.Link, error)
+	ListLinkDetails() (iproute2.Links, error)
 	ShowInterface(name string) (*iproute2.InterfaceInfo, error)
-	AddAddress(name, address string) error
-	ShowRoutes(name string) (iproute2.Routes, error)
-	AddRoute(name, to, via string) error
+	AddAddress(name, address string, options ...string) error
+	ReplaceAddress(name, address string, options ...string) error
+	DelAddress(name, address string, options ...string) error
+	ListRoutes(family string) (iproute2.Routes, error)
+	AddRoute(name, to, via string, options ...string) error
+	ReplaceRoute(name, to, via string, options ...string) error
+	AddMultipathRoute(to string, nexthops []iproute2.RouteNexthop, options ...string) error
+	ReplaceMultipathRoute(to string, nexthops []iproute2.RouteNexthop, options ...string) error
+	ListNexthops() (iproute2.Nexthops, error)
+	AddNexthop(id int, options ...string) error
+	ReplaceNexthop(id int, options ...string) error
+	DelNexthop(id int) error
+	ListMplsRoutes() (iproute2.MplsRoutes, error)
+	AddMplsRoute(label int, options ...string) error
+	ReplaceMplsRoute(label int, options ...string) error
+	ListNeighbors(name string) (iproute2.Neighbors, error)
+	ListProxyNeighbors(name string) (iproute2.Neighbors, error)
+	AddNeighbor(name, address string, options ...string) error
+	ReplaceNeighbor(name, address string, options ...string) error
+	DelNeighbor(name, address string) error
+	AddProxyNeighbor(name, address string) error
+	DelProxyNeighbor(name, address string) error
+	ListRules(family string) (iproute2.Rules, error)
+	AddRule(family string, options ...string) error
+	DelRule(family string, options ...string) error
+	Ethtool(path string) *iproute2.EthtoolCmd
+	Bridge(path string) *iproute2.BridgeCmd
+	Nft(path string) *iproute2.NftCmd
+	Tc(path string) *iproute2.TcCmd
+	Sysctl(path string) iproute2.SysctlCommand
 	InNetns() bool
 	Netns() string
 }
 
-func SetupDevice(ip IpCommand, name string, addresses []string, routes []config.Route) error {
-	err := SetLinkUp(ip, name)
+func SetupDevice(ip IpCommand, name string, values config.Ethernet) error {
+	if values.Optional {
+		_, err := ip.ShowLink(name)
+		if _, ok := err.(*iproute2.NotExistError); ok {
+			if ip.InNetns() {
+				slog.Warn("optional device is not found, skipped", "name", name, "netns", ip.Netns())
+			} else {
+				slog.Warn("optional device is not found, skipped", "name", name)
+			}
+			return nil
+		}
+	}
+
+	err := SetLinkProperties(ip, name, values)
+	if err != nil {
+		return err
+	}
+
+	err = SetOffload(ip, name, values.Offload)
+	if err != nil {
+		return err
+	}
+
+	if values.Ring != nil {
+		err = SetRing(ip, name, *values.Ring)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = SetIPv6Settings(ip, name, values)
+	if err != nil {
+		return err
+	}
+
+	err = SetMplsInput(ip, name, values.MplsInput)
+	if err != nil {
+		return err
+	}
+
+	switch values.Activation {
+	case "", config.ActivationUp:
+		err = SetLinkUp(ip, name)
+	case config.ActivationDown:
+		err = SetLinkDown(ip, name)
+	case config.ActivationManual:
+		slog.Debug("link state is managed manually", "name", name)
+	default:
+		err = fmt.Errorf("unknown activation mode %q for %s", values.Activation, name)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = SetupNetem(ip, name, values.Netem)
 	if err != nil {
 		return err
 	}
@@ -109,56 +316,113 @@ func SetupDevice(ip IpCommand, name string, addresses []string, routes []config.
 		return err
 	}
 
-	var intAddrs []string
-	for _, i := range iface.AddrInfo {
-		intAddrs = append(intAddrs, fmt.Sprintf("%s/%d", i.Local, i.Prefixlen))
+	for _, address := range values.Addresses {
+		err = SetAddress(ip, name, address, iface.AddrInfo)
+		if err != nil {
+			return err
+		}
 	}
 
-	for _, address := range addresses {
-		if slices.Contains(intAddrs, address) {
-			slog.Debug("address is already exists", "name", name, "address", address)
-			continue
+	err = SetupNeighbors(ip, name, values.Neighbors)
+	if err != nil {
+		return err
+	}
+
+	err = SetupProxyNeighbors(ip, name, values.ProxyNeighbors)
+	if err != nil {
+		return err
+	}
+
+	if values.Activation == config.ActivationDown {
+		if len(values.Routes) > 0 {
+			slog.Debug("link is down, skip routes", "name", name)
 		}
+		return nil
+	}
 
-		if ip.InNetns() {
-			slog.Info("add addresses", "name", name, "address", address, "netns", ip.Netns())
-		} else {
-			slog.Info("add addresses", "name", name, "address", address)
+	return SetupRoutes(ip, name, values.Routes, netnsRouteTables(ip))
+}
+
+func SetLinkProperties(ip IpCommand, name string, values config.Ethernet) error {
+	link, err := ip.ShowLink(name)
+	if err != nil {
+		return err
+	}
+
+	if values.Mtu != 0 && values.Mtu != link.Mtu {
+		logWithNetns(ip, "set mtu", "name", name, "current", link.Mtu, "mtu", values.Mtu)
+		err := ip.SetLinkMtu(name, values.Mtu)
+		if err != nil {
+			return err
 		}
+	}
 
-		err = ip.AddAddress(name, address)
+	if values.MacAddress != "" && !strings.EqualFold(values.MacAddress, link.Address) {
+		logWithNetns(ip, "set mac address", "name", name, "current", link.Address, "macaddress", values.MacAddress)
+		err := ip.SetLinkAddress(name, values.MacAddress)
 		if err != nil {
 			return err
 		}
 	}
 
-	rt, err := ip.ShowRoutes(name)
-	if err != nil {
-		return err
+	if values.TxQueueLen != 0 && values.TxQueueLen != link.Txqlen {
+		logWithNetns(ip, "set txqueuelen", "name", name, "current", link.Txqlen, "txqueuelen", values.TxQueueLen)
+		err := ip.SetLinkTxQueueLen(name, values.TxQueueLen)
+		if err != nil {
+			return err
+		}
 	}
 
-	for _, route := range routes {
-		slog.Debug("route", "name", name, "to", route.To, "via", route.Via, "rt", rt)
-		if slices.ContainsFunc(rt, func(r iproute2.Route) bool {
-			return r.Dst == route.To && r.Gateway == route.Via
-		}) {
-			slog.Debug("route is already exists", "name", name, "to", route.To, "via", route.Via)
-			continue
+	if values.Alias != "" && values.Alias != link.IfAlias {
+		logWithNetns(ip, "set alias", "name", name, "current", link.IfAlias, "alias", values.Alias)
+		err := ip.SetLinkAlias(name, values.Alias)
+		if err != nil {
+			return err
 		}
+	}
 
-		if ip.InNetns() {
-			slog.Info("add route", "name", name, "to", route.To, "via", route.Via, "netns", ip.Netns())
-		} else {
-			slog.Info("add route", "name", name, "to", route.To, "via", route.Via)
+	if values.Group != "" && !sameLinkGroup(values.Group, link.Group) {
+		logWithNetns(ip, "set group", "name", name, "current", link.Group, "group", values.Group)
+		err := ip.SetLinkGroup(name, values.Group)
+		if err != nil {
+			return err
 		}
-		err := ip.AddRoute(name, route.To, route.Via)
+	}
+
+	for _, altname := range values.AltNames {
+		if slices.Contains(link.AltNames, altname) {
+			slog.Debug("altname is already exists", "name", name, "altname", altname)
+			continue
+		}
+
+		logWithNetns(ip, "add altname", "name", name, "altname", altname)
+		err := ip.AddLinkAltName(name, altname)
 		if err != nil {
 			return err
 		}
 	}
+
 	return nil
 }
 
+func sameLinkGroup(a, b string) bool {
+	// group 0 is displayed as "default"
+	normalize := func(g string) string {
+		if g == "0" {
+			return "default"
+		}
+		return g
+	}
+	return normalize(a) == normalize(b)
+}
+
+func logWithNetns(ip IpCommand, msg string, args ...any) {
+	if ip.InNetns() {
+		args = append(args, "netns", ip.Netns())
+	}
+	slog.Info(msg, args...)
+}
+
 func SetLinkUp(ip IpCommand, name string) error {
 	link, err := ip.ShowLink(name)
 	if err != nil {
@@ -179,6 +443,46 @@ func SetLinkUp(ip IpCommand, name string) error {
 	return ip.SetLinkUp(name)
 }
 
+func SetLinkDown(ip IpCommand, name string) error {
+	link, err := ip.ShowLink(name)
+	if err != nil {
+		return err
+	}
+
+	if !slices.Contains(link.Flags, "UP") {
+		slog.Debug("link is already down", "name", name)
+		return nil
+	}
+
+	if ip.InNetns() {
+		slog.Info("link down", "name", name, "netns", ip.Netns())
+	} else {
+		slog.Info("link down", "name", name)
+	}
+
+	return ip.SetLinkDown(name)
+}
+
+func SetLinkMaster(ip IpCommand, name string, master string) error {
+	link, err := ip.ShowLink(name)
+	if err != nil {
+		return err
+	}
+
+	if link.Master == master {
+		slog.Debug("link is already attached to bridge", "name", name, "bridge", master)
+		return nil
+	}
+
+	if ip.InNetns() {
+		slog.Info("attach link to bridge", "name", name, "bridge", master, "netns", ip.Netns())
+	} else {
+		slog.Info("attach link to bridge", "name", name, "bridge", master)
+	}
+
+	return ip.SetLinkMaster(name, master)
+}
+
 func SetNetns(name string, netns string) error {
 	slog.Info("set netns", "name", name, "netns", netns)
 	return ip.SetNetns(name, netns)
@@ -188,29 +492,55 @@ func SetupLoopback(netns string, ethernet config.Ethernet) error {
 	slog.Debug("setup loopback device", "netns", netns, "addresses", ethernet.Addresses, "routes", ethernet.Routes)
 
 	n := ip.IntoNetns(netns)
-	return SetupDevice(n, "lo", ethernet.Addresses, ethernet.Routes)
+	return SetupDevice(n, "lo", ethernet)
 }
 
 func SetupEthernets(netns string, ethernets map[string]config.Ethernet) error {
 	n := ip.IntoNetns(netns)
-	for name, values := range ethernets {
-		slog.Debug("setup ethernet device", "netns", netns, "name", name, "addresses", values.Addresses, "routes", values.Routes)
+	for id, values := range ethernets {
+		name := id
+		if values.SetName != "" {
+			name = values.SetName
+		}
+		slog.Debug("setup ethernet device", "netns", netns, "name", name, "match", values.Match, "addresses", values.Addresses, "routes", values.Routes)
 
 		_, err := n.ShowLink(name)
 		if err == nil {
 			slog.Debug("device is already exists in netns", "name", name, "netns", netns)
 		} else {
 			if _, ok := err.(*iproute2.NotExistError); ok {
-				err := SetNetns(name, netns)
+				hostName := id
+				if values.Match != nil {
+					hostName, err = FindLink(ip, *values.Match)
+				} else {
+					_, err = ip.ShowLink(hostName)
+				}
+				if err != nil {
+					if _, ok := err.(*iproute2.NotExistError); ok && values.Optional {
+						slog.Warn("optional device is not found, skipped", "name", name, "netns", netns)
+						continue
+					}
+					return err
+				}
+
+				err := SetNetns(hostName, netns)
 				if err != nil {
 					return err
 				}
+
+				if hostName != name {
+					slog.Info("rename device", "name", hostName, "new name", name, "netns", netns)
+					err := n.SetLinkName(hostName, name)
+					if err != nil {
+						return err
+					}
+				}
 			} else {
 				return err
 			}
 		}
 
-		err = SetupDevice(n, name, values.Addresses, values.Routes)
+		err = SetupDevice(n, name, values)
 		if err != nil {
 			return err
 		}
@@ -218,6 +548,82 @@ func SetupEthernets(netns string, ethernets map[string]config.Ethernet) error {
 	return nil
 }
 
+func FindLink(ip IpCommand, match config.Match) (string, error) {
+	links, err := ip.ListLinkDetails()
+	if err != nil {
+		return "", err
+	}
+
+	var found []string
+	for _, link := range links {
+		if matchLink(link, match) {
+			found = append(found, link.Ifname)
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return "", &iproute2.NotExistError{Msg: fmt.Sprintf("no device matches %+v", match)}
+	case 1:
+		slog.Debug("found matching device", "name", found[0], "match", match)
+		return found[0], nil
+	default:
+		return "", fmt.Errorf("multiple devices match %+v: %s", match, strings.Join(found, ", "))
+	}
+}
+
+func matchLink(link iproute2.Link, match config.Match) bool {
+	if !isPhysicalLink(link) {
+		return false
+	}
+
+	if match.Name != "" {
+		if ok, _ := filepath.Match(match.Name, link.Ifname); !ok {
+			return false
+		}
+	}
+
+	if match.MacAddress != "" && !strings.EqualFold(match.MacAddress, link.Address) {
+		return false
+	}
+
+	if match.Driver != "" {
+		if ok, _ := filepath.Match(match.Driver, linkDriver(link.Ifname)); !ok {
+			return false
+		}
+	}
+
+	if match.Path != "" {
+		if link.ParentBus == "" || link.ParentDev == "" {
+			return false
+		}
+		// same format as udev's ID_PATH (e.g. pci-0000:00:1f.6)
+		path := fmt.Sprintf("%s-%s", link.ParentBus, link.ParentDev)
+		if ok, _ := filepath.Match(match.Path, path); !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isPhysicalLink reports whether link is an ethernet device that is not
+// created by a link type such as veth, bridge or vlan.
+func isPhysicalLink(link iproute2.Link) bool {
+	if link.LinkType != "ether" {
+		return false
+	}
+	return link.LinkInfo == nil || link.LinkInfo.InfoKind == ""
+}
+
+func linkDriver(name string) string {
+	driver, err := os.Readlink(filepath.Join("/sys/class/net", name, "device", "driver"))
+	if err != nil {
+		return ""
+	}
+	return filepath.Base(driver)
+}
+
 func SetupDummyDevices(netns string, devices map[string]config.Ethernet) error {
 	n := ip.IntoNetns(netns)
 	for name, values := range devices {
@@ -238,7 +644,88 @@ func SetupDummyDevices(netns string, devices map[string]config.Ethernet) error {
 			}
 		}
 
-		err = SetupDevice(n, name, values.Addresses, values.Routes)
+		err = SetupDevice(n, name, values)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func SetupGeneveDevices(netns string, devices map[string]config.Geneve) error {
+	n := ip.IntoNetns(netns)
+	for name, values := range devices {
+		slog.Debug("setup geneve device", "netns", netns, "name", name, "id", values.ID, "remote", values.Remote,
+			"external", values.External, "addresses", values.Addresses, "routes", values.Routes)
+
+		_, err := n.ShowLink(name)
+		if err == nil {
+			slog.Debug("device is already exists in netns", "name", name, "netns", netns)
+		} else {
+			if _, ok := err.(*iproute2.NotExistError); !ok {
+				return err
+			} else {
+				slog.Info("add geneve device", "name", name, "netns", netns)
+				err := n.AddGeneveDevice(name, geneveOptions(values)...)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		err = SetupDevice(n, name, values.Ethernet)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func geneveOptions(g config.Geneve) []string {
+	var options []string
+	if g.External {
+		// collect-metadata mode: VNI and remote are supplied per packet
+		options = append(options, "external")
+	} else {
+		options = append(options, "id", strconv.Itoa(g.ID))
+		if g.Remote != "" {
+			options = append(options, "remote", g.Remote)
+		}
+	}
+	if g.DstPort != 0 {
+		options = append(options, "dstport", strconv.Itoa(g.DstPort))
+	}
+	if g.TTL != "" {
+		options = append(options, "ttl", g.TTL)
+	}
+	if g.TOS != "" {
+		options = append(options, "tos", g.TOS)
+	}
+	return options
+}
+
+func SetupBareUDPDevices(netns string, devices map[string]config.BareUDP) error {
+	n := ip.IntoNetns(netns)
+	for name, values := range devices {
+		slog.Debug("setup bareudp device", "netns", netns, "name", name, "dstport", values.DstPort, "ethertype", values.EtherType,
+			"addresses", values.Addresses, "routes", values.Routes)
+
+		_, err := n.ShowLink(name)
+		if err == nil {
+			slog.Debug("device is already exists in netns", "name", name, "netns", netns)
+		} else {
+			if _, ok := err.(*iproute2.NotExistError); !ok {
+				return err
+			} else {
+				slog.Info("add bareudp device", "name", name, "netns", netns)
+				err := n.AddBareUDPDevice(name, bareUDPOptions(values)...)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		err = SetupDevice(n, name, values.Ethernet)
 		if err != nil {
 			return err
 		}
@@ -246,6 +733,27 @@ func SetupDummyDevices(netns string, devices map[string]config.Ethernet) error {
 	return nil
 }
 
+func bareUDPOptions(b config.BareUDP) []string {
+	// defaults to MPLS-in-UDP (RFC 7510)
+	dstPort := b.DstPort
+	if dstPort == 0 {
+		dstPort = 6635
+	}
+	etherType := b.EtherType
+	if etherType == "" {
+		etherType = "mpls_uc"
+	}
+
+	options := []string{"dstport", strconv.Itoa(dstPort), "ethertype", etherType}
+	if b.SrcPortMin != 0 {
+		options = append(options, "srcportmin", strconv.Itoa(b.SrcPortMin))
+	}
+	if b.MultiProto {
+		options = append(options, "multiproto")
+	}
+	return options
+}
+
 func SetupVethDevices(netns string, devices map[string]config.VethDevice) error {
 	n := ip.IntoNetns(netns)
 	for name, values := range devices {
@@ -253,7 +761,8 @@ func SetupVethDevices(netns string, devices map[string]config.VethDevice) error
 		peerNetns := values.Peer.Netns
 
 		slog.Debug("setup veth device", "netns", netns, "name", name, "addresses", values.Addresses, "routes", values.Routes,
-			"peer name", peerName, "peer netns", peerNetns, "peer addresses", values.Peer.Addresses, "peer routes", values.Peer.Routes)
+			"peer name", peerName, "peer netns", peerNetns, "peer bridge", values.Peer.Bridge,
+			"peer addresses", values.Peer.Addresses, "peer routes", values.Peer.Routes)
 
 		// check if device is already exists in netns
 		_, err := n.ShowLink(name)
@@ -286,7 +795,7 @@ func SetupVethDevices(netns string, devices map[string]config.VethDevice) error
 			}
 		}
 
-		err = SetupDevice(n, name, values.Addresses, values.Routes)
+		err = SetupDevice(n, name, values.Ethernet)
 		if err != nil {
 			return err
 		}
@@ -307,12 +816,37 @@ func SetupVethDevices(netns string, devices map[string]config.VethDevice) error
 					}
 				}
 			}
-			err = SetupDevice(n, peerName, values.Peer.Addresses, values.Peer.Routes)
+
+			if values.Peer.Bridge != "" {
+				err = SetLinkMaster(n, peerName, values.Peer.Bridge)
+				if err != nil {
+					return err
+				}
+			}
+
+			err = SetupDevice(n, peerName, values.Peer.Ethernet)
+			if err != nil {
+				return err
+			}
+
+			err = SetupBridgePort(n, peerName, values.Peer)
 			if err != nil {
 				return err
 			}
 		} else {
-			err = SetupDevice(ip, peerName, values.Peer.Addresses, values.Peer.Routes)
+			if values.Peer.Bridge != "" {
+				err = SetLinkMaster(ip, peerName, values.Peer.Bridge)
+				if err != nil {
+					return err
+				}
+			}
+
+			err = SetupDevice(ip, peerName, values.Peer.Ethernet)
+			if err != nil {
+				return err
+			}
+
+			err = SetupBridgePort(ip, peerName, values.Peer)
 			if err != nil {
 				return err
 			}
@@ -0,0 +1,476 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"netnsplan/config"
+	"netnsplan/iproute2"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// fakeIp records the link changes made through it.
+type fakeIp struct {
+	fakeLinks
+	calls []string
+}
+
+func (f *fakeIp) record(format string, args ...any) error {
+	f.calls = append(f.calls, fmt.Sprintf(format, args...))
+	return nil
+}
+
+func (f *fakeIp) SetLinkMtu(name string, mtu int) error {
+	return f.record("mtu %s %d", name, mtu)
+}
+
+func (f *fakeIp) SetLinkAddress(name, address string) error {
+	return f.record("address %s %s", name, address)
+}
+
+func (f *fakeIp) SetLinkTxQueueLen(name string, txqueuelen int) error {
+	return f.record("txqueuelen %s %d", name, txqueuelen)
+}
+
+func (f *fakeIp) SetLinkAlias(name, alias string) error {
+	return f.record("alias %s %s", name, alias)
+}
+
+func (f *fakeIp) SetLinkGroup(name, group string) error {
+	return f.record("group %s %s", name, group)
+}
+
+func (f *fakeIp) AddLinkAltName(name, altname string) error {
+	return f.record("altname %s %s", name, altname)
+}
+
+func (f *fakeIp) SetLinkMaster(name, master string) error {
+	f.links[name].Master = master
+	return f.record("master %s %s", name, master)
+}
+
+func (f *fakeIp) SetLinkNoMaster(name string) error {
+	f.links[name].Master = ""
+	return f.record("nomaster %s", name)
+}
+
+func (f *fakeLinks) ListLinkDetails() (iproute2.Links, error) {
+	var links iproute2.Links
+	for _, link := range f.links {
+		links = append(links, *link)
+	}
+	slices.SortFunc(links, func(a, b iproute2.Link) int { return a.Ifindex - b.Ifindex })
+	return links, nil
+}
+
+func TestSameLinkGroup(t *testing.T) {
+	testCases := []struct {
+		a, b     string
+		expected bool
+	}{
+		{"default", "default", true},
+		{"0", "default", true},
+		{"default", "0", true},
+		{"10", "10", true},
+		{"10", "default", false},
+		{"10", "20", false},
+	}
+
+	for _, tc := range testCases {
+		if got := sameLinkGroup(tc.a, tc.b); got != tc.expected {
+			t.Errorf("sameLinkGroup(%q, %q) = %v, want %v", tc.a, tc.b, got, tc.expected)
+		}
+	}
+}
+
+func TestSetLinkProperties(t *testing.T) {
+	link := &iproute2.Link{
+		Ifname:   "eth0",
+		Mtu:      1500,
+		Address:  "02:00:00:00:00:0a",
+		Txqlen:   1000,
+		IfAlias:  "uplink",
+		Group:    "default",
+		AltNames: []string{"eth0-alt"},
+	}
+
+	testCases := []struct {
+		desc     string
+		values   config.Ethernet
+		expected []string
+	}{
+		{
+			desc:     "Nothing is set",
+			values:   config.Ethernet{},
+			expected: nil,
+		},
+		{
+			desc: "Up to date",
+			values: config.Ethernet{
+				Mtu:        1500,
+				MacAddress: "02:00:00:00:00:0A",
+				TxQueueLen: 1000,
+				Alias:      "uplink",
+				Group:      "0",
+				AltNames:   []string{"eth0-alt"},
+			},
+			expected: nil,
+		},
+		{
+			desc: "Drifted",
+			values: config.Ethernet{
+				Mtu:        9000,
+				MacAddress: "02:00:00:00:00:0b",
+				TxQueueLen: 500,
+				Alias:      "test",
+				Group:      "10",
+				AltNames:   []string{"eth0-alt", "eth0-new"},
+			},
+			expected: []string{
+				"mtu eth0 9000",
+				"address eth0 02:00:00:00:00:0b",
+				"txqueuelen eth0 500",
+				"alias eth0 test",
+				"group eth0 10",
+				"altname eth0 eth0-new",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			ip := &fakeIp{fakeLinks: fakeLinks{links: map[string]*iproute2.Link{"eth0": link}}}
+			if err := SetLinkProperties(ip, "eth0", tc.values); err != nil {
+				t.Fatalf("SetLinkProperties() error = %v", err)
+			}
+			if !reflect.DeepEqual(ip.calls, tc.expected) {
+				t.Errorf("SetLinkProperties() calls = %v, want %v", ip.calls, tc.expected)
+			}
+		})
+	}
+}
+
+func (f *fakeIp) SetLinkUp(name string) error {
+	f.links[name].Flags = append(f.links[name].Flags, "UP")
+	return f.record("up %s", name)
+}
+
+func (f *fakeIp) SetLinkDown(name string) error {
+	f.links[name].Flags = slices.DeleteFunc(f.links[name].Flags, func(flag string) bool { return flag == "UP" })
+	return f.record("down %s", name)
+}
+
+func (f *fakeIp) ShowInterface(name string) (*iproute2.InterfaceInfo, error) {
+	return &iproute2.InterfaceInfo{Ifname: name}, nil
+}
+
+func (f *fakeIp) ListNeighbors(name string) (iproute2.Neighbors, error) {
+	return nil, nil
+}
+
+func (f *fakeIp) ListProxyNeighbors(name string) (iproute2.Neighbors, error) {
+	return nil, nil
+}
+
+func (f *fakeIp) ListRoutes(family string) (iproute2.Routes, error) {
+	return nil, nil
+}
+
+func (f *fakeIp) AddRoute(name, to, via string, options ...string) error {
+	return f.record("route %s %s via %s", name, to, via)
+}
+
+// Tc returns a tc command that is never run, since the devices have no
+// netem.
+func (f *fakeIp) Tc(path string) *iproute2.TcCmd {
+	return new(iproute2.BaseCommand).Tc(path)
+}
+
+func TestSetupDeviceActivation(t *testing.T) {
+	routes := []config.Route{{To: "10.1.0.0/16", Via: "10.0.0.254"}}
+
+	testCases := []struct {
+		desc         string
+		name         string
+		up           bool
+		values       config.Ethernet
+		expected     []string
+		expectingErr bool
+	}{
+		{
+			desc:     "Up by default",
+			name:     "eth0",
+			values:   config.Ethernet{Routes: routes},
+			expected: []string{"up eth0", "route eth0 10.1.0.0/16 via 10.0.0.254"},
+		},
+		{
+			desc:     "Already up",
+			name:     "eth0",
+			up:       true,
+			values:   config.Ethernet{Activation: config.ActivationUp},
+			expected: nil,
+		},
+		{
+			desc:     "Down skips routes",
+			name:     "eth0",
+			up:       true,
+			values:   config.Ethernet{Activation: config.ActivationDown, Routes: routes},
+			expected: []string{"down eth0"},
+		},
+		{
+			desc:     "Already down",
+			name:     "eth0",
+			values:   config.Ethernet{Activation: config.ActivationDown},
+			expected: nil,
+		},
+		{
+			desc:     "Manual leaves the link state",
+			name:     "eth0",
+			values:   config.Ethernet{Activation: config.ActivationManual, Routes: routes},
+			expected: []string{"route eth0 10.1.0.0/16 via 10.0.0.254"},
+		},
+		{
+			desc:         "Unknown activation",
+			name:         "eth0",
+			values:       config.Ethernet{Activation: "off"},
+			expectingErr: true,
+		},
+		{
+			desc:     "Optional device is missing",
+			name:     "eth1",
+			values:   config.Ethernet{Optional: true, Mtu: 9000},
+			expected: nil,
+		},
+		{
+			desc:         "Device is missing",
+			name:         "eth1",
+			values:       config.Ethernet{Mtu: 9000},
+			expectingErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			link := &iproute2.Link{Ifname: "eth0", Flags: []string{"BROADCAST", "MULTICAST"}}
+			if tc.up {
+				link.Flags = append(link.Flags, "UP")
+			}
+			ip := &fakeIp{fakeLinks: fakeLinks{links: map[string]*iproute2.Link{"eth0": link}}}
+
+			err := SetupDevice(ip, tc.name, tc.values)
+			if (err != nil) != tc.expectingErr {
+				t.Fatalf("SetupDevice() error = %v, expectingErr %v", err, tc.expectingErr)
+			}
+			if !reflect.DeepEqual(ip.calls, tc.expected) {
+				t.Errorf("SetupDevice() calls = %v, want %v", ip.calls, tc.expected)
+			}
+		})
+	}
+}
+
+func TestSetLinkMaster(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		master   string
+		expected []string
+	}{
+		{
+			desc:     "Not attached",
+			master:   "",
+			expected: []string{"master veth1 br0"},
+		},
+		{
+			desc:     "Already attached",
+			master:   "br0",
+			expected: nil,
+		},
+		{
+			desc:     "Attached to another bridge",
+			master:   "br1",
+			expected: []string{"master veth1 br0"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			f := &fakeIp{fakeLinks: fakeLinks{links: map[string]*iproute2.Link{
+				"veth1": {Ifname: "veth1", Master: tc.master},
+			}}}
+			if err := SetLinkMaster(f, "veth1", "br0"); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(f.calls, tc.expected) {
+				t.Errorf("calls = %v, want %v", f.calls, tc.expected)
+			}
+		})
+	}
+
+	f := &fakeIp{fakeLinks: fakeLinks{links: map[string]*iproute2.Link{}}}
+	if err := SetLinkMaster(f, "veth1", "br0"); err == nil {
+		t.Error("SetLinkMaster() on a missing link should fail")
+	}
+}
+
+func TestFindLink(t *testing.T) {
+	f := &fakeLinks{links: map[string]*iproute2.Link{
+		"lo":     {Ifindex: 1, Ifname: "lo", LinkType: "loopback", Address: "00:00:00:00:00:00"},
+		"enp1s0": {Ifindex: 2, Ifname: "enp1s0", LinkType: "ether", Address: "52:54:00:12:34:5a", ParentBus: "pci", ParentDev: "0000:01:00.0"},
+		"enp2s0": {Ifindex: 3, Ifname: "enp2s0", LinkType: "ether", Address: "52:54:00:12:34:57", ParentBus: "pci", ParentDev: "0000:02:00.0"},
+		"veth0":  {Ifindex: 4, Ifname: "veth0", LinkType: "ether", Address: "52:54:00:12:34:58", LinkInfo: &iproute2.LinkInfo{InfoKind: "veth"}},
+		"br0":    {Ifindex: 5, Ifname: "br0", LinkType: "ether", Address: "52:54:00:12:34:59", LinkInfo: &iproute2.LinkInfo{InfoKind: "bridge"}},
+	}}
+
+	testCases := []struct {
+		desc     string
+		match    config.Match
+		expected string
+		err      string
+	}{
+		{
+			desc:     "MAC address",
+			match:    config.Match{MacAddress: "52:54:00:12:34:57"},
+			expected: "enp2s0",
+		},
+		{
+			desc:     "MAC address in upper case",
+			match:    config.Match{MacAddress: "52:54:00:12:34:5A"},
+			expected: "enp1s0",
+		},
+		{
+			desc:     "Path",
+			match:    config.Match{Path: "pci-0000:02:*"},
+			expected: "enp2s0",
+		},
+		{
+			desc:     "Name and path",
+			match:    config.Match{Name: "enp*", Path: "pci-0000:01:00.0"},
+			expected: "enp1s0",
+		},
+		{
+			desc:  "Glob name matches physical devices only",
+			match: config.Match{Name: "*"},
+			err:   "multiple devices match",
+		},
+		{
+			desc:  "MAC address of a veth",
+			match: config.Match{MacAddress: "52:54:00:12:34:58"},
+			err:   "no device matches",
+		},
+		{
+			desc:  "Name of a bridge",
+			match: config.Match{Name: "br*"},
+			err:   "no device matches",
+		},
+		{
+			desc:  "Loopback",
+			match: config.Match{Name: "lo"},
+			err:   "no device matches",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			name, err := FindLink(f, tc.match)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("FindLink() error = %v, want %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if name != tc.expected {
+				t.Errorf("FindLink() = %q, want %q", name, tc.expected)
+			}
+		})
+	}
+
+	_, err := FindLink(f, config.Match{Name: "*"})
+	if err == nil || !strings.Contains(err.Error(), "enp1s0, enp2s0") {
+		t.Errorf("FindLink() error = %v, want both physical devices", err)
+	}
+}
+
+func TestGeneveOptions(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		geneve   config.Geneve
+		expected []string
+	}{
+		{
+			desc:     "VNI only",
+			geneve:   config.Geneve{ID: 100},
+			expected: []string{"id", "100"},
+		},
+		{
+			desc:   "All options",
+			geneve: config.Geneve{ID: 100, Remote: "192.0.2.2", DstPort: 6082, TTL: "64", TOS: "inherit"},
+			expected: []string{
+				"id", "100", "remote", "192.0.2.2", "dstport", "6082", "ttl", "64", "tos", "inherit",
+			},
+		},
+		{
+			desc:     "External ignores VNI and remote",
+			geneve:   config.Geneve{ID: 100, Remote: "192.0.2.2", DstPort: 6082, External: true},
+			expected: []string{"external", "dstport", "6082"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			if got := geneveOptions(tc.geneve); !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("geneveOptions() = %v, want %v", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestBareUDPOptions(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		bareUDP  config.BareUDP
+		expected []string
+	}{
+		{
+			desc:     "MPLS-in-UDP by default",
+			bareUDP:  config.BareUDP{},
+			expected: []string{"dstport", "6635", "ethertype", "mpls_uc"},
+		},
+		{
+			desc:     "All options",
+			bareUDP:  config.BareUDP{DstPort: 6081, EtherType: "ipv4", SrcPortMin: 49152, MultiProto: true},
+			expected: []string{"dstport", "6081", "ethertype", "ipv4", "srcportmin", "49152", "multiproto"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			if got := bareUDPOptions(tc.bareUDP); !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("bareUDPOptions() = %v, want %v", got, tc.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,216 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"netnsplan/config"
+	"netnsplan/iproute2"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// BridgeCommand is the bridge command that the VLANs and the FDB of a
+// bridge port are managed with.
+type BridgeCommand interface {
+	ListVlans(name string) ([]iproute2.BridgeVlan, error)
+	AddVlan(name string, vid int, options ...string) error
+	DelVlan(name string, vid int) error
+	ListFdb(name string) (iproute2.FdbEntries, error)
+	ReplaceFdb(name string, mac string, options ...string) error
+	DelFdb(name string, mac string, options ...string) error
+}
+
+// SetupBridgePort sets the VLANs and the static FDB entries of a peer
+// that is attached to a bridge.
+func SetupBridgePort(ip IpCommand, name string, peer config.Peer) error {
+	if peer.Bridge == "" {
+		if len(peer.Vlans) > 0 || len(peer.Fdb) > 0 {
+			return fmt.Errorf("vlans and fdb of %s require a bridge", name)
+		}
+		return nil
+	}
+
+	bridge := ip.Bridge(flags.BridgeCmdPath)
+	err := SetBridgeVlans(ip, bridge, name, peer.Vlans)
+	if err != nil {
+		return err
+	}
+
+	return SetupFdb(ip, bridge, name, peer.Fdb)
+}
+
+// SetBridgeVlans makes the VLANs of the port match the config. The VLANs
+// that are not in the config, including the default VLAN 1, are removed
+// from the port. Once vlans is removed from the config, the port is put
+// back to the default VLAN 1.
+func SetBridgeVlans(ip IpCommand, bridge BridgeCommand, name string, vlans []config.BridgeVlan) error {
+	installed, err := readDeviceState(ip, "bridge-vlans", name)
+	if err != nil {
+		return err
+	}
+	restore := len(vlans) == 0
+	if restore {
+		if len(installed) == 0 {
+			return nil
+		}
+		// the default of a new port
+		vlans = []config.BridgeVlan{{Vid: 1, PVID: true, Untagged: true}}
+	}
+
+	current, err := bridge.ListVlans(name)
+	if err != nil {
+		return err
+	}
+
+	pvids := 0
+	for _, vlan := range vlans {
+		if vlan.Vid < 1 || vlan.Vid > 4094 {
+			return fmt.Errorf("invalid vid %d for %s", vlan.Vid, name)
+		}
+		if vlan.PVID {
+			pvids++
+		}
+	}
+	if pvids > 1 {
+		return fmt.Errorf("%s has more than one pvid", name)
+	}
+
+	for _, vlan := range vlans {
+		i := slices.IndexFunc(current, func(v iproute2.BridgeVlan) bool {
+			return v.Vlan == vlan.Vid
+		})
+		if i >= 0 && !bridgeVlanDrifted(current[i], vlan) {
+			slog.Debug("vlan is already exists", "name", name, "vid", vlan.Vid)
+			continue
+		}
+
+		var options []string
+		if vlan.PVID {
+			options = append(options, "pvid")
+		}
+		if vlan.Untagged {
+			options = append(options, "untagged")
+		}
+		logWithNetns(ip, "add vlan", "name", name, "vid", vlan.Vid, "pvid", vlan.PVID, "untagged", vlan.Untagged)
+		err = bridge.AddVlan(name, vlan.Vid, options...)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, v := range current {
+		if slices.ContainsFunc(vlans, func(vlan config.BridgeVlan) bool {
+			return vlan.Vid == v.Vlan
+		}) {
+			continue
+		}
+		logWithNetns(ip, "delete vlan", "name", name, "vid", v.Vlan)
+		if err := bridge.DelVlan(name, v.Vlan); err != nil {
+			return err
+		}
+	}
+
+	var vids []string
+	if !restore {
+		for _, vlan := range vlans {
+			vids = append(vids, strconv.Itoa(vlan.Vid))
+		}
+	}
+	return writeDeviceState(ip, "bridge-vlans", name, vids)
+}
+func bridgeVlanDrifted(v iproute2.BridgeVlan, vlan config.BridgeVlan) bool {
+	return slices.Contains(v.Flags, iproute2.BridgeVlanPVID) != vlan.PVID ||
+		slices.Contains(v.Flags, iproute2.BridgeVlanUntagged) != vlan.Untagged
+}
+
+// SetupFdb adds the static FDB entries of the port to its bridge and
+// removes the ones it has added before that are no longer in the config.
+// Entries added by others or learned by the bridge are left alone.
+func SetupFdb(ip IpCommand, bridge BridgeCommand, name string, entries []config.FdbEntry) error {
+	installed, err := readDeviceState(ip, "fdb", name)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 && len(installed) == 0 {
+		return nil
+	}
+
+	current, err := bridge.ListFdb(name)
+	if err != nil {
+		return err
+	}
+	// only the entries of the bridge, not the ones of the port itself
+	current = slices.DeleteFunc(current, func(e iproute2.FdbEntry) bool {
+		return e.Master == ""
+	})
+
+	var keys []string
+	for _, entry := range entries {
+		keys = append(keys, fdbKey(entry.Mac, entry.Vlan))
+
+		i := slices.IndexFunc(current, func(e iproute2.FdbEntry) bool {
+			return sameFdbEntry(e, entry)
+		})
+		if i >= 0 && current[i].State == "static" {
+			slog.Debug("fdb entry is already exists", "name", name, "mac", entry.Mac, "vlan", entry.Vlan)
+			continue
+		}
+
+		logWithNetns(ip, "add fdb entry", "name", name, "mac", entry.Mac, "vlan", entry.Vlan)
+		err = bridge.ReplaceFdb(name, entry.Mac, fdbOptions(entry.Vlan)...)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, e := range current {
+		key := fdbKey(e.Mac, e.Vlan)
+		if e.State != "static" || !slices.Contains(installed, key) || slices.Contains(keys, key) {
+			continue
+		}
+		logWithNetns(ip, "delete fdb entry", "name", name, "mac", e.Mac, "vlan", e.Vlan)
+		if err := bridge.DelFdb(name, e.Mac, fdbOptions(e.Vlan)...); err != nil {
+			return err
+		}
+	}
+	return writeDeviceState(ip, "fdb", name, keys)
+}
+
+// fdbKey identifies an FDB entry in the state of the port.
+func fdbKey(mac string, vlan int) string {
+	return strings.ToLower(mac) + " " + strconv.Itoa(vlan)
+}
+
+func fdbOptions(vlan int) []string {
+	options := []string{"master", "static"}
+	if vlan != 0 {
+		options = append(options, "vlan", strconv.Itoa(vlan))
+	}
+	return options
+}
+
+func sameFdbEntry(e iproute2.FdbEntry, entry config.FdbEntry) bool {
+	return strings.EqualFold(e.Mac, entry.Mac) && e.Vlan == entry.Vlan
+}
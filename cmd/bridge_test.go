@@ -0,0 +1,282 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"netnsplan/config"
+	"netnsplan/iproute2"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// fakeBridge keeps the VLANs and the FDB entries of a port and records
+// the changes.
+type fakeBridge struct {
+	vlans []iproute2.BridgeVlan
+	fdb   iproute2.FdbEntries
+	calls []string
+}
+
+func (f *fakeBridge) ListVlans(name string) ([]iproute2.BridgeVlan, error) {
+	return slices.Clone(f.vlans), nil
+}
+
+func (f *fakeBridge) AddVlan(name string, vid int, options ...string) error {
+	f.vlans = slices.DeleteFunc(f.vlans, func(v iproute2.BridgeVlan) bool { return v.Vlan == vid })
+	vlan := iproute2.BridgeVlan{Vlan: vid}
+	if slices.Contains(options, "pvid") {
+		vlan.Flags = append(vlan.Flags, iproute2.BridgeVlanPVID)
+	}
+	if slices.Contains(options, "untagged") {
+		vlan.Flags = append(vlan.Flags, iproute2.BridgeVlanUntagged)
+	}
+	f.vlans = append(f.vlans, vlan)
+	f.calls = append(f.calls, fmt.Sprintf("add vlan %d %v", vid, options))
+	return nil
+}
+
+func (f *fakeBridge) DelVlan(name string, vid int) error {
+	f.vlans = slices.DeleteFunc(f.vlans, func(v iproute2.BridgeVlan) bool { return v.Vlan == vid })
+	f.calls = append(f.calls, fmt.Sprintf("del vlan %d", vid))
+	return nil
+}
+
+func (f *fakeBridge) ListFdb(name string) (iproute2.FdbEntries, error) {
+	return slices.Clone(f.fdb), nil
+}
+
+func (f *fakeBridge) ReplaceFdb(name string, mac string, options ...string) error {
+	entry := iproute2.FdbEntry{Mac: strings.ToLower(mac), Master: "br0", State: "static"}
+	if i := slices.Index(options, "vlan"); i >= 0 {
+		fmt.Sscan(options[i+1], &entry.Vlan)
+	}
+	f.fdb = append(f.fdb, entry)
+	f.calls = append(f.calls, fmt.Sprintf("add fdb %s %d", entry.Mac, entry.Vlan))
+	return nil
+}
+
+func (f *fakeBridge) DelFdb(name string, mac string, options ...string) error {
+	f.fdb = slices.DeleteFunc(f.fdb, func(e iproute2.FdbEntry) bool { return e.Mac == mac })
+	f.calls = append(f.calls, fmt.Sprintf("del fdb %s", mac))
+	return nil
+}
+
+func TestBridgeVlanDrifted(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		current  iproute2.BridgeVlan
+		vlan     config.BridgeVlan
+		expected bool
+	}{
+		{
+			desc:     "Tagged",
+			current:  iproute2.BridgeVlan{Vlan: 10},
+			vlan:     config.BridgeVlan{Vid: 10},
+			expected: false,
+		},
+		{
+			desc:     "PVID and untagged",
+			current:  iproute2.BridgeVlan{Vlan: 10, Flags: []string{iproute2.BridgeVlanPVID, iproute2.BridgeVlanUntagged}},
+			vlan:     config.BridgeVlan{Vid: 10, PVID: true, Untagged: true},
+			expected: false,
+		},
+		{
+			desc:     "Becomes PVID",
+			current:  iproute2.BridgeVlan{Vlan: 10, Flags: []string{iproute2.BridgeVlanUntagged}},
+			vlan:     config.BridgeVlan{Vid: 10, PVID: true, Untagged: true},
+			expected: true,
+		},
+		{
+			desc:     "Becomes tagged",
+			current:  iproute2.BridgeVlan{Vlan: 10, Flags: []string{iproute2.BridgeVlanUntagged}},
+			vlan:     config.BridgeVlan{Vid: 10},
+			expected: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			if got := bridgeVlanDrifted(tc.current, tc.vlan); got != tc.expected {
+				t.Errorf("bridgeVlanDrifted() = %v, want %v", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestSetBridgeVlans(t *testing.T) {
+	defaultVlan := iproute2.BridgeVlan{Vlan: 1, Flags: []string{iproute2.BridgeVlanPVID, iproute2.BridgeVlanUntagged}}
+	vlans := []config.BridgeVlan{{Vid: 10, PVID: true, Untagged: true}, {Vid: 20}}
+
+	testCases := []struct {
+		desc     string
+		steps    [][]config.BridgeVlan
+		expected []string
+		vlans    []iproute2.BridgeVlan
+	}{
+		{
+			desc:     "No vlans",
+			steps:    [][]config.BridgeVlan{nil},
+			expected: nil,
+			vlans:    []iproute2.BridgeVlan{defaultVlan},
+		},
+		{
+			desc:  "Set and applied again",
+			steps: [][]config.BridgeVlan{vlans, vlans},
+			expected: []string{
+				"add vlan 10 [pvid untagged]", "add vlan 20 []", "del vlan 1",
+			},
+			vlans: []iproute2.BridgeVlan{
+				{Vlan: 10, Flags: []string{iproute2.BridgeVlanPVID, iproute2.BridgeVlanUntagged}},
+				{Vlan: 20},
+			},
+		},
+		{
+			desc:  "Edited",
+			steps: [][]config.BridgeVlan{vlans, {{Vid: 10}}},
+			expected: []string{
+				"add vlan 10 [pvid untagged]", "add vlan 20 []", "del vlan 1",
+				"add vlan 10 []", "del vlan 20",
+			},
+			vlans: []iproute2.BridgeVlan{{Vlan: 10}},
+		},
+		{
+			desc:  "Removed",
+			steps: [][]config.BridgeVlan{vlans, nil, nil},
+			expected: []string{
+				"add vlan 10 [pvid untagged]", "add vlan 20 []", "del vlan 1",
+				"add vlan 1 [pvid untagged]", "del vlan 10", "del vlan 20",
+			},
+			vlans: []iproute2.BridgeVlan{defaultVlan},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			useTempRunDir(t)
+			ip := &fakeIp{}
+			bridge := &fakeBridge{vlans: []iproute2.BridgeVlan{defaultVlan}}
+			for _, step := range tc.steps {
+				if err := SetBridgeVlans(ip, bridge, "veth0", step); err != nil {
+					t.Fatalf("SetBridgeVlans() error = %v", err)
+				}
+			}
+			if !reflect.DeepEqual(bridge.calls, tc.expected) {
+				t.Errorf("calls = %v, want %v", bridge.calls, tc.expected)
+			}
+			if !reflect.DeepEqual(bridge.vlans, tc.vlans) {
+				t.Errorf("vlans = %v, want %v", bridge.vlans, tc.vlans)
+			}
+		})
+	}
+
+	for _, vlans := range [][]config.BridgeVlan{
+		{{Vid: 4095}},
+		{{Vid: 10, PVID: true}, {Vid: 20, PVID: true}},
+	} {
+		if err := SetBridgeVlans(&fakeIp{}, &fakeBridge{}, "veth0", vlans); err == nil {
+			t.Errorf("SetBridgeVlans(%v) should fail", vlans)
+		}
+	}
+}
+
+func TestSameFdbEntry(t *testing.T) {
+	testCases := []struct {
+		current  iproute2.FdbEntry
+		entry    config.FdbEntry
+		expected bool
+	}{
+		{iproute2.FdbEntry{Mac: "02:00:00:00:00:01"}, config.FdbEntry{Mac: "02:00:00:00:00:01"}, true},
+		{iproute2.FdbEntry{Mac: "0a:00:00:00:00:01"}, config.FdbEntry{Mac: "0A:00:00:00:00:01"}, true},
+		{iproute2.FdbEntry{Mac: "02:00:00:00:00:01", Vlan: 10}, config.FdbEntry{Mac: "02:00:00:00:00:01", Vlan: 10}, true},
+		{iproute2.FdbEntry{Mac: "02:00:00:00:00:01", Vlan: 10}, config.FdbEntry{Mac: "02:00:00:00:00:01"}, false},
+		{iproute2.FdbEntry{Mac: "02:00:00:00:00:01"}, config.FdbEntry{Mac: "02:00:00:00:00:02"}, false},
+	}
+
+	for _, tc := range testCases {
+		if got := sameFdbEntry(tc.current, tc.entry); got != tc.expected {
+			t.Errorf("sameFdbEntry(%v, %v) = %v, want %v", tc.current, tc.entry, got, tc.expected)
+		}
+	}
+}
+
+func TestSetupFdb(t *testing.T) {
+	other := iproute2.FdbEntry{Mac: "02:00:00:00:00:99", Master: "br0", State: "static"}
+	learned := iproute2.FdbEntry{Mac: "02:00:00:00:00:98", Master: "br0"}
+	own := iproute2.FdbEntry{Mac: "ca:6a:21:26:f6:b2", State: "permanent"}
+	entries := []config.FdbEntry{{Mac: "02:00:00:00:00:01", Vlan: 10}, {Mac: "02:00:00:00:00:02"}}
+
+	testCases := []struct {
+		desc     string
+		steps    [][]config.FdbEntry
+		expected []string
+	}{
+		{
+			desc:     "No entries",
+			steps:    [][]config.FdbEntry{nil},
+			expected: nil,
+		},
+		{
+			desc:     "Added and applied again",
+			steps:    [][]config.FdbEntry{entries, entries},
+			expected: []string{"add fdb 02:00:00:00:00:01 10", "add fdb 02:00:00:00:00:02 0"},
+		},
+		{
+			desc:  "Edited",
+			steps: [][]config.FdbEntry{entries, entries[:1]},
+			expected: []string{
+				"add fdb 02:00:00:00:00:01 10", "add fdb 02:00:00:00:00:02 0",
+				"del fdb 02:00:00:00:00:02",
+			},
+		},
+		{
+			desc:  "Removed",
+			steps: [][]config.FdbEntry{entries, nil, nil},
+			expected: []string{
+				"add fdb 02:00:00:00:00:01 10", "add fdb 02:00:00:00:00:02 0",
+				"del fdb 02:00:00:00:00:01", "del fdb 02:00:00:00:00:02",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			useTempRunDir(t)
+			bridge := &fakeBridge{fdb: iproute2.FdbEntries{own, other, learned}}
+			for _, step := range tc.steps {
+				if err := SetupFdb(&fakeIp{}, bridge, "veth0", step); err != nil {
+					t.Fatalf("SetupFdb() error = %v", err)
+				}
+			}
+			if !reflect.DeepEqual(bridge.calls, tc.expected) {
+				t.Errorf("calls = %v, want %v", bridge.calls, tc.expected)
+			}
+			for _, e := range []iproute2.FdbEntry{own, other, learned} {
+				if !slices.ContainsFunc(bridge.fdb, func(f iproute2.FdbEntry) bool { return f.Mac == e.Mac }) {
+					t.Errorf("entry %v is deleted", e)
+				}
+			}
+		})
+	}
+}
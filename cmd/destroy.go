@@ -23,6 +23,8 @@ package cmd
 
 import (
 	"log/slog"
+	"netnsplan/config"
+	"netnsplan/iproute2"
 
 	"github.com/spf13/cobra"
 )
@@ -33,7 +35,12 @@ var destroyCmd = &cobra.Command{
 	Short: "Destroy netns networks configuration from running system",
 	Long:  "Destroy netns networks configuration from running system",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		for n := range cfg.Netns {
+		for n, values := range cfg.Netns {
+			err := DetachVethPeers(values.VethDevices)
+			if err != nil {
+				return err
+			}
+
 			if ip.ExistsNetns(n) {
 				slog.Info("delete netns", "name", n)
 				err := ip.DelNetns(n)
@@ -43,12 +50,78 @@ var destroyCmd = &cobra.Command{
 			} else {
 				slog.Warn("netns is not exists", "name", n)
 			}
+
+			err = RemoveNetnsEtcDir(n)
+			if err != nil {
+				return err
+			}
+
+			err = RemoveUplink(n)
+			if err != nil {
+				return err
+			}
+
+			err = RemovePortForwards(n)
+			if err != nil {
+				return err
+			}
+
+			err = RemoveNetnsState(n)
+			if err != nil {
+				return err
+			}
 		}
 
-		return nil
+		return SetupHostTopologyHosts(nil)
 	},
 }
 
+func DetachVethPeers(devices map[string]config.VethDevice) error {
+	for _, values := range devices {
+		bridge := values.Peer.Bridge
+		if bridge == "" {
+			continue
+		}
+
+		var n IpCommand = ip
+		if values.Peer.Netns != "" {
+			if !ip.ExistsNetns(values.Peer.Netns) {
+				continue
+			}
+			n = ip.IntoNetns(values.Peer.Netns)
+		}
+
+		err := DetachLink(n, values.Peer.Name, bridge)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func DetachLink(ip IpCommand, name string, master string) error {
+	link, err := ip.ShowLink(name)
+	if err != nil {
+		if _, ok := err.(*iproute2.NotExistError); ok {
+			return nil
+		}
+		return err
+	}
+
+	if link.Master != master {
+		slog.Debug("link is not attached to bridge", "name", name, "bridge", master)
+		return nil
+	}
+
+	if ip.InNetns() {
+		slog.Info("detach link from bridge", "name", name, "bridge", master, "netns", ip.Netns())
+	} else {
+		slog.Info("detach link from bridge", "name", name, "bridge", master)
+	}
+
+	return ip.SetLinkNoMaster(name)
+}
+
 func init() {
 	rootCmd.AddCommand(destroyCmd)
 }
@@ -0,0 +1,75 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package cmd
+
+import (
+	"netnsplan/iproute2"
+	"reflect"
+	"testing"
+)
+
+func TestDetachLink(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		links    map[string]*iproute2.Link
+		expected []string
+	}{
+		{
+			desc: "Attached",
+			links: map[string]*iproute2.Link{
+				"veth1": {Ifname: "veth1", Master: "br0"},
+			},
+			expected: []string{"nomaster veth1"},
+		},
+		{
+			desc: "Not attached",
+			links: map[string]*iproute2.Link{
+				"veth1": {Ifname: "veth1"},
+			},
+			expected: nil,
+		},
+		{
+			desc: "Attached to another bridge",
+			links: map[string]*iproute2.Link{
+				"veth1": {Ifname: "veth1", Master: "br1"},
+			},
+			expected: nil,
+		},
+		{
+			desc:     "Link is gone",
+			links:    map[string]*iproute2.Link{},
+			expected: nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			f := &fakeIp{fakeLinks: fakeLinks{links: tc.links}}
+			if err := DetachLink(f, "veth1", "br0"); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(f.calls, tc.expected) {
+				t.Errorf("calls = %v, want %v", f.calls, tc.expected)
+			}
+		})
+	}
+}
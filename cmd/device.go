@@ -0,0 +1,80 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package cmd
+
+import (
+	"netnsplan/config"
+	"sort"
+)
+
+type Device struct {
+	Netns  string
+	Name   string
+	Values config.Ethernet
+}
+
+// ListDevices returns every device declared in cfg together with the netns
+// it is placed in. Veth peers without a netns are placed in the root netns.
+func ListDevices(cfg *config.Config) []Device {
+	var devices []Device
+	for netns, values := range cfg.Netns {
+		devices = append(devices, Device{Netns: netns, Name: "lo", Values: values.Loopback})
+
+		for id, d := range values.Ethernets {
+			name := id
+			if d.SetName != "" {
+				name = d.SetName
+			}
+			devices = append(devices, Device{Netns: netns, Name: name, Values: d})
+		}
+		for name, d := range values.DummyDevices {
+			devices = append(devices, Device{Netns: netns, Name: name, Values: d})
+		}
+		for name, d := range values.Geneves {
+			devices = append(devices, Device{Netns: netns, Name: name, Values: d.Ethernet})
+		}
+		for name, d := range values.BareUDP {
+			devices = append(devices, Device{Netns: netns, Name: name, Values: d.Ethernet})
+		}
+		for name, d := range values.VethDevices {
+			devices = append(devices, Device{Netns: netns, Name: name, Values: d.Ethernet})
+			devices = append(devices, Device{Netns: d.Peer.Netns, Name: d.Peer.Name, Values: d.Peer.Ethernet})
+		}
+	}
+
+	sort.Slice(devices, func(i, j int) bool {
+		if devices[i].Netns != devices[j].Netns {
+			return devices[i].Netns < devices[j].Netns
+		}
+		return devices[i].Name < devices[j].Name
+	})
+	return devices
+}
+
+// IntoNetns returns the ip command for netns, or for the root netns
+// when netns is empty.
+func IntoNetns(netns string) IpCommand {
+	if netns == "" {
+		return ip
+	}
+	return ip.IntoNetns(netns)
+}
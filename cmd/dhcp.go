@@ -0,0 +1,438 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"netnsplan/config"
+	"netnsplan/dhcp"
+	"netnsplan/iproute2"
+	"netnsplan/netns"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var dhcpCmd = &cobra.Command{
+	Use:   "dhcp",
+	Short: "Run DHCP clients and keep leases renewed",
+	Long: `Run DHCP clients for devices with dhcp4 or dhcp6 and keep leases renewed.
+"apply" acquires the first lease, but leased addresses expire unless this command is running.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+
+		var wg sync.WaitGroup
+		for _, d := range ListDevices(cfg) {
+			if d.Values.DHCP4 {
+				wg.Add(1)
+				go func(d Device) {
+					defer wg.Done()
+					RunDHCP4(ctx, d.Netns, d.Name)
+				}(d)
+			}
+			if d.Values.DHCP6 {
+				wg.Add(1)
+				go func(d Device) {
+					defer wg.Done()
+					RunDHCP6(ctx, d.Netns, d.Name)
+				}(d)
+			}
+		}
+		wg.Wait()
+
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(dhcpCmd)
+}
+
+// dhcpReady reports whether a DHCP client can run on the device. Devices
+// that are set down, optional devices that are missing and links that
+// are not up, e.g. manual ones, are skipped.
+func dhcpReady(ip IpCommand, name string, values config.Ethernet) (bool, error) {
+	if !values.DHCP4 && !values.DHCP6 {
+		return false, nil
+	}
+	if values.Activation == config.ActivationDown {
+		return false, nil
+	}
+
+	link, err := ip.ShowLink(name)
+	if err != nil {
+		if _, ok := err.(*iproute2.NotExistError); ok && values.Optional {
+			slog.Debug("optional device is not found, skip dhcp", "name", name)
+			return false, nil
+		}
+		return false, err
+	}
+	if !slices.Contains(link.Flags, "UP") {
+		if ip.InNetns() {
+			slog.Warn("link is not up, skip dhcp", "name", name, "netns", ip.Netns())
+		} else {
+			slog.Warn("link is not up, skip dhcp", "name", name)
+		}
+		return false, nil
+	}
+	return true, nil
+}
+
+func SetupDHCP(ip IpCommand, name string, values config.Ethernet) error {
+	if values.DHCP4 {
+		lease, err := Acquire4(ip.Netns(), name)
+		if err != nil {
+			return err
+		}
+		err = InstallLease4(ip, name, lease)
+		if err != nil {
+			return err
+		}
+	}
+
+	if values.DHCP6 {
+		lease, err := Acquire6(ip.Netns(), name)
+		if err != nil {
+			return err
+		}
+		err = InstallLease6(ip, name, lease)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func Acquire4(ns string, name string) (*dhcp.Lease4, error) {
+	var lease *dhcp.Lease4
+	err := netns.Do(ns, func() error {
+		c, err := dhcp.NewClient4(name)
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+
+		lease, err = c.Acquire()
+		return err
+	})
+	return lease, err
+}
+
+func Acquire6(ns string, name string) (*dhcp.Lease6, error) {
+	var lease *dhcp.Lease6
+	err := netns.Do(ns, func() error {
+		c, err := dhcp.NewClient6(name)
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+
+		lease, err = c.Acquire()
+		return err
+	})
+	return lease, err
+}
+
+func InstallLease4(ip IpCommand, name string, lease *dhcp.Lease4) error {
+	lifetime := seconds(lease.LeaseTime)
+	logWithNetns(ip, "set dhcp4 lease", "name", name, "address", lease.Address, "lease time", lease.LeaseTime)
+
+	// the address expires with the lease unless it is renewed
+	err := ip.ReplaceAddress(name, lease.Address.String(), "valid_lft", lifetime, "preferred_lft", lifetime)
+	if err != nil {
+		return err
+	}
+
+	// classless routes take precedence over the router option (RFC 3442)
+	routes := lease.Routes
+	if len(routes) == 0 && len(lease.Routers) > 0 {
+		routes = []dhcp.Route4{{Dst: netip.MustParsePrefix("0.0.0.0/0"), Gateway: lease.Routers[0]}}
+	}
+	current, err := ip.ListRoutes(iproute2.FamilyInet)
+	if err != nil {
+		return err
+	}
+	for _, r := range routes {
+		to := r.Dst.String()
+		if r.Dst.Bits() == 0 {
+			to = "default"
+		}
+		via := ""
+		if !r.Gateway.IsUnspecified() {
+			via = r.Gateway.String()
+		}
+
+		err := setDHCPRoute(ip, name, to, via, current)
+		if err != nil {
+			return err
+		}
+	}
+
+	search := lease.DomainSearch
+	if len(search) == 0 && lease.DomainName != "" {
+		search = []string{lease.DomainName}
+	}
+	return SetDHCPResolvConf(ip, name, 4, lease.DNS, search)
+}
+
+// setDHCPRoute adds a route learned by DHCP. A route to the same
+// destination is only replaced when DHCP has installed it, so that a route
+// from the config, or the default route of the host, is left alone.
+func setDHCPRoute(ip IpCommand, name, to, via string, current iproute2.Routes) error {
+	for _, r := range current {
+		if !sameTable(r.Table, "main", nil) || r.Metric != 0 || !sameDst(r.Dst, to, iproute2.FamilyInet) {
+			continue
+		}
+		if r.Protocol != "dhcp" {
+			if ip.InNetns() {
+				slog.Warn("route already exists, skip dhcp4 route", "name", name, "to", to, "dev", r.Dev, "netns", ip.Netns())
+			} else {
+				slog.Warn("route already exists, skip dhcp4 route", "name", name, "to", to, "dev", r.Dev)
+			}
+			return nil
+		}
+		if r.Dev == name && sameGateway(r.Gateway, via) {
+			return nil
+		}
+	}
+
+	logWithNetns(ip, "set dhcp4 route", "name", name, "to", to, "via", via)
+	return ip.ReplaceRoute(name, to, via, "proto", "dhcp")
+}
+
+func InstallLease6(ip IpCommand, name string, lease *dhcp.Lease6) error {
+	logWithNetns(ip, "set dhcp6 lease", "name", name, "address", lease.Address, "valid lifetime", lease.ValidLifetime)
+
+	// the on-link prefix is announced by router advertisements
+	address := netip.PrefixFrom(lease.Address, 128).String()
+	err := ip.ReplaceAddress(name, address, "valid_lft", seconds(lease.ValidLifetime), "preferred_lft", seconds(lease.PreferredLifetime))
+	if err != nil {
+		return err
+	}
+
+	return SetDHCPResolvConf(ip, name, 6, lease.DNS, lease.DomainSearch)
+}
+
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(d.Seconds()))
+}
+
+type dnsInfo struct {
+	nameservers []netip.Addr
+	search      []string
+}
+
+var (
+	dhcpDNSMu sync.Mutex
+	dhcpDNS   = map[string]map[string]dnsInfo{}
+)
+
+// SetDHCPResolvConf records DNS servers learned on a device and rewrites
+// the resolv.conf of its netns. The root netns is left untouched.
+func SetDHCPResolvConf(ip IpCommand, name string, family int, nameservers []netip.Addr, search []string) error {
+	if !ip.InNetns() {
+		slog.Debug("skip resolv.conf for root netns", "name", name)
+		return nil
+	}
+	if len(nameservers) == 0 && len(search) == 0 {
+		return nil
+	}
+	if cfg.Netns[ip.Netns()].DNS != nil {
+		slog.Debug("resolv.conf is set by dns", "name", name, "netns", ip.Netns())
+		return nil
+	}
+
+	dhcpDNSMu.Lock()
+	defer dhcpDNSMu.Unlock()
+
+	ns := ip.Netns()
+	if dhcpDNS[ns] == nil {
+		dhcpDNS[ns] = map[string]dnsInfo{}
+	}
+	dhcpDNS[ns][fmt.Sprintf("%s/%d", name, family)] = dnsInfo{nameservers: nameservers, search: search}
+
+	var keys []string
+	for key := range dhcpDNS[ns] {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	var servers []string
+	var domains []string
+	for _, key := range keys {
+		for _, s := range dhcpDNS[ns][key].nameservers {
+			if !slices.Contains(servers, s.String()) {
+				servers = append(servers, s.String())
+			}
+		}
+		for _, d := range dhcpDNS[ns][key].search {
+			if !slices.Contains(domains, d) {
+				domains = append(domains, d)
+			}
+		}
+	}
+
+	return WriteResolvConf(ns, servers, domains, nil)
+}
+
+func WriteResolvConf(ns string, nameservers []string, search []string, options []string) error {
+	var b strings.Builder
+	b.WriteString(generatedHeader)
+	if len(search) > 0 {
+		fmt.Fprintf(&b, "search %s\n", strings.Join(search, " "))
+	}
+	for _, s := range nameservers {
+		fmt.Fprintf(&b, "nameserver %s\n", s)
+	}
+	if len(options) > 0 {
+		fmt.Fprintf(&b, "options %s\n", strings.Join(options, " "))
+	}
+
+	path := filepath.Join(netnsEtcDir, ns, "resolv.conf")
+	if etcFileUpToDate(path, b.String()) {
+		slog.Debug("resolv.conf is up to date", "path", path)
+		return nil
+	}
+
+	slog.Info("write resolv.conf", "path", path, "nameservers", nameservers, "search", search, "options", options)
+	return writeEtcFile(path, b.String())
+}
+
+const dhcpRetryInterval = 30 * time.Second
+
+func RunDHCP4(ctx context.Context, ns string, name string) {
+	ip := IntoNetns(ns)
+
+	var c *dhcp.Client4
+	err := netns.Do(ns, func() error {
+		var err error
+		c, err = dhcp.NewClient4(name)
+		return err
+	})
+	if err != nil {
+		slog.Error("failed to start dhcp4 client", "name", name, "netns", ns, "err", err)
+		return
+	}
+	defer c.Close()
+
+	var lease *dhcp.Lease4
+	for {
+		var wait time.Duration
+		if lease == nil || time.Now().After(lease.Acquired.Add(lease.LeaseTime)) {
+			l, err := c.Acquire()
+			if err != nil {
+				slog.Warn("failed to acquire dhcp4 lease", "name", name, "netns", ns, "err", err)
+				lease = nil
+				wait = dhcpRetryInterval
+			} else {
+				lease = l
+			}
+		} else {
+			l, err := c.Renew(lease)
+			if err != nil {
+				slog.Warn("failed to renew dhcp4 lease", "name", name, "netns", ns, "err", err)
+				wait = dhcpRetryInterval
+			} else {
+				lease = l
+			}
+		}
+
+		if wait == 0 {
+			err := InstallLease4(ip, name, lease)
+			if err != nil {
+				slog.Error("failed to install dhcp4 lease", "name", name, "netns", ns, "err", err)
+			}
+			wait = max(lease.RenewalTime, 10*time.Second)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+func RunDHCP6(ctx context.Context, ns string, name string) {
+	ip := IntoNetns(ns)
+
+	var c *dhcp.Client6
+	err := netns.Do(ns, func() error {
+		var err error
+		c, err = dhcp.NewClient6(name)
+		return err
+	})
+	if err != nil {
+		slog.Error("failed to start dhcp6 client", "name", name, "netns", ns, "err", err)
+		return
+	}
+	defer c.Close()
+
+	var lease *dhcp.Lease6
+	for {
+		var wait time.Duration
+		if lease == nil || time.Now().After(lease.Acquired.Add(lease.ValidLifetime)) {
+			l, err := c.Acquire()
+			if err != nil {
+				slog.Warn("failed to acquire dhcp6 lease", "name", name, "netns", ns, "err", err)
+				lease = nil
+				wait = dhcpRetryInterval
+			} else {
+				lease = l
+			}
+		} else {
+			l, err := c.Renew(lease)
+			if err != nil {
+				slog.Warn("failed to renew dhcp6 lease", "name", name, "netns", ns, "err", err)
+				wait = dhcpRetryInterval
+			} else {
+				lease = l
+			}
+		}
+
+		if wait == 0 {
+			err := InstallLease6(ip, name, lease)
+			if err != nil {
+				slog.Error("failed to install dhcp6 lease", "name", name, "netns", ns, "err", err)
+			}
+			wait = max(lease.RenewalTime, 10*time.Second)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
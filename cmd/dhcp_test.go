@@ -0,0 +1,185 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package cmd
+
+import (
+	"netnsplan/config"
+	"netnsplan/iproute2"
+	"reflect"
+	"testing"
+)
+
+// fakeLinks is an IpCommand in a netns that only knows its links.
+type fakeLinks struct {
+	IpCommand
+	links map[string]*iproute2.Link
+}
+
+func (f *fakeLinks) ShowLink(name string) (*iproute2.Link, error) {
+	link, ok := f.links[name]
+	if !ok {
+		return nil, &iproute2.NotExistError{Msg: "Device \"" + name + "\" does not exist."}
+	}
+	return link, nil
+}
+
+func (f *fakeLinks) InNetns() bool { return true }
+func (f *fakeLinks) Netns() string { return "ns1" }
+
+func TestDhcpReady(t *testing.T) {
+	ip := &fakeLinks{links: map[string]*iproute2.Link{
+		"eth0": {Ifname: "eth0", Flags: []string{"BROADCAST", "MULTICAST", "UP", "LOWER_UP"}},
+		"eth1": {Ifname: "eth1", Flags: []string{"BROADCAST", "MULTICAST"}},
+	}}
+
+	testCases := []struct {
+		desc         string
+		name         string
+		values       config.Ethernet
+		expected     bool
+		expectingErr bool
+	}{
+		{
+			desc:     "Link is up",
+			name:     "eth0",
+			values:   config.Ethernet{DHCP4: true},
+			expected: true,
+		},
+		{
+			desc:   "No dhcp",
+			name:   "eth0",
+			values: config.Ethernet{},
+		},
+		{
+			desc:   "Activation down",
+			name:   "eth0",
+			values: config.Ethernet{DHCP4: true, Activation: config.ActivationDown},
+		},
+		{
+			desc:   "Manual link is down",
+			name:   "eth1",
+			values: config.Ethernet{DHCP6: true, Activation: config.ActivationManual},
+		},
+		{
+			desc:   "Optional device is missing",
+			name:   "eth2",
+			values: config.Ethernet{DHCP4: true, Optional: true},
+		},
+		{
+			desc:         "Device is missing",
+			name:         "eth2",
+			values:       config.Ethernet{DHCP4: true},
+			expectingErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, err := dhcpReady(ip, tc.name, tc.values)
+			if (err != nil) != tc.expectingErr {
+				t.Fatalf("dhcpReady() error = %v, expectingErr %v", err, tc.expectingErr)
+			}
+			if got != tc.expected {
+				t.Errorf("dhcpReady() = %v, want %v", got, tc.expected)
+			}
+		})
+	}
+}
+
+func (f *fakeIp) ReplaceRoute(name, to, via string, options ...string) error {
+	return f.record("replace route %s %s via %s %v", name, to, via, options)
+}
+
+func TestSetDHCPRoute(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		current  iproute2.Routes
+		to       string
+		via      string
+		expected []string
+	}{
+		{
+			desc:     "No route",
+			to:       "default",
+			via:      "10.0.0.1",
+			expected: []string{"replace route eth0 default via 10.0.0.1 [proto dhcp]"},
+		},
+		{
+			desc:    "Static default route",
+			current: iproute2.Routes{{Dst: "default", Gateway: "10.0.0.254", Dev: "eth0", Protocol: "boot"}},
+			to:      "default",
+			via:     "10.0.0.1",
+		},
+		{
+			desc:    "Default route of the host on another device",
+			current: iproute2.Routes{{Dst: "default", Gateway: "192.168.0.1", Dev: "eth1", Protocol: "boot"}},
+			to:      "default",
+			via:     "10.0.0.1",
+		},
+		{
+			desc:    "Route installed by dhcp",
+			current: iproute2.Routes{{Dst: "default", Gateway: "10.0.0.1", Dev: "eth0", Protocol: "dhcp"}},
+			to:      "default",
+			via:     "10.0.0.1",
+		},
+		{
+			desc:     "Route installed by dhcp with another gateway",
+			current:  iproute2.Routes{{Dst: "default", Gateway: "10.0.0.2", Dev: "eth0", Protocol: "dhcp"}},
+			to:       "default",
+			via:      "10.0.0.1",
+			expected: []string{"replace route eth0 default via 10.0.0.1 [proto dhcp]"},
+		},
+		{
+			desc:     "Route in another table",
+			current:  iproute2.Routes{{Dst: "default", Gateway: "10.0.0.254", Dev: "eth0", Table: "100"}},
+			to:       "default",
+			via:      "10.0.0.1",
+			expected: []string{"replace route eth0 default via 10.0.0.1 [proto dhcp]"},
+		},
+		{
+			desc:     "Route with a metric",
+			current:  iproute2.Routes{{Dst: "default", Gateway: "10.0.0.254", Dev: "eth0", Metric: 100}},
+			to:       "default",
+			via:      "10.0.0.1",
+			expected: []string{"replace route eth0 default via 10.0.0.1 [proto dhcp]"},
+		},
+		{
+			desc:     "Route to another destination",
+			current:  iproute2.Routes{{Dst: "default", Gateway: "10.0.0.254", Dev: "eth0"}},
+			to:       "192.168.1.0/24",
+			via:      "10.0.0.1",
+			expected: []string{"replace route eth0 192.168.1.0/24 via 10.0.0.1 [proto dhcp]"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			ip := &fakeIp{}
+			if err := setDHCPRoute(ip, "eth0", tc.to, tc.via, tc.current); err != nil {
+				t.Fatalf("setDHCPRoute() error = %v", err)
+			}
+			if !reflect.DeepEqual(ip.calls, tc.expected) {
+				t.Errorf("setDHCPRoute() calls = %v, want %v", ip.calls, tc.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"netnsplan/config"
+	"path/filepath"
+	"strings"
+)
+
+// SetupResolvConf writes the resolv.conf of the netns. Without dns, the
+// file is left to DHCP if a device of the netns uses it, and is removed
+// otherwise so that the netns falls back to the host's.
+func SetupResolvConf(ns string, dns *config.DNS) error {
+	if dns == nil {
+		if netnsUsesDHCP(ns) {
+			return nil
+		}
+		return removeGeneratedEtcFile(filepath.Join(netnsEtcDir, ns, "resolv.conf"))
+	}
+
+	for _, s := range dns.Nameservers {
+		if _, err := netip.ParseAddr(s); err != nil {
+			return fmt.Errorf("invalid nameserver %q for %s", s, ns)
+		}
+	}
+	return WriteResolvConf(ns, dns.Nameservers, dns.Search, dns.Options)
+}
+
+// SetupHosts writes the hosts file of the netns, or removes it when no
+// entries are left.
+func SetupHosts(ns string, entries []config.HostsEntry) error {
+	path := filepath.Join(netnsEtcDir, ns, "hosts")
+	if len(entries) == 0 {
+		return removeGeneratedEtcFile(path)
+	}
+
+	data, err := renderHosts(entries)
+	if err != nil {
+		return fmt.Errorf("hosts of %s: %w", ns, err)
+	}
+	if etcFileUpToDate(path, data) {
+		slog.Debug("hosts is up to date", "path", path)
+		return nil
+	}
+
+	slog.Info("write hosts", "path", path)
+	return writeEtcFile(path, data)
+}
+
+// renderHosts renders the entries after the localhost ones, since the
+// file replaces the hosts file of the host.
+func renderHosts(entries []config.HostsEntry) (string, error) {
+	var b strings.Builder
+	b.WriteString(generatedHeader)
+	b.WriteString("127.0.0.1\tlocalhost\n")
+	b.WriteString("::1\tlocalhost ip6-localhost ip6-loopback\n")
+	for _, e := range entries {
+		if _, err := netip.ParseAddr(e.IP); err != nil {
+			return "", fmt.Errorf("invalid ip %q", e.IP)
+		}
+		if len(e.Names) == 0 {
+			return "", fmt.Errorf("%s has no names", e.IP)
+		}
+		fmt.Fprintf(&b, "%s\t%s\n", e.IP, strings.Join(e.Names, " "))
+	}
+	return b.String(), nil
+}
+
+func netnsUsesDHCP(ns string) bool {
+	for _, d := range ListDevices(cfg) {
+		if d.Netns == ns && (d.Values.DHCP4 || d.Values.DHCP6) {
+			return true
+		}
+	}
+	return false
+}
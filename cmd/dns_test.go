@@ -0,0 +1,52 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package cmd
+
+import (
+	"netnsplan/config"
+	"testing"
+)
+
+func TestRenderHosts(t *testing.T) {
+	got, err := renderHosts([]config.HostsEntry{
+		{IP: "10.0.0.1", Names: []string{"gw", "gw.example.com"}},
+		{IP: "2001:db8::1", Names: []string{"gw6"}},
+	})
+	if err != nil {
+		t.Fatalf("renderHosts() error = %v", err)
+	}
+	expected := "# Generated by netnsplan\n" +
+		"127.0.0.1\tlocalhost\n" +
+		"::1\tlocalhost ip6-localhost ip6-loopback\n" +
+		"10.0.0.1\tgw gw.example.com\n" +
+		"2001:db8::1\tgw6\n"
+	if got != expected {
+		t.Errorf("renderHosts() = %q, want %q", got, expected)
+	}
+
+	if _, err := renderHosts([]config.HostsEntry{{IP: "gw", Names: []string{"gw"}}}); err == nil {
+		t.Errorf("renderHosts() expected error for invalid ip")
+	}
+	if _, err := renderHosts([]config.HostsEntry{{IP: "10.0.0.1"}}); err == nil {
+		t.Errorf("renderHosts() expected error for no names")
+	}
+}
@@ -0,0 +1,98 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package cmd
+
+import (
+	"netnsplan/config"
+	"netnsplan/iproute2"
+	"strings"
+)
+
+func encapOptions(encap config.Encap) []string {
+	options := []string{"encap", encap.Type}
+	switch encap.Type {
+	case config.EncapMPLS:
+		options = append(options, mplsLabels(encap.Labels))
+	case config.EncapSeg6:
+		options = append(options, "mode", seg6Mode(encap.Mode), "segs", strings.Join(encap.Segments, ","))
+	case config.EncapSeg6Local:
+		options = append(options, "action", encap.Action)
+		if encap.Nh4 != "" {
+			options = append(options, "nh4", encap.Nh4)
+		}
+		if encap.Nh6 != "" {
+			options = append(options, "nh6", encap.Nh6)
+		}
+		if encap.Table != "" {
+			options = append(options, "table", encap.Table)
+		}
+		if encap.VrfTable != "" {
+			options = append(options, "vrftable", encap.VrfTable)
+		}
+	}
+	return options
+}
+
+func seg6Mode(mode string) string {
+	if mode == "" {
+		return "encap"
+	}
+	return mode
+}
+
+// sameEncap compares the encap of a route with the config.
+func sameEncap(current *iproute2.RouteEncap, encap *config.Encap, tables map[string]int) bool {
+	if current == nil || encap == nil {
+		return current == nil && encap == nil
+	}
+	if current.Type != encap.Type {
+		return false
+	}
+
+	switch encap.Type {
+	case config.EncapMPLS:
+		return current.Labels == mplsLabels(encap.Labels)
+	case config.EncapSeg6:
+		mode := seg6Mode(encap.Mode)
+		segs := current.Segs
+		// inline mode reserves the first segment for the destination
+		if mode == "inline" && len(segs) > 0 && segs[len(segs)-1] == "::" {
+			segs = segs[:len(segs)-1]
+		}
+		if current.Mode != mode || len(segs) != len(encap.Segments) {
+			return false
+		}
+		for i := range segs {
+			if !sameGateway(segs[i], encap.Segments[i]) {
+				return false
+			}
+		}
+		return true
+	case config.EncapSeg6Local:
+		return current.Action == encap.Action &&
+			sameGateway(current.Nh4, encap.Nh4) &&
+			sameGateway(current.Nh6, encap.Nh6) &&
+			(encap.Table == "" || sameTable(current.Table, encap.Table, tables)) &&
+			(encap.VrfTable == "" || sameTable(current.VrfTable, encap.VrfTable, tables))
+	}
+	return true
+}
@@ -0,0 +1,124 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package cmd
+
+import (
+	"netnsplan/config"
+	"netnsplan/iproute2"
+	"reflect"
+	"testing"
+)
+
+func TestSameEncap(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		current  *iproute2.RouteEncap
+		encap    *config.Encap
+		expected bool
+	}{
+		{
+			desc:     "no encap",
+			expected: true,
+		},
+		{
+			desc:     "encap is added",
+			encap:    &config.Encap{Type: config.EncapMPLS, Labels: []int{100}},
+			expected: false,
+		},
+		{
+			desc:     "mpls",
+			current:  &iproute2.RouteEncap{Type: "mpls", Labels: "100/200"},
+			encap:    &config.Encap{Type: config.EncapMPLS, Labels: []int{100, 200}},
+			expected: true,
+		},
+		{
+			desc:     "mpls with other labels",
+			current:  &iproute2.RouteEncap{Type: "mpls", Labels: "100/200"},
+			encap:    &config.Encap{Type: config.EncapMPLS, Labels: []int{100}},
+			expected: false,
+		},
+		{
+			desc:     "seg6 with default mode",
+			current:  &iproute2.RouteEncap{Type: "seg6", Mode: "encap", Segs: []string{"fc00::1", "fc00::2"}},
+			encap:    &config.Encap{Type: config.EncapSeg6, Segments: []string{"fc00::1", "fc00:0::2"}},
+			expected: true,
+		},
+		{
+			desc:     "seg6 inline",
+			current:  &iproute2.RouteEncap{Type: "seg6", Mode: "inline", Segs: []string{"fc00::3", "::"}},
+			encap:    &config.Encap{Type: config.EncapSeg6, Mode: "inline", Segments: []string{"fc00::3"}},
+			expected: true,
+		},
+		{
+			desc:     "seg6local",
+			current:  &iproute2.RouteEncap{Type: "seg6local", Action: "End.DT6", Table: "100"},
+			encap:    &config.Encap{Type: config.EncapSeg6Local, Action: "End.DT6", Table: "100"},
+			expected: true,
+		},
+		{
+			desc:     "seg6local with another nexthop",
+			current:  &iproute2.RouteEncap{Type: "seg6local", Action: "End.X", Nh6: "2001:db8::2"},
+			encap:    &config.Encap{Type: config.EncapSeg6Local, Action: "End.X", Nh6: "2001:db8::3"},
+			expected: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			if got := sameEncap(tc.current, tc.encap, nil); got != tc.expected {
+				t.Errorf("sameEncap() = %v, want %v", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestEncapOptions(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		encap    config.Encap
+		expected []string
+	}{
+		{
+			desc:     "mpls",
+			encap:    config.Encap{Type: config.EncapMPLS, Labels: []int{100, 200}},
+			expected: []string{"encap", "mpls", "100/200"},
+		},
+		{
+			desc:     "seg6 with default mode",
+			encap:    config.Encap{Type: config.EncapSeg6, Segments: []string{"fc00::1", "fc00::2"}},
+			expected: []string{"encap", "seg6", "mode", "encap", "segs", "fc00::1,fc00::2"},
+		},
+		{
+			desc:     "seg6local",
+			encap:    config.Encap{Type: config.EncapSeg6Local, Action: "End.DT6", Table: "100"},
+			expected: []string{"encap", "seg6local", "action", "End.DT6", "table", "100"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			if got := encapOptions(tc.encap); !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("encapOptions() = %v, want %v", got, tc.expected)
+			}
+		})
+	}
+}
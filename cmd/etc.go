@@ -0,0 +1,80 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package cmd
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// netnsEtcDir holds per-netns files. "ip netns exec" bind mounts each
+// entry of /etc/netns/<netns> over the same entry of /etc.
+const netnsEtcDir = "/etc/netns"
+
+// generatedHeader is the first line of the files netnsplan writes.
+const generatedHeader = "# Generated by netnsplan\n"
+
+// etcFileUpToDate reports whether the file at path already has data.
+func etcFileUpToDate(path string, data string) bool {
+	current, err := os.ReadFile(path)
+	return err == nil && string(current) == data
+}
+
+func writeEtcFile(path string, data string) error {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(data), 0644)
+}
+
+// removeGeneratedEtcFile removes a file that netnsplan has written once it
+// is no longer in the config. Files written by others are left alone.
+func removeGeneratedEtcFile(path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(string(data), generatedHeader) {
+		return nil
+	}
+
+	slog.Info("remove file", "path", path)
+	return os.Remove(path)
+}
+
+// RemoveNetnsEtcDir removes /etc/netns/<netns> along with every file
+// written for the netns.
+func RemoveNetnsEtcDir(ns string) error {
+	dir := filepath.Join(netnsEtcDir, ns)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil
+	}
+
+	slog.Info("remove netns config", "path", dir)
+	return os.RemoveAll(dir)
+}
@@ -0,0 +1,100 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package cmd
+
+import (
+	"log/slog"
+	"netnsplan/config"
+	"netnsplan/iproute2"
+	"strconv"
+)
+
+func SetOffload(ip IpCommand, name string, offload map[string]bool) error {
+	if len(offload) == 0 {
+		return nil
+	}
+
+	ethtool := ip.Ethtool(flags.EthtoolCmdPath)
+	features, err := ethtool.ShowFeatures(name)
+	if err != nil {
+		return err
+	}
+
+	changes := map[string]bool{}
+	for key, enabled := range offload {
+		current, ok := features[iproute2.FeatureName(key)]
+		if !ok {
+			slog.Warn("offload feature is not supported", "name", name, "feature", key)
+			continue
+		}
+
+		slog.Debug("offload feature", "name", name, "feature", key, "current", current.Enabled, "want", enabled)
+		if current.Enabled == enabled {
+			continue
+		}
+		if current.Fixed {
+			slog.Warn("offload feature is fixed", "name", name, "feature", key, "current", current.Enabled)
+			continue
+		}
+		changes[key] = enabled
+	}
+
+	if len(changes) == 0 {
+		slog.Debug("offload features are already set", "name", name)
+		return nil
+	}
+
+	logWithNetns(ip, "set offload features", "name", name, "features", changes)
+	return ethtool.SetFeatures(name, changes)
+}
+
+func SetRing(ip IpCommand, name string, ring config.Ring) error {
+	ethtool := ip.Ethtool(flags.EthtoolCmdPath)
+	current, err := ethtool.ShowRing(name)
+	if err != nil {
+		return err
+	}
+
+	var options []string
+	for _, param := range []struct {
+		name          string
+		want, current int
+	}{
+		{"rx", ring.RX, current.RX},
+		{"rx-mini", ring.RXMini, current.RXMini},
+		{"rx-jumbo", ring.RXJumbo, current.RXJumbo},
+		{"tx", ring.TX, current.TX},
+	} {
+		slog.Debug("ring parameter", "name", name, "param", param.name, "current", param.current, "want", param.want)
+		if param.want != 0 && param.want != param.current {
+			options = append(options, param.name, strconv.Itoa(param.want))
+		}
+	}
+
+	if len(options) == 0 {
+		slog.Debug("ring parameters are already set", "name", name)
+		return nil
+	}
+
+	logWithNetns(ip, "set ring parameters", "name", name, "params", options)
+	return ethtool.SetRing(name, options...)
+}
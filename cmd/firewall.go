@@ -0,0 +1,356 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package cmd
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"netnsplan/config"
+	"netnsplan/iproute2"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// netnsplanRunDir holds the state of what netnsplan has loaded for
+// each netns.
+var netnsplanRunDir = "/run/netnsplan"
+
+const (
+	rulesetStateHash   = "# netnsplan sha256:"
+	rulesetStateTables = "# netnsplan tables:"
+)
+
+type nftTable struct {
+	family string
+	name   string
+}
+
+func (t nftTable) String() string {
+	return t.family + " " + t.name
+}
+
+var nftFamilies = []string{"ip", "ip6", "inet", "arp", "bridge", "netdev"}
+
+// rulesetState is the ruleset that was last loaded by netnsplan: the
+// hash of the declared ruleset, its tables and their listing right
+// after loading.
+type rulesetState struct {
+	hash    string
+	tables  []nftTable
+	listing string
+}
+
+// rulesetStatePath returns where the state of a ruleset that netnsplan
+// loads for the netns is kept.
+func rulesetStatePath(ns string, name string) string {
+	return filepath.Join(netnsplanRunDir, ns, name+".nft")
+}
+
+// SetupFirewall loads the declared ruleset when the live one differs.
+// Only the tables declared in the ruleset, and the ones declared when
+// it was last loaded, are replaced; other tables are left alone.
+func SetupFirewall(ip IpCommand, ns string, fw *config.Firewall) error {
+	path := rulesetStatePath(ns, "firewall")
+	if fw == nil {
+		return removeRuleset(ip, path, "firewall")
+	}
+
+	ruleset, err := firewallRuleset(fw)
+	if err != nil {
+		return err
+	}
+	return setupRuleset(ip, path, "firewall", ruleset)
+}
+
+// FirewallDrift returns why the live ruleset of the netns differs from
+// the declared one, or an empty string if it does not.
+func FirewallDrift(ip IpCommand, ns string, fw *config.Firewall) (string, error) {
+	ruleset, err := firewallRuleset(fw)
+	if err != nil {
+		return "", err
+	}
+	return rulesetDrift(ip, rulesetStatePath(ns, "firewall"), ruleset)
+}
+
+// RemoveNetnsState removes what netnsplan has recorded for the netns.
+func RemoveNetnsState(ns string) error {
+	dir := filepath.Join(netnsplanRunDir, ns)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil
+	}
+
+	slog.Debug("remove state", "path", dir)
+	return os.RemoveAll(dir)
+}
+
+// writeStateFile records data at path under netnsplanRunDir.
+func writeStateFile(path string, data string) error {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(data), 0644)
+}
+
+// deviceStatePath returns where netnsplan records what of kind (e.g.
+// "neighbors") it has added to the device of the netns.
+func deviceStatePath(ns string, kind string, name string) string {
+	return filepath.Join(netnsplanRunDir, ns, kind, name)
+}
+
+// readDeviceState returns the lines recorded for the device. Nothing is
+// recorded for the devices of the host, so what netnsplan has added
+// there is never removed.
+func readDeviceState(ip IpCommand, kind string, name string) ([]string, error) {
+	if !ip.InNetns() {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(deviceStatePath(ip.Netns(), kind, name))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return strings.FieldsFunc(string(data), func(r rune) bool { return r == '\n' }), nil
+}
+
+// writeDeviceState records the lines for the device, or removes the
+// record when there are none.
+func writeDeviceState(ip IpCommand, kind string, name string, lines []string) error {
+	if !ip.InNetns() {
+		return nil
+	}
+
+	path := deviceStatePath(ip.Netns(), kind, name)
+	if len(lines) == 0 {
+		err := os.Remove(path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return writeStateFile(path, strings.Join(lines, "\n")+"\n")
+}
+
+// setupRuleset loads the ruleset with the nft command of ip, replacing
+// the tables it declares and the ones it declared when last loaded.
+// The state of the loaded ruleset is kept at path.
+func setupRuleset(ip IpCommand, path string, label string, ruleset string) error {
+	drift, err := rulesetDrift(ip, path, ruleset)
+	if err != nil {
+		return err
+	}
+	if drift == "" {
+		slog.Debug(label+" is already loaded", "path", path)
+		return nil
+	}
+
+	tables := nftTables(ruleset)
+	if len(tables) == 0 {
+		return fmt.Errorf("%s declares no table", label)
+	}
+
+	state, err := readRulesetState(path)
+	if err != nil {
+		return err
+	}
+	owned := tables
+	if state != nil {
+		for _, t := range state.tables {
+			if !slices.Contains(owned, t) {
+				owned = append(owned, t)
+			}
+		}
+	}
+
+	nft := ip.Nft(flags.NftCmdPath)
+	logWithNetns(ip, "load "+label, "tables", tables, "reason", drift)
+	err = nft.LoadRuleset(deleteTablesScript(owned) + ruleset)
+	if err != nil {
+		return err
+	}
+
+	listing, err := listNftTables(nft, tables)
+	if err != nil {
+		return err
+	}
+	var names []string
+	for _, t := range tables {
+		names = append(names, t.String())
+	}
+	data := rulesetStateHash + rulesetHash(ruleset) + "\n" +
+		rulesetStateTables + strings.Join(names, ",") + "\n" + listing
+	return writeStateFile(path, data)
+}
+
+// removeRuleset deletes the tables of the ruleset that was loaded with
+// the state at path.
+func removeRuleset(ip IpCommand, path string, label string) error {
+	state, err := readRulesetState(path)
+	if err != nil || state == nil {
+		return err
+	}
+
+	logWithNetns(ip, "remove "+label, "tables", state.tables)
+	err = ip.Nft(flags.NftCmdPath).LoadRuleset(deleteTablesScript(state.tables))
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// rulesetDrift returns why the live tables differ from the ruleset, or
+// an empty string if they do not.
+func rulesetDrift(ip IpCommand, path string, ruleset string) (string, error) {
+	state, err := readRulesetState(path)
+	if err != nil {
+		return "", err
+	}
+	if state == nil {
+		return "ruleset is not loaded", nil
+	}
+	if state.hash != rulesetHash(ruleset) {
+		return "declared ruleset has changed", nil
+	}
+
+	live, err := listNftTables(ip.Nft(flags.NftCmdPath), state.tables)
+	if err != nil {
+		return "", err
+	}
+	if live != state.listing {
+		return "live ruleset has been modified", nil
+	}
+	return "", nil
+}
+
+func firewallRuleset(fw *config.Firewall) (string, error) {
+	switch {
+	case fw.Ruleset != "" && fw.File != "":
+		return "", fmt.Errorf("firewall cannot have both ruleset and file")
+	case fw.File != "":
+		path := fw.File
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(flags.ConfigDir, path)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	case fw.Ruleset != "":
+		return fw.Ruleset, nil
+	}
+	return "", fmt.Errorf("firewall has neither ruleset nor file")
+}
+
+func readRulesetState(path string) (*rulesetState, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	lines := strings.SplitN(string(data), "\n", 3)
+	if len(lines) < 3 {
+		return nil, fmt.Errorf("invalid ruleset state %s", path)
+	}
+	hash, ok1 := strings.CutPrefix(lines[0], rulesetStateHash)
+	names, ok2 := strings.CutPrefix(lines[1], rulesetStateTables)
+	if !ok1 || !ok2 {
+		return nil, fmt.Errorf("invalid ruleset state %s", path)
+	}
+
+	state := &rulesetState{hash: hash, listing: lines[2]}
+	for _, name := range strings.Split(names, ",") {
+		if family, table, ok := strings.Cut(name, " "); ok {
+			state.tables = append(state.tables, nftTable{family: family, name: table})
+		}
+	}
+	return state, nil
+}
+
+// listNftTables lists the tables in order. A table that has been
+// deleted is left out.
+func listNftTables(nft *iproute2.NftCmd, tables []nftTable) (string, error) {
+	var listing strings.Builder
+	for _, t := range tables {
+		data, err := nft.ListTable(t.family, t.name)
+		if _, ok := err.(*iproute2.NotExistError); ok {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		listing.WriteString(data)
+	}
+	return listing.String(), nil
+}
+
+// deleteTablesScript deletes the tables. Declaring a table first makes
+// the deletion succeed when the table does not exist yet.
+func deleteTablesScript(tables []nftTable) string {
+	var script strings.Builder
+	for _, t := range tables {
+		fmt.Fprintf(&script, "table %s\ndelete table %s\n", t, t)
+	}
+	return script.String()
+}
+
+// nftTables returns the tables declared in the ruleset, in order. A
+// table without a family is an ip table.
+func nftTables(ruleset string) []nftTable {
+	var tables []nftTable
+	scanner := bufio.NewScanner(strings.NewReader(ruleset))
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(strings.ReplaceAll(line, "{", " { "))
+		if len(fields) > 0 && (fields[0] == "add" || fields[0] == "create") {
+			fields = fields[1:]
+		}
+		if len(fields) < 2 || fields[0] != "table" {
+			continue
+		}
+
+		t := nftTable{family: "ip", name: fields[1]}
+		if slices.Contains(nftFamilies, fields[1]) && len(fields) > 2 && fields[2] != "{" {
+			t = nftTable{family: fields[1], name: fields[2]}
+		}
+		if !slices.Contains(tables, t) {
+			tables = append(tables, t)
+		}
+	}
+	return tables
+}
+
+func rulesetHash(ruleset string) string {
+	sum := sha256.Sum256([]byte(ruleset))
+	return hex.EncodeToString(sum[:])
+}
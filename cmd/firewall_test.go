@@ -0,0 +1,64 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package cmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNftTables(t *testing.T) {
+	ruleset := `#!/usr/sbin/nft -f
+# table inet commented
+table inet filter {
+	chain input {
+		type filter hook input priority 0; policy accept;
+	}
+}
+table nat{
+}
+add table ip6 mangle
+table bridge filter {
+}
+table inet filter {
+}
+`
+	expected := []nftTable{
+		{family: "inet", name: "filter"},
+		{family: "ip", name: "nat"},
+		{family: "ip6", name: "mangle"},
+		{family: "bridge", name: "filter"},
+	}
+
+	got := nftTables(ruleset)
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("nftTables() = %v, want %v", got, expected)
+	}
+}
+
+func TestDeleteTablesScript(t *testing.T) {
+	got := deleteTablesScript([]nftTable{{family: "inet", name: "filter"}, {family: "ip", name: "nat"}})
+	expected := "table inet filter\ndelete table inet filter\ntable ip nat\ndelete table ip nat\n"
+	if got != expected {
+		t.Errorf("deleteTablesScript() = %q, want %q", got, expected)
+	}
+}
@@ -0,0 +1,227 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"netnsplan/config"
+	"netnsplan/iproute2"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SetupPortForwards installs the DNAT rules of the port forwards in a
+// table of their own in the host. Forwards that go through the
+// userspace proxy are left to "netnsplan proxy".
+func SetupPortForwards(netns string, forwards []config.PortForward) error {
+	path := rulesetStatePath(netns, "port-forwards")
+	ruleset, err := portForwardRuleset(netns, forwards)
+	if err != nil {
+		return err
+	}
+	if ruleset == "" {
+		return removeRuleset(ip, path, "port forwards")
+	}
+
+	// DNAT into the netns is forwarded by the host
+	for _, key := range forwardingSysctls(forwards) {
+		err = SetSysctl(ip, key, "1", false)
+		if err != nil {
+			return err
+		}
+	}
+
+	return setupRuleset(ip, path, "port forwards", ruleset)
+}
+
+// RemovePortForwards removes the DNAT rules of the netns from the host.
+func RemovePortForwards(netns string) error {
+	return removeRuleset(ip, rulesetStatePath(netns, "port-forwards"), "port forwards")
+}
+
+// PortForwardDrift returns why the DNAT rules differ from the config, or
+// an empty string if they do not.
+func PortForwardDrift(netns string, forwards []config.PortForward) (string, error) {
+	ruleset, err := portForwardRuleset(netns, forwards)
+	if err != nil || ruleset == "" {
+		return "", err
+	}
+
+	for _, key := range forwardingSysctls(forwards) {
+		current, err := ip.Sysctl(flags.SysctlCmdPath).Read(key)
+		if err != nil {
+			return "", err
+		}
+		if !sameSysctlValue(current, "1") {
+			return key + " is disabled", nil
+		}
+	}
+	return rulesetDrift(ip, rulesetStatePath(netns, "port-forwards"), ruleset)
+}
+
+// forwardingSysctls returns the host sysctls that enable forwarding for
+// the address families of the forwards done with DNAT.
+func forwardingSysctls(forwards []config.PortForward) []string {
+	var keys []string
+	for _, pf := range forwards {
+		if useProxy(pf) {
+			continue
+		}
+		key := "net/ipv4/ip_forward"
+		if a, err := netip.ParseAddr(pf.Target); err == nil && addrFamily(a) == iproute2.FamilyInet6 {
+			key = "net/ipv6/conf/all/forwarding"
+		}
+		if !slices.Contains(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// useProxy reports whether the forward is relayed by the userspace
+// proxy. DNAT cannot reach a loopback address of another netns.
+func useProxy(pf config.PortForward) bool {
+	if pf.Proxy {
+		return true
+	}
+	a, err := netip.ParseAddr(pf.Target)
+	return err == nil && a.IsLoopback()
+}
+
+func portForwardProto(pf config.PortForward) string {
+	if pf.Proto == "" {
+		return config.ProtoTCP
+	}
+	return pf.Proto
+}
+
+func portForwardTargetPort(pf config.PortForward) int {
+	if pf.TargetPort == 0 {
+		return pf.HostPort
+	}
+	return pf.TargetPort
+}
+
+func checkPortForward(netns string, pf config.PortForward) error {
+	errorf := func(format string, args ...any) error {
+		return fmt.Errorf("port forward %d of %s: %s", pf.HostPort, netns, fmt.Sprintf(format, args...))
+	}
+
+	if pf.HostPort < 1 || pf.HostPort > 65535 {
+		return errorf("invalid host-port")
+	}
+	if pf.TargetPort < 0 || pf.TargetPort > 65535 {
+		return errorf("invalid target-port %d", pf.TargetPort)
+	}
+	proto := portForwardProto(pf)
+	if proto != config.ProtoTCP && proto != config.ProtoUDP {
+		return errorf("unknown proto %q", pf.Proto)
+	}
+
+	target, err := netip.ParseAddr(pf.Target)
+	if err != nil {
+		return errorf("invalid target %q", pf.Target)
+	}
+	if pf.HostAddress != "" {
+		host, err := netip.ParseAddr(pf.HostAddress)
+		if err != nil {
+			return errorf("invalid host-address %q", pf.HostAddress)
+		}
+		if !useProxy(pf) && addrFamily(host) != addrFamily(target) {
+			return errorf("host-address and target must be of the same address family")
+		}
+	}
+	if useProxy(pf) && proto != config.ProtoTCP {
+		return errorf("the proxy only supports tcp")
+	}
+	return nil
+}
+
+// portForwardRuleset returns the nftables ruleset with the DNAT rules,
+// or an empty string if no forward needs one. Connections from the host
+// itself are forwarded too, except the ones from a loopback address,
+// which cannot leave the host.
+func portForwardRuleset(netns string, forwards []config.PortForward) (string, error) {
+	var prerouting, output []string
+	for _, pf := range forwards {
+		if err := checkPortForward(netns, pf); err != nil {
+			return "", err
+		}
+		if useProxy(pf) {
+			continue
+		}
+
+		target := netip.MustParseAddr(pf.Target)
+		family, nfproto, loopback := "ip", "ipv4", "127.0.0.0/8"
+		if addrFamily(target) == iproute2.FamilyInet6 {
+			family, nfproto, loopback = "ip6", "ipv6", "::1"
+		}
+
+		match := "fib daddr type local"
+		if pf.HostAddress != "" {
+			match = family + " daddr " + pf.HostAddress
+		}
+		to := netip.AddrPortFrom(target, uint16(portForwardTargetPort(pf)))
+		dnat := fmt.Sprintf("%s %s dport %d dnat %s to %s", match, portForwardProto(pf), pf.HostPort, family, to)
+
+		prerouting = append(prerouting, fmt.Sprintf("meta nfproto %s %s", nfproto, dnat))
+		output = append(output, fmt.Sprintf("meta nfproto %s %s saddr != %s %s", nfproto, family, loopback, dnat))
+	}
+	if len(prerouting) == 0 {
+		return "", nil
+	}
+
+	var ruleset strings.Builder
+	fmt.Fprintf(&ruleset, "table inet netnsplan-forward-%s {\n", netns)
+	for _, chain := range []struct {
+		name  string
+		rules []string
+	}{
+		{"prerouting", prerouting},
+		{"output", output},
+	} {
+		fmt.Fprintf(&ruleset, "\tchain %s {\n", chain.name)
+		fmt.Fprintf(&ruleset, "\t\ttype nat hook %s priority -100; policy accept;\n", chain.name)
+		for _, rule := range chain.rules {
+			fmt.Fprintf(&ruleset, "\t\t%s\n", rule)
+		}
+		fmt.Fprintf(&ruleset, "\t}\n")
+	}
+	fmt.Fprintf(&ruleset, "}\n")
+	return ruleset.String(), nil
+}
+
+// portForwardListenAddress returns the host address the proxy listens on.
+func portForwardListenAddress(pf config.PortForward) string {
+	return net.JoinHostPort(pf.HostAddress, strconv.Itoa(pf.HostPort))
+}
+
+// portForwardTargetAddress returns the address in the netns the proxy
+// connects to.
+func portForwardTargetAddress(pf config.PortForward) string {
+	return net.JoinHostPort(pf.Target, strconv.Itoa(portForwardTargetPort(pf)))
+}
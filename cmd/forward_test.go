@@ -0,0 +1,122 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package cmd
+
+import (
+	"netnsplan/config"
+	"reflect"
+	"testing"
+)
+
+func TestPortForwardRuleset(t *testing.T) {
+	forwards := []config.PortForward{
+		{HostPort: 8080, Target: "10.200.0.2", TargetPort: 80},
+		{HostPort: 53, Proto: "udp", HostAddress: "192.0.2.1", Target: "10.200.0.2"},
+		{HostPort: 8443, Target: "2001:db8::2"},
+		{HostPort: 9000, Target: "127.0.0.1"},
+		{HostPort: 9001, Target: "10.200.0.2", Proxy: true},
+	}
+	expected := `table inet netnsplan-forward-sample1 {
+	chain prerouting {
+		type nat hook prerouting priority -100; policy accept;
+		meta nfproto ipv4 fib daddr type local tcp dport 8080 dnat ip to 10.200.0.2:80
+		meta nfproto ipv4 ip daddr 192.0.2.1 udp dport 53 dnat ip to 10.200.0.2:53
+		meta nfproto ipv6 fib daddr type local tcp dport 8443 dnat ip6 to [2001:db8::2]:8443
+	}
+	chain output {
+		type nat hook output priority -100; policy accept;
+		meta nfproto ipv4 ip saddr != 127.0.0.0/8 fib daddr type local tcp dport 8080 dnat ip to 10.200.0.2:80
+		meta nfproto ipv4 ip saddr != 127.0.0.0/8 ip daddr 192.0.2.1 udp dport 53 dnat ip to 10.200.0.2:53
+		meta nfproto ipv6 ip6 saddr != ::1 fib daddr type local tcp dport 8443 dnat ip6 to [2001:db8::2]:8443
+	}
+}
+`
+
+	got, err := portForwardRuleset("sample1", forwards)
+	if err != nil {
+		t.Fatalf("portForwardRuleset() error = %v", err)
+	}
+	if got != expected {
+		t.Errorf("portForwardRuleset() = %v, want %v", got, expected)
+	}
+
+	got, err = portForwardRuleset("sample1", forwards[3:])
+	if err != nil || got != "" {
+		t.Errorf("portForwardRuleset() = %q, %v, want no ruleset", got, err)
+	}
+}
+
+func TestCheckPortForward(t *testing.T) {
+	testCases := []struct {
+		desc         string
+		pf           config.PortForward
+		expectingErr bool
+	}{
+		{desc: "valid", pf: config.PortForward{HostPort: 80, Target: "10.0.0.2"}},
+		{desc: "no host-port", pf: config.PortForward{Target: "10.0.0.2"}, expectingErr: true},
+		{desc: "unknown proto", pf: config.PortForward{HostPort: 80, Proto: "sctp", Target: "10.0.0.2"}, expectingErr: true},
+		{desc: "invalid target", pf: config.PortForward{HostPort: 80, Target: "example.com"}, expectingErr: true},
+		{desc: "family mismatch", pf: config.PortForward{HostPort: 80, HostAddress: "2001:db8::1", Target: "10.0.0.2"}, expectingErr: true},
+		{desc: "udp proxy", pf: config.PortForward{HostPort: 53, Proto: "udp", Target: "127.0.0.1"}, expectingErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := checkPortForward("sample1", tc.pf)
+			if (err != nil) != tc.expectingErr {
+				t.Errorf("checkPortForward() error = %v, expectingErr %v", err, tc.expectingErr)
+			}
+		})
+	}
+}
+
+func TestForwardingSysctls(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		forwards []config.PortForward
+		expected []string
+	}{
+		{
+			desc:     "IPv4",
+			forwards: []config.PortForward{{HostPort: 8080, Target: "10.200.0.2"}, {HostPort: 8081, Target: "10.200.0.3"}},
+			expected: []string{"net/ipv4/ip_forward"},
+		},
+		{
+			desc:     "IPv4 and IPv6",
+			forwards: []config.PortForward{{HostPort: 8443, Target: "2001:db8::2"}, {HostPort: 8080, Target: "10.200.0.2"}},
+			expected: []string{"net/ipv4/ip_forward", "net/ipv6/conf/all/forwarding"},
+		},
+		{
+			desc:     "Proxy only",
+			forwards: []config.PortForward{{HostPort: 9000, Target: "127.0.0.1"}, {HostPort: 9001, Target: "10.200.0.2", Proxy: true}},
+			expected: nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			if got := forwardingSysctls(tc.forwards); !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("forwardingSysctls() = %v, want %v", got, tc.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package cmd
+
+import (
+	"log/slog"
+	"net/netip"
+	"netnsplan/config"
+	"netnsplan/iproute2"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// SetMplsPlatformLabels sets the size of the label table of the netns,
+// which enables MPLS forwarding.
+func SetMplsPlatformLabels(ip IpCommand, labels int) error {
+	if labels == 0 {
+		return nil
+	}
+	return SetSysctl(ip, "net/mpls/platform_labels", strconv.Itoa(labels), false)
+}
+
+// SetMplsInput makes the device accept MPLS packets.
+func SetMplsInput(ip IpCommand, name string, input *bool) error {
+	if input == nil {
+		return nil
+	}
+	return SetSysctl(ip, "net/mpls/conf/"+name+"/input", boolValue(*input, "1"), false)
+}
+
+// SetupMplsRoutes adds the label switching entries and replaces the ones
+// that differ from the config.
+func SetupMplsRoutes(ip IpCommand, routes []config.MplsRoute) error {
+	if len(routes) == 0 {
+		return nil
+	}
+
+	current, err := ip.ListMplsRoutes()
+	if err != nil {
+		return err
+	}
+
+	for _, route := range routes {
+		i := slices.IndexFunc(current, func(r iproute2.MplsRoute) bool {
+			return r.Dst == strconv.Itoa(route.Label)
+		})
+		if i >= 0 && !mplsRouteDrifted(current[i], route) {
+			slog.Debug("mpls route is already exists", "label", route.Label)
+			continue
+		}
+
+		options := mplsRouteOptions(route)
+		if i < 0 {
+			logWithNetns(ip, "add mpls route", "label", route.Label, "route", strings.Join(options, " "))
+			err = ip.AddMplsRoute(route.Label, options...)
+		} else {
+			logWithNetns(ip, "replace mpls route", "label", route.Label, "route", strings.Join(options, " "))
+			err = ip.ReplaceMplsRoute(route.Label, options...)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func mplsRouteOptions(route config.MplsRoute) []string {
+	var options []string
+	if len(route.As) > 0 {
+		options = append(options, "as", mplsLabels(route.As))
+	}
+	if route.Via != "" {
+		family := iproute2.FamilyInet
+		if a, err := netip.ParseAddr(route.Via); err == nil {
+			family = addrFamily(a)
+		}
+		options = append(options, "via", family, route.Via)
+	}
+	if route.Dev != "" {
+		options = append(options, "dev", route.Dev)
+	}
+	return options
+}
+
+func mplsRouteDrifted(r iproute2.MplsRoute, route config.MplsRoute) bool {
+	if r.NewDst != mplsLabels(route.As) {
+		return true
+	}
+	via := ""
+	if r.Via != nil {
+		via = r.Via.Host
+	}
+	if !sameGateway(via, route.Via) {
+		return true
+	}
+	return route.Dev != "" && r.Dev != route.Dev
+}
+
+// mplsLabels formats a label stack as "ip" does, e.g. "100/200".
+func mplsLabels(labels []int) string {
+	var s []string
+	for _, l := range labels {
+		s = append(s, strconv.Itoa(l))
+	}
+	return strings.Join(s, "/")
+}
@@ -0,0 +1,122 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package cmd
+
+import (
+	"netnsplan/config"
+	"netnsplan/iproute2"
+	"reflect"
+	"testing"
+)
+
+func TestMplsRouteDrifted(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		current  iproute2.MplsRoute
+		route    config.MplsRoute
+		expected bool
+	}{
+		{
+			desc:     "Same swap",
+			current:  iproute2.MplsRoute{Dst: "100", NewDst: "200", Via: &iproute2.MplsVia{Family: "inet", Host: "10.0.0.2"}, Dev: "eth0"},
+			route:    config.MplsRoute{Label: 100, As: []int{200}, Via: "10.0.0.2", Dev: "eth0"},
+			expected: false,
+		},
+		{
+			desc:     "Same label stack",
+			current:  iproute2.MplsRoute{Dst: "100", NewDst: "200/300", Via: &iproute2.MplsVia{Family: "inet", Host: "10.0.0.2"}, Dev: "eth0"},
+			route:    config.MplsRoute{Label: 100, As: []int{200, 300}, Via: "10.0.0.2"},
+			expected: false,
+		},
+		{
+			desc:     "Other outgoing labels",
+			current:  iproute2.MplsRoute{Dst: "100", NewDst: "200", Via: &iproute2.MplsVia{Family: "inet", Host: "10.0.0.2"}, Dev: "eth0"},
+			route:    config.MplsRoute{Label: 100, As: []int{201}, Via: "10.0.0.2"},
+			expected: true,
+		},
+		{
+			desc:     "Swap turned into pop",
+			current:  iproute2.MplsRoute{Dst: "100", NewDst: "200", Via: &iproute2.MplsVia{Family: "inet", Host: "10.0.0.2"}, Dev: "eth0"},
+			route:    config.MplsRoute{Label: 100, Via: "10.0.0.2"},
+			expected: true,
+		},
+		{
+			desc:     "Other gateway",
+			current:  iproute2.MplsRoute{Dst: "100", Via: &iproute2.MplsVia{Family: "inet", Host: "10.0.0.2"}, Dev: "eth0"},
+			route:    config.MplsRoute{Label: 100, Via: "10.0.0.3"},
+			expected: true,
+		},
+		{
+			desc:     "IPv6 gateway written differently",
+			current:  iproute2.MplsRoute{Dst: "100", Via: &iproute2.MplsVia{Family: "inet6", Host: "2001:db8::2"}, Dev: "eth0"},
+			route:    config.MplsRoute{Label: 100, Via: "2001:db8:0::2"},
+			expected: false,
+		},
+		{
+			desc:     "Gateway removed",
+			current:  iproute2.MplsRoute{Dst: "100", Via: &iproute2.MplsVia{Family: "inet", Host: "10.0.0.2"}, Dev: "eth0"},
+			route:    config.MplsRoute{Label: 100, Dev: "eth0"},
+			expected: true,
+		},
+		{
+			desc:     "Other device",
+			current:  iproute2.MplsRoute{Dst: "100", Dev: "eth0"},
+			route:    config.MplsRoute{Label: 100, Dev: "eth1"},
+			expected: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			if got := mplsRouteDrifted(tc.current, tc.route); got != tc.expected {
+				t.Errorf("mplsRouteDrifted() = %v, want %v", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestMplsRouteOptions(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		route    config.MplsRoute
+		expected []string
+	}{
+		{
+			desc:     "Swap",
+			route:    config.MplsRoute{Label: 100, As: []int{200, 300}, Via: "10.0.0.2", Dev: "eth0"},
+			expected: []string{"as", "200/300", "via", "inet", "10.0.0.2", "dev", "eth0"},
+		},
+		{
+			desc:     "Pop to an IPv6 gateway",
+			route:    config.MplsRoute{Label: 100, Via: "2001:db8::2"},
+			expected: []string{"via", "inet6", "2001:db8::2"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			if got := mplsRouteOptions(tc.route); !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("mplsRouteOptions() = %v, want %v", got, tc.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"netnsplan/config"
+	"netnsplan/iproute2"
+	"slices"
+	"strings"
+)
+
+// SetupNeighbors pins the static neighbor entries of the device and
+// removes the ones it has added before that are no longer in the config.
+// Entries added by others or learned by the kernel are left alone.
+func SetupNeighbors(ip IpCommand, name string, neighbors []config.Neighbor) error {
+	installed, err := readDeviceState(ip, "neighbors", name)
+	if err != nil {
+		return err
+	}
+	if len(neighbors) == 0 && len(installed) == 0 {
+		return nil
+	}
+
+	current, err := ip.ListNeighbors(name)
+	if err != nil {
+		return err
+	}
+
+	var addresses []string
+	for _, neigh := range neighbors {
+		state, err := neighborState(neigh)
+		if err != nil {
+			return fmt.Errorf("neighbor %s on %s: %w", neigh.IP, name, err)
+		}
+		addresses = append(addresses, neigh.IP)
+
+		i := slices.IndexFunc(current, func(n iproute2.Neighbor) bool {
+			return sameGateway(n.Dst, neigh.IP)
+		})
+		if i >= 0 && !neighborDrifted(current[i], neigh.LLAddr, state) {
+			slog.Debug("neighbor is already exists", "name", name, "ip", neigh.IP)
+			continue
+		}
+
+		options := []string{"lladdr", neigh.LLAddr, "nud", state}
+		if i < 0 {
+			logWithNetns(ip, "add neighbor", "name", name, "ip", neigh.IP, "lladdr", neigh.LLAddr, "state", state)
+			err = ip.AddNeighbor(name, neigh.IP, options...)
+		} else {
+			logWithNetns(ip, "replace neighbor", "name", name, "ip", neigh.IP, "lladdr", neigh.LLAddr, "state", state)
+			err = ip.ReplaceNeighbor(name, neigh.IP, options...)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, n := range removedNeighbors(current, installed, addresses) {
+		logWithNetns(ip, "delete neighbor", "name", name, "ip", n.Dst)
+		if err := ip.DelNeighbor(name, n.Dst); err != nil {
+			return err
+		}
+	}
+	return writeDeviceState(ip, "neighbors", name, addresses)
+}
+
+// SetupProxyNeighbors makes the device answer ARP/NDP requests for the
+// addresses, and stops answering for the ones it has added before that
+// are removed from the config. NDP proxying also needs proxy_ndp of the
+// device, which is turned on when there is an IPv6 address.
+func SetupProxyNeighbors(ip IpCommand, name string, addresses []string) error {
+	proxyNdp := false
+	for _, address := range addresses {
+		a, err := netip.ParseAddr(address)
+		if err != nil {
+			return fmt.Errorf("invalid proxy neighbor %q on %s", address, name)
+		}
+		if addrFamily(a) == iproute2.FamilyInet6 {
+			proxyNdp = true
+		}
+	}
+
+	installed, err := readDeviceState(ip, "proxy-neighbors", name)
+	if err != nil {
+		return err
+	}
+	if len(addresses) == 0 && len(installed) == 0 {
+		return nil
+	}
+
+	if proxyNdp {
+		err := SetSysctl(ip, "net/ipv6/conf/"+name+"/proxy_ndp", "1", false)
+		if err != nil {
+			return err
+		}
+	}
+
+	current, err := ip.ListProxyNeighbors(name)
+	if err != nil {
+		return err
+	}
+
+	for _, address := range addresses {
+		if slices.ContainsFunc(current, func(n iproute2.Neighbor) bool {
+			return sameGateway(n.Dst, address)
+		}) {
+			slog.Debug("proxy neighbor is already exists", "name", name, "ip", address)
+			continue
+		}
+
+		logWithNetns(ip, "add proxy neighbor", "name", name, "ip", address)
+		if err := ip.AddProxyNeighbor(name, address); err != nil {
+			return err
+		}
+	}
+
+	for _, n := range removedNeighbors(current, installed, addresses) {
+		logWithNetns(ip, "delete proxy neighbor", "name", name, "ip", n.Dst)
+		if err := ip.DelProxyNeighbor(name, n.Dst); err != nil {
+			return err
+		}
+	}
+	return writeDeviceState(ip, "proxy-neighbors", name, addresses)
+}
+
+// removedNeighbors returns the entries of current that netnsplan has
+// installed and that are no longer in addresses.
+func removedNeighbors(current iproute2.Neighbors, installed []string, addresses []string) iproute2.Neighbors {
+	var removed iproute2.Neighbors
+	for _, n := range current {
+		same := func(address string) bool { return sameGateway(n.Dst, address) }
+		if slices.ContainsFunc(installed, same) && !slices.ContainsFunc(addresses, same) {
+			removed = append(removed, n)
+		}
+	}
+	return removed
+}
+
+func neighborState(neigh config.Neighbor) (string, error) {
+	if _, err := netip.ParseAddr(neigh.IP); err != nil {
+		return "", fmt.Errorf("invalid ip %q", neigh.IP)
+	}
+	if neigh.LLAddr == "" {
+		return "", fmt.Errorf("lladdr is required")
+	}
+
+	switch neigh.State {
+	case "":
+		return config.NeighborStatePermanent, nil
+	case config.NeighborStatePermanent, config.NeighborStateNoArp, config.NeighborStateReachable:
+		return neigh.State, nil
+	}
+	return "", fmt.Errorf("unknown state %q", neigh.State)
+}
+
+// neighborDrifted reports whether the entry differs from the config. A
+// reachable entry ages into stale and is only refreshed when its link
+// layer address changes.
+func neighborDrifted(n iproute2.Neighbor, lladdr string, state string) bool {
+	if !strings.EqualFold(n.LLAddr, lladdr) {
+		return true
+	}
+	if state == config.NeighborStateReachable {
+		return false
+	}
+	return !slices.Contains(n.State, strings.ToUpper(state))
+}
@@ -0,0 +1,280 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package cmd
+
+import (
+	"netnsplan/config"
+	"netnsplan/iproute2"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestNeighborState(t *testing.T) {
+	testCases := []struct {
+		desc         string
+		neigh        config.Neighbor
+		expected     string
+		expectingErr bool
+	}{
+		{
+			desc:     "Default state",
+			neigh:    config.Neighbor{IP: "10.0.0.1", LLAddr: "02:00:00:00:00:01"},
+			expected: config.NeighborStatePermanent,
+		},
+		{
+			desc:     "Noarp",
+			neigh:    config.Neighbor{IP: "2001:db8::1", LLAddr: "02:00:00:00:00:01", State: config.NeighborStateNoArp},
+			expected: config.NeighborStateNoArp,
+		},
+		{
+			desc:         "Invalid ip",
+			neigh:        config.Neighbor{IP: "gw", LLAddr: "02:00:00:00:00:01"},
+			expectingErr: true,
+		},
+		{
+			desc:         "No lladdr",
+			neigh:        config.Neighbor{IP: "10.0.0.1"},
+			expectingErr: true,
+		},
+		{
+			desc:         "Unknown state",
+			neigh:        config.Neighbor{IP: "10.0.0.1", LLAddr: "02:00:00:00:00:01", State: "stale"},
+			expectingErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, err := neighborState(tc.neigh)
+			if (err != nil) != tc.expectingErr {
+				t.Fatalf("neighborState() error = %v, expectingErr %v", err, tc.expectingErr)
+			}
+			if got != tc.expected {
+				t.Errorf("neighborState() = %v, want %v", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestNeighborDrifted(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		current  iproute2.Neighbor
+		lladdr   string
+		state    string
+		expected bool
+	}{
+		{
+			desc:     "Same permanent entry",
+			current:  iproute2.Neighbor{Dst: "10.0.0.1", LLAddr: "02:00:00:00:00:01", State: []string{"PERMANENT"}},
+			lladdr:   "02:00:00:00:00:01",
+			state:    config.NeighborStatePermanent,
+			expected: false,
+		},
+		{
+			desc:     "Lladdr in upper case",
+			current:  iproute2.Neighbor{Dst: "10.0.0.1", LLAddr: "02:00:00:00:00:0a", State: []string{"PERMANENT"}},
+			lladdr:   "02:00:00:00:00:0A",
+			state:    config.NeighborStatePermanent,
+			expected: false,
+		},
+		{
+			desc:     "Another lladdr",
+			current:  iproute2.Neighbor{Dst: "10.0.0.1", LLAddr: "02:00:00:00:00:01", State: []string{"PERMANENT"}},
+			lladdr:   "02:00:00:00:00:02",
+			state:    config.NeighborStatePermanent,
+			expected: true,
+		},
+		{
+			desc:     "Learned entry is pinned",
+			current:  iproute2.Neighbor{Dst: "10.0.0.1", LLAddr: "02:00:00:00:00:01", State: []string{"REACHABLE"}},
+			lladdr:   "02:00:00:00:00:01",
+			state:    config.NeighborStatePermanent,
+			expected: true,
+		},
+		{
+			desc:     "Reachable entry has aged",
+			current:  iproute2.Neighbor{Dst: "10.0.0.1", LLAddr: "02:00:00:00:00:01", State: []string{"STALE"}},
+			lladdr:   "02:00:00:00:00:01",
+			state:    config.NeighborStateReachable,
+			expected: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			if got := neighborDrifted(tc.current, tc.lladdr, tc.state); got != tc.expected {
+				t.Errorf("neighborDrifted() = %v, want %v", got, tc.expected)
+			}
+		})
+	}
+}
+
+// fakeProxyNeighbors is an IpCommand in a netns that keeps proxy
+// neighbors and sysctls.
+type fakeProxyNeighbors struct {
+	fakeSysctls
+	proxies iproute2.Neighbors
+}
+
+func (f *fakeProxyNeighbors) ListProxyNeighbors(name string) (iproute2.Neighbors, error) {
+	return f.proxies, nil
+}
+
+func (f *fakeProxyNeighbors) AddProxyNeighbor(name string, address string) error {
+	f.proxies = append(f.proxies, iproute2.Neighbor{Dst: address, Dev: name})
+	return nil
+}
+
+func (f *fakeProxyNeighbors) DelProxyNeighbor(name string, address string) error {
+	f.proxies = slices.DeleteFunc(f.proxies, func(n iproute2.Neighbor) bool { return n.Dst == address })
+	return nil
+}
+
+// useTempRunDir keeps the state that netnsplan records in a temporary
+// directory during the test.
+func useTempRunDir(t *testing.T) {
+	orig := netnsplanRunDir
+	netnsplanRunDir = t.TempDir()
+	t.Cleanup(func() { netnsplanRunDir = orig })
+}
+
+func TestSetupProxyNeighborsProxyNdp(t *testing.T) {
+	useTempRunDir(t)
+	ip := &fakeProxyNeighbors{fakeSysctls: fakeSysctls{sysctls: map[string]string{"net/ipv6/conf/eth0/proxy_ndp": "0"}}}
+	if err := SetupProxyNeighbors(ip, "eth0", []string{"192.168.0.100"}); err != nil {
+		t.Fatalf("SetupProxyNeighbors() error = %v", err)
+	}
+	if got := ip.sysctls["net/ipv6/conf/eth0/proxy_ndp"]; got != "0" {
+		t.Errorf("proxy_ndp = %v with IPv4 proxies only, want 0", got)
+	}
+
+	if err := SetupProxyNeighbors(ip, "eth0", []string{"192.168.0.100", "2001:db8::100"}); err != nil {
+		t.Fatalf("SetupProxyNeighbors() error = %v", err)
+	}
+	if got := ip.sysctls["net/ipv6/conf/eth0/proxy_ndp"]; got != "1" {
+		t.Errorf("proxy_ndp = %v, want 1", got)
+	}
+	expected := iproute2.Neighbors{{Dst: "192.168.0.100", Dev: "eth0"}, {Dst: "2001:db8::100", Dev: "eth0"}}
+	if !reflect.DeepEqual(ip.proxies, expected) {
+		t.Errorf("proxy neighbors = %v, want %v", ip.proxies, expected)
+	}
+}
+
+func TestSetupProxyNeighborsInstalled(t *testing.T) {
+	useTempRunDir(t)
+	other := iproute2.Neighbor{Dst: "192.168.0.200", Dev: "eth0"}
+	ip := &fakeProxyNeighbors{proxies: iproute2.Neighbors{other}}
+
+	// no proxy-neighbors leaves the entries added by others alone
+	if err := SetupProxyNeighbors(ip, "eth0", nil); err != nil {
+		t.Fatalf("SetupProxyNeighbors() error = %v", err)
+	}
+	if err := SetupProxyNeighbors(ip, "eth0", []string{"192.168.0.100"}); err != nil {
+		t.Fatalf("SetupProxyNeighbors() error = %v", err)
+	}
+	if err := SetupProxyNeighbors(ip, "eth0", nil); err != nil {
+		t.Fatalf("SetupProxyNeighbors() error = %v", err)
+	}
+	if expected := (iproute2.Neighbors{other}); !reflect.DeepEqual(ip.proxies, expected) {
+		t.Errorf("proxy neighbors = %v, want %v", ip.proxies, expected)
+	}
+}
+
+// fakeNeighbors keeps neighbor entries and records the changes.
+type fakeNeighbors struct {
+	fakeIp
+	neighbors iproute2.Neighbors
+	host      bool
+}
+
+func (f *fakeNeighbors) InNetns() bool { return !f.host }
+
+func (f *fakeNeighbors) ListNeighbors(name string) (iproute2.Neighbors, error) {
+	return slices.Clone(f.neighbors), nil
+}
+
+func (f *fakeNeighbors) AddNeighbor(name, address string, options ...string) error {
+	f.neighbors = append(f.neighbors, iproute2.Neighbor{Dst: address, Dev: name, LLAddr: options[1], State: []string{"PERMANENT"}})
+	return f.record("add %s", address)
+}
+
+func (f *fakeNeighbors) DelNeighbor(name, address string) error {
+	f.neighbors = slices.DeleteFunc(f.neighbors, func(n iproute2.Neighbor) bool { return n.Dst == address })
+	return f.record("del %s", address)
+}
+
+func TestSetupNeighbors(t *testing.T) {
+	other := iproute2.Neighbor{Dst: "10.1.0.200", Dev: "eth0", LLAddr: "02:00:00:00:00:c8", State: []string{"PERMANENT"}}
+	neighbors := []config.Neighbor{
+		{IP: "10.1.0.254", LLAddr: "02:00:00:00:00:fe"},
+		{IP: "10.1.0.253", LLAddr: "02:00:00:00:00:fd"},
+	}
+
+	testCases := []struct {
+		desc     string
+		host     bool
+		steps    [][]config.Neighbor
+		expected []string
+	}{
+		{
+			desc:     "No neighbors",
+			steps:    [][]config.Neighbor{nil},
+			expected: nil,
+		},
+		{
+			desc:     "Removed entry",
+			steps:    [][]config.Neighbor{neighbors, neighbors[:1]},
+			expected: []string{"add 10.1.0.254", "add 10.1.0.253", "del 10.1.0.253"},
+		},
+		{
+			desc:     "Removed block",
+			steps:    [][]config.Neighbor{neighbors, nil},
+			expected: []string{"add 10.1.0.254", "add 10.1.0.253", "del 10.1.0.254", "del 10.1.0.253"},
+		},
+		{
+			desc:     "Host entries are not deleted",
+			host:     true,
+			steps:    [][]config.Neighbor{neighbors, nil},
+			expected: []string{"add 10.1.0.254", "add 10.1.0.253"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			useTempRunDir(t)
+			ip := &fakeNeighbors{neighbors: iproute2.Neighbors{other}, host: tc.host}
+			for _, step := range tc.steps {
+				if err := SetupNeighbors(ip, "eth0", step); err != nil {
+					t.Fatalf("SetupNeighbors() error = %v", err)
+				}
+			}
+			if !reflect.DeepEqual(ip.calls, tc.expected) {
+				t.Errorf("calls = %v, want %v", ip.calls, tc.expected)
+			}
+			if !slices.ContainsFunc(ip.neighbors, func(n iproute2.Neighbor) bool { return n.Dst == other.Dst }) {
+				t.Errorf("entry added by others is deleted")
+			}
+		})
+	}
+}
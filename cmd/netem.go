@@ -0,0 +1,409 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"netnsplan/config"
+	"netnsplan/iproute2"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// netemDefaultLimit is the queue limit tc gives a netem qdisc.
+const netemDefaultLimit = 1000
+
+// netemParams are the parameters of a netem block as the kernel reports
+// them: times in seconds, probabilities as fractions and the rate in
+// bytes per second.
+type netemParams struct {
+	delay     float64
+	jitter    float64
+	loss      float64
+	duplicate float64
+	reorder   float64
+	corrupt   float64
+	rate      uint64
+	limit     int
+}
+
+// netemStatePath returns where the tc options of the netem qdisc that
+// netnsplan has installed on the device are kept. The distribution
+// cannot be read back from the kernel, so a change of it is found here.
+func netemStatePath(ns string, name string) string {
+	return filepath.Join(netnsplanRunDir, ns, "netem", name)
+}
+
+// SetupNetem installs the netem block as the root qdisc of the device,
+// or changes the options of the installed one in place. A netem qdisc
+// that netnsplan has installed is removed when the block is deleted.
+func SetupNetem(ip IpCommand, name string, netem *config.Netem) error {
+	if !ip.InNetns() {
+		if netem != nil {
+			return fmt.Errorf("netem of %s requires a netns, set it on the veth end in the netns instead", name)
+		}
+		return nil
+	}
+
+	path := netemStatePath(ip.Netns(), name)
+	tc := ip.Tc(flags.TcCmdPath)
+	if netem == nil {
+		return removeNetem(ip, tc, path, name)
+	}
+
+	args, err := netemArgs(name, *netem)
+	if err != nil {
+		return err
+	}
+
+	current, err := showRootQdisc(tc, name)
+	if err != nil {
+		return err
+	}
+	drift, err := netemDrift(current, path, *netem, args)
+	if err != nil {
+		return err
+	}
+	if drift == "" {
+		slog.Debug("netem is already set", "name", name)
+		return nil
+	}
+
+	state, err := readNetemState(path)
+	if err != nil {
+		return err
+	}
+
+	installed := current != nil && current.Kind == "netem"
+	switch {
+	case installed && netem.Distribution == "" && strings.Contains(state, "distribution"):
+		// the kernel keeps the distribution table unless another
+		// one is given, so the qdisc is recreated to drop it
+		logWithNetns(ip, "recreate netem", "name", name, "params", args, "reason", drift)
+		err = tc.DelRootQdisc(name)
+		if err == nil {
+			err = tc.ReplaceRootQdisc(name, "netem", args...)
+		}
+	case installed:
+		logWithNetns(ip, "change netem", "name", name, "params", args, "reason", drift)
+		err = tc.ChangeRootQdisc(name, "netem", args...)
+	default:
+		logWithNetns(ip, "add netem", "name", name, "params", args)
+		err = tc.ReplaceRootQdisc(name, "netem", args...)
+	}
+	if err != nil {
+		return err
+	}
+
+	return writeStateFile(path, strings.Join(args, " ")+"\n")
+}
+
+// NetemDrift returns why the root qdisc of the device differs from the
+// netem block, or an empty string if it does not.
+func NetemDrift(ip IpCommand, name string, netem config.Netem) (string, error) {
+	args, err := netemArgs(name, netem)
+	if err != nil {
+		return "", err
+	}
+
+	current, err := showRootQdisc(ip.Tc(flags.TcCmdPath), name)
+	if err != nil {
+		return "", err
+	}
+	return netemDrift(current, netemStatePath(ip.Netns(), name), netem, args)
+}
+
+func removeNetem(ip IpCommand, tc *iproute2.TcCmd, path string, name string) error {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+
+	current, err := showRootQdisc(tc, name)
+	if err != nil {
+		return err
+	}
+	if current != nil && current.Kind == "netem" {
+		logWithNetns(ip, "remove netem", "name", name)
+		err = tc.DelRootQdisc(name)
+		if err != nil {
+			return err
+		}
+	}
+	return os.Remove(path)
+}
+
+// showRootQdisc returns the root qdisc of the device, or nil if it has
+// none.
+func showRootQdisc(tc *iproute2.TcCmd, name string) (*iproute2.Qdisc, error) {
+	current, err := tc.ShowRootQdisc(name)
+	if err != nil {
+		if _, ok := err.(*iproute2.NotExistError); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return current, nil
+}
+
+func readNetemState(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func netemDrift(current *iproute2.Qdisc, path string, netem config.Netem, args []string) (string, error) {
+	if current == nil || current.Kind != "netem" {
+		return "netem is not installed", nil
+	}
+
+	options, err := current.Netem()
+	if err != nil {
+		return "", err
+	}
+	want, err := parseNetem(netem)
+	if err != nil {
+		return "", err
+	}
+	if diff := netemParamsDiff(netemParamsOf(options), want); len(diff) > 0 {
+		return strings.Join(diff, ", ") + " differ", nil
+	}
+
+	state, err := readNetemState(path)
+	if err != nil {
+		return "", err
+	}
+	if state != strings.Join(args, " ") {
+		return "declared netem has changed", nil
+	}
+	return "", nil
+}
+
+// netemArgs returns the tc options of the netem block.
+func netemArgs(name string, netem config.Netem) ([]string, error) {
+	if _, err := parseNetem(netem); err != nil {
+		return nil, fmt.Errorf("netem of %s: %w", name, err)
+	}
+
+	var args []string
+	if netem.Delay != "" {
+		args = append(args, "delay", netem.Delay)
+		if netem.Jitter != "" {
+			args = append(args, netem.Jitter)
+		}
+		if netem.Distribution != "" {
+			args = append(args, "distribution", netem.Distribution)
+		}
+	}
+	for _, param := range []struct {
+		name  string
+		value string
+	}{
+		{"loss", netem.Loss},
+		{"duplicate", netem.Duplicate},
+		{"reorder", netem.Reorder},
+		{"corrupt", netem.Corrupt},
+		{"rate", netem.Rate},
+	} {
+		if param.value != "" {
+			args = append(args, param.name, param.value)
+		}
+	}
+	if netem.Limit != 0 {
+		args = append(args, "limit", strconv.Itoa(netem.Limit))
+	}
+	return args, nil
+}
+
+func parseNetem(netem config.Netem) (netemParams, error) {
+	params := netemParams{limit: netemDefaultLimit}
+	var err error
+
+	if netem.Jitter != "" && netem.Delay == "" {
+		return params, fmt.Errorf("jitter requires delay")
+	}
+	if netem.Distribution != "" && netem.Jitter == "" {
+		return params, fmt.Errorf("distribution requires jitter")
+	}
+	if netem.Reorder != "" && netem.Delay == "" {
+		return params, fmt.Errorf("reorder requires delay")
+	}
+
+	for _, t := range []struct {
+		value string
+		dst   *float64
+	}{
+		{netem.Delay, &params.delay},
+		{netem.Jitter, &params.jitter},
+	} {
+		if t.value == "" {
+			continue
+		}
+		*t.dst, err = parseTcTime(t.value)
+		if err != nil {
+			return params, err
+		}
+	}
+
+	for _, p := range []struct {
+		value string
+		dst   *float64
+	}{
+		{netem.Loss, &params.loss},
+		{netem.Duplicate, &params.duplicate},
+		{netem.Reorder, &params.reorder},
+		{netem.Corrupt, &params.corrupt},
+	} {
+		if p.value == "" {
+			continue
+		}
+		*p.dst, err = parseTcPercent(p.value)
+		if err != nil {
+			return params, err
+		}
+	}
+
+	if netem.Rate != "" {
+		params.rate, err = parseTcRate(netem.Rate)
+		if err != nil {
+			return params, err
+		}
+	}
+
+	if netem.Limit < 0 {
+		return params, fmt.Errorf("invalid limit %d", netem.Limit)
+	}
+	if netem.Limit != 0 {
+		params.limit = netem.Limit
+	}
+	return params, nil
+}
+
+func netemParamsOf(options *iproute2.NetemOptions) netemParams {
+	params := netemParams{
+		limit:     options.Limit,
+		loss:      options.Loss["loss"],
+		duplicate: options.Duplicate["duplicate"],
+		reorder:   options.Reorder["reorder"],
+		corrupt:   options.Corrupt["corrupt"],
+	}
+	if options.Delay != nil {
+		params.delay = options.Delay.Delay
+		params.jitter = options.Delay.Jitter
+	}
+	if options.Rate != nil {
+		params.rate = options.Rate.Rate
+	}
+	return params
+}
+
+// netemParamsDiff returns the names of the parameters that differ. Times
+// are compared to the microsecond and probabilities to the precision the
+// kernel keeps them in.
+func netemParamsDiff(current, want netemParams) []string {
+	var diff []string
+	for _, t := range []struct {
+		name          string
+		current, want float64
+		tolerance     float64
+	}{
+		{"delay", current.delay, want.delay, 1e-6},
+		{"jitter", current.jitter, want.jitter, 1e-6},
+		{"loss", current.loss, want.loss, 1e-6},
+		{"duplicate", current.duplicate, want.duplicate, 1e-6},
+		{"reorder", current.reorder, want.reorder, 1e-6},
+		{"corrupt", current.corrupt, want.corrupt, 1e-6},
+	} {
+		if math.Abs(t.current-t.want) > t.tolerance {
+			diff = append(diff, t.name)
+		}
+	}
+	if current.rate != want.rate {
+		diff = append(diff, "rate")
+	}
+	if current.limit != want.limit {
+		diff = append(diff, "limit")
+	}
+	return diff
+}
+
+// parseTcTime parses a tc time such as "100ms" into seconds.
+func parseTcTime(s string) (float64, error) {
+	for _, unit := range []struct {
+		suffix string
+		scale  float64
+	}{
+		{"usecs", 1e-6}, {"usec", 1e-6}, {"us", 1e-6},
+		{"msecs", 1e-3}, {"msec", 1e-3}, {"ms", 1e-3},
+		{"secs", 1}, {"sec", 1}, {"s", 1},
+	} {
+		if v, ok := strings.CutSuffix(s, unit.suffix); ok {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil || f < 0 {
+				break
+			}
+			return f * unit.scale, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid time %q", s)
+}
+
+// parseTcPercent parses a percentage such as "1%" into a fraction. The
+// percent sign is optional, as it is for tc.
+func parseTcPercent(s string) (float64, error) {
+	f, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+	if err != nil || f < 0 || f > 100 {
+		return 0, fmt.Errorf("invalid percentage %q", s)
+	}
+	return f / 100, nil
+}
+
+// parseTcRate parses a tc rate such as "1mbit" into bytes per second.
+func parseTcRate(s string) (uint64, error) {
+	lower := strings.ToLower(s)
+	for _, unit := range []struct {
+		suffix string
+		bits   float64
+	}{
+		{"kibit", 1024}, {"mibit", 1024 * 1024}, {"gibit", 1024 * 1024 * 1024}, {"tibit", 1024 * 1024 * 1024 * 1024},
+		{"kbit", 1e3}, {"mbit", 1e6}, {"gbit", 1e9}, {"tbit", 1e12}, {"bit", 1},
+		{"kibps", 8 * 1024}, {"mibps", 8 * 1024 * 1024}, {"gibps", 8 * 1024 * 1024 * 1024}, {"tibps", 8 * 1024 * 1024 * 1024 * 1024},
+		{"kbps", 8e3}, {"mbps", 8e6}, {"gbps", 8e9}, {"tbps", 8e12}, {"bps", 8},
+	} {
+		if v, ok := strings.CutSuffix(lower, unit.suffix); ok {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil || f <= 0 {
+				break
+			}
+			return uint64(f * unit.bits / 8), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid rate %q", s)
+}
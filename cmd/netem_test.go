@@ -0,0 +1,187 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"netnsplan/config"
+	"netnsplan/iproute2"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestNetemArgs(t *testing.T) {
+	testCases := []struct {
+		desc         string
+		netem        config.Netem
+		expected     []string
+		expectingErr bool
+	}{
+		{
+			desc: "All parameters",
+			netem: config.Netem{
+				Delay:        "100ms",
+				Jitter:       "10ms",
+				Distribution: "normal",
+				Loss:         "1%",
+				Duplicate:    "0.5%",
+				Reorder:      "25%",
+				Corrupt:      "0.1%",
+				Rate:         "1mbit",
+				Limit:        100,
+			},
+			expected: []string{
+				"delay", "100ms", "10ms", "distribution", "normal",
+				"loss", "1%", "duplicate", "0.5%", "reorder", "25%", "corrupt", "0.1%",
+				"rate", "1mbit", "limit", "100",
+			},
+		},
+		{
+			desc:     "Loss only",
+			netem:    config.Netem{Loss: "5"},
+			expected: []string{"loss", "5"},
+		},
+		{
+			desc:         "Jitter without delay",
+			netem:        config.Netem{Jitter: "10ms"},
+			expectingErr: true,
+		},
+		{
+			desc:         "Distribution without jitter",
+			netem:        config.Netem{Delay: "100ms", Distribution: "normal"},
+			expectingErr: true,
+		},
+		{
+			desc:         "Reorder without delay",
+			netem:        config.Netem{Reorder: "25%"},
+			expectingErr: true,
+		},
+		{
+			desc:         "Invalid time",
+			netem:        config.Netem{Delay: "100"},
+			expectingErr: true,
+		},
+		{
+			desc:         "Invalid percentage",
+			netem:        config.Netem{Loss: "101%"},
+			expectingErr: true,
+		},
+		{
+			desc:         "Invalid rate",
+			netem:        config.Netem{Rate: "fast"},
+			expectingErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, err := netemArgs("eth0", tc.netem)
+			if (err != nil) != tc.expectingErr {
+				t.Fatalf("netemArgs() error = %v, expectingErr %v", err, tc.expectingErr)
+			}
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("netemArgs() = %v, want %v", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestParseTcUnits(t *testing.T) {
+	for s, expected := range map[string]float64{"100ms": 0.1, "1.5s": 1.5, "500us": 0.0005, "2secs": 2} {
+		if got, err := parseTcTime(s); err != nil || got != expected {
+			t.Errorf("parseTcTime(%q) = %v, %v, want %v", s, got, err, expected)
+		}
+	}
+	for s, expected := range map[string]uint64{"1mbit": 125000, "8kbit": 1000, "1kibit": 128, "2kbps": 2000, "100bps": 100, "1Gbit": 125000000} {
+		if got, err := parseTcRate(s); err != nil || got != expected {
+			t.Errorf("parseTcRate(%q) = %v, %v, want %v", s, got, err, expected)
+		}
+	}
+}
+
+func TestNetemDrift(t *testing.T) {
+	netem := config.Netem{Delay: "100ms", Jitter: "10ms", Distribution: "normal", Loss: "1%", Rate: "1mbit"}
+	args, err := netemArgs("eth0", netem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "eth0")
+	if err := os.WriteFile(path, []byte("delay 100ms 10ms distribution normal loss 1% rate 1mbit\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	qdisc := func(options string) *iproute2.Qdisc {
+		return &iproute2.Qdisc{Kind: "netem", Handle: "8001:", Root: true, Options: json.RawMessage(options)}
+	}
+	testCases := []struct {
+		desc     string
+		current  *iproute2.Qdisc
+		netem    config.Netem
+		expected string
+	}{
+		{
+			desc:     "Up to date",
+			current:  qdisc(`{"limit":1000,"delay":{"delay":0.1,"jitter":0.01,"correlation":0},"loss-random":{"loss":0.01,"correlation":0},"rate":{"rate":125000,"packetoverhead":0},"ecn":false}`),
+			netem:    netem,
+			expected: "",
+		},
+		{
+			desc:     "Not installed",
+			current:  &iproute2.Qdisc{Kind: "noqueue", Handle: "0:", Root: true},
+			netem:    netem,
+			expected: "netem is not installed",
+		},
+		{
+			desc:     "Changed live",
+			current:  qdisc(`{"limit":100,"delay":{"delay":0.2,"jitter":0.01,"correlation":0},"rate":{"rate":125000,"packetoverhead":0}}`),
+			netem:    netem,
+			expected: "delay, loss, limit differ",
+		},
+		{
+			desc:     "Distribution changed",
+			current:  qdisc(`{"limit":1000,"delay":{"delay":0.1,"jitter":0.01,"correlation":0},"loss-random":{"loss":0.01,"correlation":0},"rate":{"rate":125000,"packetoverhead":0}}`),
+			netem:    config.Netem{Delay: "100ms", Jitter: "10ms", Distribution: "pareto", Loss: "1%", Rate: "1mbit"},
+			expected: "declared netem has changed",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			a := args
+			if tc.netem != netem {
+				a, err = netemArgs("eth0", tc.netem)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := netemDrift(tc.current, path, tc.netem, a)
+			if err != nil {
+				t.Fatalf("netemDrift() error = %v", err)
+			}
+			if got != tc.expected {
+				t.Errorf("netemDrift() = %q, want %q", got, tc.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"netnsplan/config"
+	"netnsplan/iproute2"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SetupNexthops adds the nexthop objects of a netns and replaces the ones
+// that differ from the config. Groups are set up after the nexthops they
+// consist of.
+func SetupNexthops(ip IpCommand, nexthops []config.NexthopObject) error {
+	if len(nexthops) == 0 {
+		return nil
+	}
+
+	current, err := ip.ListNexthops()
+	if err != nil {
+		return err
+	}
+
+	ordered := slices.Clone(nexthops)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return len(ordered[i].Group) == 0 && len(ordered[j].Group) > 0
+	})
+
+	for _, nh := range ordered {
+		if len(nh.Group) == 0 && !nh.Blackhole && nh.Dev == "" {
+			return fmt.Errorf("nexthop %d needs dev unless it is a group or a blackhole", nh.ID)
+		}
+
+		i := slices.IndexFunc(current, func(c iproute2.Nexthop) bool {
+			return c.ID == nh.ID
+		})
+		if i >= 0 && !nexthopDrifted(current[i], nh) {
+			slog.Debug("nexthop is already exists", "id", nh.ID)
+			continue
+		}
+
+		options := nexthopOptions(nh)
+		if i >= 0 && !nexthopReplaceable(current[i], nh) {
+			// the kernel also removes the routes using the nexthop and
+			// the nexthop from its groups, which are set up again later
+			logWithNetns(ip, "delete nexthop to recreate it", "id", nh.ID)
+			err = ip.DelNexthop(nh.ID)
+			if err != nil {
+				return err
+			}
+
+			current, err = ip.ListNexthops()
+			if err != nil {
+				return err
+			}
+			i = -1
+		}
+
+		if i < 0 {
+			logWithNetns(ip, "add nexthop", "id", nh.ID, "nexthop", strings.Join(options, " "))
+			err = ip.AddNexthop(nh.ID, options...)
+		} else {
+			logWithNetns(ip, "replace nexthop", "id", nh.ID, "nexthop", strings.Join(options, " "))
+			err = ip.ReplaceNexthop(nh.ID, options...)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func nexthopOptions(nh config.NexthopObject) []string {
+	if len(nh.Group) > 0 {
+		var members []string
+		for _, m := range nh.Group {
+			member := strconv.Itoa(m.ID)
+			if m.Weight != 0 {
+				member += "," + strconv.Itoa(m.Weight)
+			}
+			members = append(members, member)
+		}
+		options := []string{"group", strings.Join(members, "/")}
+
+		if r := nh.Resilient; r != nil {
+			options = append(options, "type", "resilient")
+			if r.Buckets != 0 {
+				options = append(options, "buckets", strconv.Itoa(r.Buckets))
+			}
+			if r.IdleTimer != 0 {
+				options = append(options, "idle_timer", strconv.Itoa(r.IdleTimer))
+			}
+			if r.UnbalancedTimer != 0 {
+				options = append(options, "unbalanced_timer", strconv.Itoa(r.UnbalancedTimer))
+			}
+		}
+		return options
+	}
+
+	if nh.Blackhole {
+		return []string{"blackhole"}
+	}
+
+	var options []string
+	if nh.Via != "" {
+		options = append(options, "via", nh.Via)
+	}
+	if nh.Dev != "" {
+		options = append(options, "dev", nh.Dev)
+	}
+	return options
+}
+
+func nexthopDrifted(c iproute2.Nexthop, nh config.NexthopObject) bool {
+	if c.Blackhole != nh.Blackhole || !sameGateway(c.Gateway, nh.Via) {
+		return true
+	}
+	if nh.Dev != "" && c.Dev != nh.Dev {
+		return true
+	}
+	if !sameNexthopGroup(c.Group, nh.Group) {
+		return true
+	}
+
+	if (c.Type == "resilient") != (nh.Resilient != nil) {
+		return true
+	}
+	if r := nh.Resilient; r != nil && c.ResilientArgs != nil {
+		a := c.ResilientArgs
+		if (r.Buckets != 0 && a.Buckets != r.Buckets) ||
+			(r.IdleTimer != 0 && a.IdleTimer != r.IdleTimer) ||
+			(r.UnbalancedTimer != 0 && a.UnbalancedTimer != r.UnbalancedTimer) {
+			return true
+		}
+	}
+	return false
+}
+
+// nexthopReplaceable reports whether the kernel can replace c with nh in
+// place. It refuses to turn a nexthop into a group or back, to change the
+// type of a group, or to change the buckets of a resilient group.
+func nexthopReplaceable(c iproute2.Nexthop, nh config.NexthopObject) bool {
+	if (len(c.Group) > 0) != (len(nh.Group) > 0) {
+		return false
+	}
+	if (c.Type == "resilient") != (nh.Resilient != nil) {
+		return false
+	}
+	if r := nh.Resilient; r != nil && r.Buckets != 0 && c.ResilientArgs != nil && c.ResilientArgs.Buckets != r.Buckets {
+		return false
+	}
+	return true
+}
+
+// sameNexthopGroup compares group members regardless of their order.
+// An unset weight is 1.
+func sameNexthopGroup(current []iproute2.NexthopGroupMember, group []config.NexthopGroupMember) bool {
+	if len(current) != len(group) {
+		return false
+	}
+
+	weight := func(w int) int {
+		if w == 0 {
+			return 1
+		}
+		return w
+	}
+	weights := map[int]int{}
+	for _, m := range current {
+		weights[m.ID] = weight(m.Weight)
+	}
+	for _, m := range group {
+		w, ok := weights[m.ID]
+		if !ok || w != weight(m.Weight) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkNexthopRoute validates a route that refers to a nexthop object.
+// The nexthop object decides the device and the gateway.
+func checkNexthopRoute(name string, route config.Route) error {
+	if name != "" {
+		return fmt.Errorf("route to %s with nhid must be defined in the netns routes instead of %s", route.To, name)
+	}
+	if route.Via != "" || len(route.Nexthops) > 0 {
+		return fmt.Errorf("route to %s cannot have nhid with via or nexthops", route.To)
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package cmd
+
+import (
+	"netnsplan/config"
+	"netnsplan/iproute2"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+// fakeNexthops records the nexthop changes. Deleted nexthops are removed
+// from the list.
+type fakeNexthops struct {
+	fakeIp
+	nexthops iproute2.Nexthops
+}
+
+func (f *fakeNexthops) ListNexthops() (iproute2.Nexthops, error) {
+	return slices.Clone(f.nexthops), nil
+}
+
+func (f *fakeNexthops) AddNexthop(id int, options ...string) error {
+	return f.record("add %d %v", id, options)
+}
+
+func (f *fakeNexthops) ReplaceNexthop(id int, options ...string) error {
+	return f.record("replace %d %v", id, options)
+}
+
+func (f *fakeNexthops) DelNexthop(id int) error {
+	f.nexthops = slices.DeleteFunc(f.nexthops, func(nh iproute2.Nexthop) bool { return nh.ID == id })
+	return f.record("del %d", id)
+}
+
+func TestNexthopReplaceable(t *testing.T) {
+	single := iproute2.Nexthop{ID: 10, Gateway: "10.0.0.1", Dev: "eth0"}
+	group := iproute2.Nexthop{ID: 10, Group: []iproute2.NexthopGroupMember{{ID: 1}, {ID: 2}}}
+	resilient := iproute2.Nexthop{
+		ID: 10, Group: []iproute2.NexthopGroupMember{{ID: 1}, {ID: 2}},
+		Type: "resilient", ResilientArgs: &iproute2.ResilientArgs{Buckets: 32, IdleTimer: 120},
+	}
+	members := []config.NexthopGroupMember{{ID: 1}, {ID: 2}}
+
+	testCases := []struct {
+		desc     string
+		current  iproute2.Nexthop
+		nexthop  config.NexthopObject
+		expected bool
+	}{
+		{
+			desc:     "Gateway changes",
+			current:  single,
+			nexthop:  config.NexthopObject{ID: 10, Via: "10.0.0.2", Dev: "eth0"},
+			expected: true,
+		},
+		{
+			desc:     "Single becomes blackhole",
+			current:  single,
+			nexthop:  config.NexthopObject{ID: 10, Blackhole: true},
+			expected: true,
+		},
+		{
+			desc:     "Single becomes group",
+			current:  single,
+			nexthop:  config.NexthopObject{ID: 10, Group: members},
+			expected: false,
+		},
+		{
+			desc:     "Group becomes single",
+			current:  group,
+			nexthop:  config.NexthopObject{ID: 10, Via: "10.0.0.2", Dev: "eth0"},
+			expected: false,
+		},
+		{
+			desc:     "Group members change",
+			current:  group,
+			nexthop:  config.NexthopObject{ID: 10, Group: []config.NexthopGroupMember{{ID: 1}, {ID: 2, Weight: 2}}},
+			expected: true,
+		},
+		{
+			desc:     "Group becomes resilient",
+			current:  group,
+			nexthop:  config.NexthopObject{ID: 10, Group: members, Resilient: &config.Resilient{}},
+			expected: false,
+		},
+		{
+			desc:     "Resilient becomes plain group",
+			current:  resilient,
+			nexthop:  config.NexthopObject{ID: 10, Group: members},
+			expected: false,
+		},
+		{
+			desc:     "Resilient timer changes",
+			current:  resilient,
+			nexthop:  config.NexthopObject{ID: 10, Group: members, Resilient: &config.Resilient{Buckets: 32, IdleTimer: 60}},
+			expected: true,
+		},
+		{
+			desc:     "Resilient buckets change",
+			current:  resilient,
+			nexthop:  config.NexthopObject{ID: 10, Group: members, Resilient: &config.Resilient{Buckets: 64}},
+			expected: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			if got := nexthopReplaceable(tc.current, tc.nexthop); got != tc.expected {
+				t.Errorf("nexthopReplaceable() = %v, want %v", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestSetupNexthopsRecreate(t *testing.T) {
+	f := &fakeNexthops{
+		fakeIp: fakeIp{fakeLinks: fakeLinks{links: map[string]*iproute2.Link{}}},
+		nexthops: iproute2.Nexthops{
+			{ID: 1, Gateway: "10.0.0.1", Dev: "eth0"},
+			{ID: 2, Gateway: "10.0.0.2", Dev: "eth0"},
+			{ID: 10, Gateway: "10.0.0.3", Dev: "eth0"},
+		},
+	}
+	nexthops := []config.NexthopObject{
+		{ID: 10, Group: []config.NexthopGroupMember{{ID: 1}, {ID: 2}}},
+		{ID: 1, Via: "10.0.0.1", Dev: "eth0"},
+		{ID: 2, Via: "10.0.0.4", Dev: "eth0"},
+	}
+
+	if err := SetupNexthops(f, nexthops); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"replace 2 [via 10.0.0.4 dev eth0]",
+		"del 10",
+		"add 10 [group 1/2]",
+	}
+	if !reflect.DeepEqual(f.calls, expected) {
+		t.Errorf("calls = %v, want %v", f.calls, expected)
+	}
+}
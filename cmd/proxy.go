@@ -0,0 +1,147 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"netnsplan/config"
+	"netnsplan/netns"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const proxyDialTimeout = 10 * time.Second
+
+var proxyCmd = &cobra.Command{
+	Use:   "proxy",
+	Short: "Relay port forwards that cannot be done with DNAT",
+	Long: `Relay the port forwards with proxy, or with a loopback target, into their netns.
+"apply" installs the DNAT rules of the other port forwards, but these need this command to be running.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+
+		names := make([]string, 0, len(cfg.Netns))
+		for name := range cfg.Netns {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		var listeners []net.Listener
+		for _, name := range names {
+			for _, pf := range cfg.Netns[name].PortForwards {
+				if err := checkPortForward(name, pf); err != nil {
+					return err
+				}
+				if !useProxy(pf) {
+					continue
+				}
+
+				l, err := net.Listen("tcp", portForwardListenAddress(pf))
+				if err != nil {
+					for _, l := range listeners {
+						l.Close()
+					}
+					return err
+				}
+				listeners = append(listeners, l)
+
+				slog.Info("start proxy", "listen", l.Addr(), "netns", name, "target", portForwardTargetAddress(pf))
+				go RunProxy(l, name, pf)
+			}
+		}
+		if len(listeners) == 0 {
+			slog.Warn("no port forward uses the proxy")
+			return nil
+		}
+
+		<-ctx.Done()
+		for _, l := range listeners {
+			l.Close()
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(proxyCmd)
+}
+
+// RunProxy accepts connections on l and relays each of them to the
+// target of the port forward in the netns, until l is closed.
+func RunProxy(l net.Listener, ns string, pf config.PortForward) {
+	target := portForwardTargetAddress(pf)
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			return
+		}
+		go relay(conn, ns, target)
+	}
+}
+
+func relay(conn net.Conn, ns string, target string) {
+	defer conn.Close()
+
+	var upstream net.Conn
+	err := netns.Do(ns, func() error {
+		var err error
+		upstream, err = net.DialTimeout("tcp", target, proxyDialTimeout)
+		return err
+	})
+	if err != nil {
+		slog.Warn("failed to connect to port forward target", "netns", ns, "target", target, "client", conn.RemoteAddr(), "err", err)
+		return
+	}
+	defer upstream.Close()
+	slog.Debug("relay connection", "netns", ns, "target", target, "client", conn.RemoteAddr())
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		io.Copy(upstream, conn)
+		closeWrite(upstream)
+	}()
+	io.Copy(conn, upstream)
+	closeWrite(conn)
+	wg.Wait()
+}
+
+// closeWrite tells the peer that no more data is sent, while the other
+// direction may still be open.
+func closeWrite(conn net.Conn) {
+	if c, ok := conn.(*net.TCPConn); ok {
+		c.CloseWrite()
+	}
+}
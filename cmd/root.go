@@ -35,9 +35,14 @@ import (
 )
 
 type Flags struct {
-	ConfigDir    string
-	IpCmdPath    string
-	Debug, Quiet bool
+	ConfigDir      string
+	IpCmdPath      string
+	EthtoolCmdPath string
+	BridgeCmdPath  string
+	NftCmdPath     string
+	TcCmdPath      string
+	SysctlCmdPath  string
+	Debug, Quiet   bool
 }
 
 var flags Flags
@@ -97,6 +102,11 @@ func Execute() {
 func init() {
 	rootCmd.PersistentFlags().StringVarP(&flags.ConfigDir, "config-dir", "d", "/etc/netnsplan", "config file directory")
 	rootCmd.PersistentFlags().StringVar(&flags.IpCmdPath, "cmd", "/bin/ip", "ip command path")
+	rootCmd.PersistentFlags().StringVar(&flags.EthtoolCmdPath, "ethtool-cmd", "/sbin/ethtool", "ethtool command path")
+	rootCmd.PersistentFlags().StringVar(&flags.BridgeCmdPath, "bridge-cmd", "/sbin/bridge", "bridge command path")
+	rootCmd.PersistentFlags().StringVar(&flags.NftCmdPath, "nft-cmd", "/usr/sbin/nft", "nft command path")
+	rootCmd.PersistentFlags().StringVar(&flags.TcCmdPath, "tc-cmd", "/sbin/tc", "tc command path")
+	rootCmd.PersistentFlags().StringVar(&flags.SysctlCmdPath, "sysctl-cmd", "/sbin/sysctl", "sysctl command path")
 
 	rootCmd.PersistentFlags().BoolVar(&flags.Debug, "debug", false, "debug mode")
 	rootCmd.PersistentFlags().BoolVarP(&flags.Quiet, "quiet", "q", false, "debug mode")
@@ -0,0 +1,460 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"netnsplan/config"
+	"netnsplan/iproute2"
+	"slices"
+	"strconv"
+)
+
+// SetupRoutes adds the routes bound to the device name, or the
+// namespace-level routes if name is empty. A route whose identity
+// (table, destination and metric) already exists is replaced when any
+// of its other attributes differ.
+func SetupRoutes(ip IpCommand, name string, routes []config.Route, tables map[string]int) error {
+	if len(routes) == 0 {
+		return nil
+	}
+
+	// routes are listed per address family
+	listed := map[string]iproute2.Routes{}
+	var err error
+	for _, route := range routes {
+		if name != "" && !routeHasDevice(route) {
+			return fmt.Errorf("%s route to %s has no device, define it in the netns routes instead of %s", route.Type, route.To, name)
+		}
+		if route.Nhid != 0 {
+			if err := checkNexthopRoute(name, route); err != nil {
+				return err
+			}
+		}
+		if len(route.Nexthops) > 0 {
+			if name != "" {
+				return fmt.Errorf("multipath route to %s must be defined in the netns routes instead of %s", route.To, name)
+			}
+			if route.Via != "" {
+				return fmt.Errorf("multipath route to %s cannot have both via and nexthops", route.To)
+			}
+		}
+
+		family := routeFamily(route)
+		rt, ok := listed[family]
+		if !ok {
+			rt, err = ip.ListRoutes(family)
+			if err != nil {
+				return err
+			}
+			listed[family] = rt
+		}
+
+		slog.Debug("route", "name", name, "route", route, "rt", rt)
+		i := slices.IndexFunc(rt, func(r iproute2.Route) bool {
+			return matchRoute(r, route, tables)
+		})
+		if i >= 0 && !routeDrifted(rt[i], name, route, tables) {
+			slog.Debug("route is already exists", "name", name, "to", route.To, "via", route.Via)
+			continue
+		}
+
+		args := []any{"to", route.To, "via", route.Via, "table", routeTable(route), "metric", route.Metric}
+		if name != "" {
+			args = append([]any{"name", name}, args...)
+		}
+		if route.Type != "" {
+			args = append(args, "type", route.Type)
+		}
+
+		if len(route.Nexthops) > 0 {
+			args = append(args, "nexthops", route.Nexthops)
+		}
+		if route.Nhid != 0 {
+			args = append(args, "nhid", route.Nhid)
+		}
+
+		nexthops := routeNexthops(route)
+		switch {
+		case i < 0 && nexthops != nil:
+			logWithNetns(ip, "add route", args...)
+			err = ip.AddMultipathRoute(routeDst(route), nexthops, routeOptions(route)...)
+		case i < 0:
+			logWithNetns(ip, "add route", args...)
+			err = ip.AddRoute(name, routeDst(route), route.Via, routeOptions(route)...)
+		case nexthops != nil:
+			logWithNetns(ip, "replace route", args...)
+			err = ip.ReplaceMultipathRoute(routeDst(route), nexthops, routeOptions(route)...)
+		default:
+			logWithNetns(ip, "replace route", args...)
+			err = ip.ReplaceRoute(name, routeDst(route), route.Via, routeOptions(route)...)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func routeOptions(route config.Route) []string {
+	var options []string
+	if route.From != "" {
+		options = append(options, "src", route.From)
+	}
+	if route.Metric != 0 {
+		options = append(options, "metric", strconv.Itoa(route.Metric))
+	}
+	if route.Table != "" {
+		options = append(options, "table", route.Table)
+	}
+	if route.Scope != "" {
+		options = append(options, "scope", route.Scope)
+	}
+	if route.Mtu != 0 {
+		options = append(options, "mtu", strconv.Itoa(route.Mtu))
+	}
+	if route.AdvMSS != 0 {
+		options = append(options, "advmss", strconv.Itoa(route.AdvMSS))
+	}
+	if route.Protocol != "" {
+		options = append(options, "proto", route.Protocol)
+	}
+	if route.Nhid != 0 {
+		options = append(options, "nhid", strconv.Itoa(route.Nhid))
+	}
+	if route.OnLink {
+		options = append(options, "onlink")
+	}
+	if route.Encap != nil {
+		options = append(options, encapOptions(*route.Encap)...)
+	}
+	// the route type must come right before the destination
+	if route.Type != "" {
+		options = append(options, route.Type)
+	}
+	return options
+}
+
+func routeNexthops(route config.Route) []iproute2.RouteNexthop {
+	var nexthops []iproute2.RouteNexthop
+	for _, nh := range route.Nexthops {
+		nexthops = append(nexthops, iproute2.RouteNexthop{Gateway: nh.Via, Dev: nh.Dev, Weight: nh.Weight})
+	}
+	return nexthops
+}
+
+// routeHasDevice reports whether the route type needs an output device.
+// Blackhole, unreachable and prohibit routes only drop packets.
+func routeHasDevice(route config.Route) bool {
+	switch route.Type {
+	case config.RouteTypeBlackhole, config.RouteTypeUnreachable, config.RouteTypeProhibit:
+		return false
+	}
+	return true
+}
+
+// matchRoute reports whether r has the same identity as the config
+// route. The kernel keys a route on its table, destination and metric,
+// so adding another route with the same identity fails. The device is
+// not part of it; checkRouteConflicts rejects a config that sets the
+// same route on two devices.
+func matchRoute(r iproute2.Route, route config.Route, tables map[string]int) bool {
+	return sameTable(r.Table, routeTable(route), tables) &&
+		sameDst(r.Dst, routeDst(route), routeFamily(route)) &&
+		r.Metric == routeMetric(route)
+}
+
+// checkRouteConflicts returns an error when a netns has more than one
+// route with the same identity (see matchRoute), e.g. on two devices.
+// Only one of them can be installed, and each apply would replace the
+// other.
+func checkRouteConflicts(cfg *config.Config) error {
+	owners := map[string]string{}
+	check := func(netns string, name string, routes []config.Route) error {
+		tables := cfg.Netns[netns].RouteTables
+		for _, route := range routes {
+			dst := routeDst(route)
+			if p, ok := parseDst(dst, routeFamily(route)); ok {
+				dst = p.String()
+			}
+			key := fmt.Sprintf("%s %s %s %d", netns, tableID(routeTable(route), tables), dst, routeMetric(route))
+			if other, ok := owners[key]; ok {
+				where := "netns " + netns
+				if netns == "" {
+					where = "the root netns"
+				}
+				return fmt.Errorf("route to %s in table %s is defined twice in %s: %s and %s", route.To, routeTable(route), where, other, name)
+			}
+			owners[key] = name
+		}
+		return nil
+	}
+
+	netnsNames := make([]string, 0, len(cfg.Netns))
+	for netns := range cfg.Netns {
+		netnsNames = append(netnsNames, netns)
+	}
+	slices.Sort(netnsNames)
+	for _, netns := range netnsNames {
+		err := check(netns, "routes", cfg.Netns[netns].Routes)
+		if err != nil {
+			return err
+		}
+	}
+	for _, d := range ListDevices(cfg) {
+		err := check(d.Netns, d.Name, d.Values.Routes)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// routeDrifted reports whether attributes other than the identity differ
+// from the config. Optional attributes are compared only when set.
+func routeDrifted(r iproute2.Route, name string, route config.Route, tables map[string]int) bool {
+	if route.Nhid != 0 || r.Nhid != 0 {
+		// the paths and the type come from the nexthop object
+		if r.Nhid != route.Nhid {
+			return true
+		}
+	} else {
+		if routeType(r.Type) != routeType(route.Type) {
+			return true
+		}
+		if name != "" && r.Dev != name {
+			return true
+		}
+		if len(route.Nexthops) == 1 && len(r.Nexthops) == 0 {
+			// the kernel stores a single nexthop as a plain route
+			nh := route.Nexthops[0]
+			if !sameGateway(r.Gateway, nh.Via) || (nh.Dev != "" && r.Dev != nh.Dev) {
+				return true
+			}
+		} else {
+			if !sameGateway(r.Gateway, route.Via) {
+				return true
+			}
+			if !sameNexthops(r.Nexthops, route.Nexthops) {
+				return true
+			}
+		}
+	}
+	if route.OnLink != slices.Contains(r.Flags, "onlink") {
+		return true
+	}
+	if route.From != "" && !sameGateway(r.PrefSrc, route.From) {
+		return true
+	}
+	if route.Scope != "" && routeScope(r.Scope) != route.Scope {
+		return true
+	}
+	if route.Protocol != "" && routeProtocol(r.Protocol) != route.Protocol {
+		return true
+	}
+	if route.Mtu != 0 && r.Mtu() != route.Mtu {
+		return true
+	}
+	if route.AdvMSS != 0 && r.AdvMSS() != route.AdvMSS {
+		return true
+	}
+	return !sameEncap(r.Encap, route.Encap, tables)
+}
+
+// sameNexthops compares the paths of a multipath route regardless of
+// their order. An unset weight is 1 and an unset dev matches any device.
+func sameNexthops(current []iproute2.RouteNexthop, nexthops []config.Nexthop) bool {
+	if len(current) != len(nexthops) {
+		return false
+	}
+
+	weight := func(w int) int {
+		if w == 0 {
+			return 1
+		}
+		return w
+	}
+	used := make([]bool, len(current))
+	for _, nh := range nexthops {
+		i := slices.IndexFunc(current, func(c iproute2.RouteNexthop) bool {
+			return sameGateway(c.Gateway, nh.Via) &&
+				(nh.Dev == "" || c.Dev == nh.Dev) &&
+				weight(c.Weight) == weight(nh.Weight)
+		})
+		if i < 0 || used[i] {
+			return false
+		}
+		used[i] = true
+	}
+	return true
+}
+
+// routeTable returns the table the route is added to. Local routes go
+// to the local table unless a table is given.
+func routeTable(route config.Route) string {
+	if route.Table != "" {
+		return route.Table
+	}
+	if route.Type == config.RouteTypeLocal {
+		return "local"
+	}
+	return "main"
+}
+
+// sameTable compares table names or ids. "ip route" omits the main
+// table and prints the reserved tables by name, and inside a netns with
+// route tables also the tables named in the config.
+func sameTable(a, b string, tables map[string]int) bool {
+	return tableID(a, tables) == tableID(b, tables)
+}
+
+// tableID returns the id of a table name or id, or the name when it is
+// not known.
+func tableID(t string, tables map[string]int) string {
+	if t == "" {
+		return "254"
+	}
+	if id, ok := reservedTables[t]; ok {
+		return strconv.Itoa(id)
+	}
+	if id, ok := tables[t]; ok {
+		return strconv.Itoa(id)
+	}
+	if id, err := strconv.Atoi(t); err == nil {
+		return strconv.Itoa(id)
+	}
+	return t
+}
+
+// routeMetric returns the metric the kernel assigns to the route.
+// IPv6 routes default to 1024.
+func routeMetric(route config.Route) int {
+	if route.Metric != 0 {
+		return route.Metric
+	}
+	if routeFamily(route) == iproute2.FamilyInet6 {
+		return 1024
+	}
+	return 0
+}
+
+func routeType(t string) string {
+	if t == "" {
+		return config.RouteTypeUnicast
+	}
+	return t
+}
+
+func routeScope(s string) string {
+	if s == "" {
+		return "global"
+	}
+	return s
+}
+
+func routeProtocol(p string) string {
+	if p == "" {
+		return "boot"
+	}
+	return p
+}
+
+// routeFamily infers the address family of the route from its
+// destination, or from its gateways if the destination is "default".
+func routeFamily(route config.Route) string {
+	if route.To == "default6" {
+		return iproute2.FamilyInet6
+	}
+	if p, ok := parseDst(route.To, iproute2.FamilyInet); ok && route.To != "default" {
+		return addrFamily(p.Addr())
+	}
+
+	gateways := []string{route.Via}
+	for _, nh := range route.Nexthops {
+		gateways = append(gateways, nh.Via)
+	}
+	for _, g := range gateways {
+		if a, err := netip.ParseAddr(g); err == nil {
+			return addrFamily(a)
+		}
+	}
+	return iproute2.FamilyInet
+}
+
+func addrFamily(a netip.Addr) string {
+	if a.Is6() && !a.Is4In6() {
+		return iproute2.FamilyInet6
+	}
+	return iproute2.FamilyInet
+}
+
+// routeDst returns the destination passed to "ip route". An IPv6 default
+// route is written as "::/0", since "ip" takes "default" as IPv4 unless
+// told otherwise.
+func routeDst(route config.Route) string {
+	if route.To == "default6" || (route.To == "default" && routeFamily(route) == iproute2.FamilyInet6) {
+		return "::/0"
+	}
+	return route.To
+}
+
+// sameDst compares route destinations as printed by "ip route" with the
+// config. A host route is printed without its prefix length, and
+// "default" depends on the address family.
+func sameDst(a, b string, family string) bool {
+	pa, ok1 := parseDst(a, family)
+	pb, ok2 := parseDst(b, family)
+	if !ok1 || !ok2 {
+		return a == b
+	}
+	return pa == pb
+}
+
+func parseDst(s string, family string) (netip.Prefix, bool) {
+	switch {
+	case s == "default6" || (s == "default" && family == iproute2.FamilyInet6):
+		return netip.PrefixFrom(netip.IPv6Unspecified(), 0), true
+	case s == "default":
+		return netip.PrefixFrom(netip.IPv4Unspecified(), 0), true
+	}
+	if p, err := netip.ParsePrefix(s); err == nil {
+		return p.Masked(), true
+	}
+	if a, err := netip.ParseAddr(s); err == nil {
+		return netip.PrefixFrom(a, a.BitLen()), true
+	}
+	return netip.Prefix{}, false
+}
+
+func sameGateway(a, b string) bool {
+	if a == "" || b == "" {
+		return a == b
+	}
+	pa, err1 := netip.ParseAddr(a)
+	pb, err2 := netip.ParseAddr(b)
+	if err1 != nil || err2 != nil {
+		return a == b
+	}
+	return pa == pb
+}
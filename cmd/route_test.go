@@ -0,0 +1,482 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"netnsplan/config"
+	"netnsplan/iproute2"
+	"slices"
+	"testing"
+)
+
+// output of "ip -json -6 route show table all" without the local table
+const ipv6RoutesJSON = `[{"type":"unreachable","dst":"default","dev":"lo","table":"100","metric":1024,"flags":[],"pref":"medium"},{"dst":"2001:db8:8::/64","dev":"v0","protocol":"kernel","metric":256,"flags":[],"pref":"medium"},{"dst":"2001:db8:100::/48","gateway":"2001:db8:8::fe","dev":"v0","metric":50,"flags":[],"pref":"medium"},{"dst":"2001:db8:200::/48","dev":"v0","metric":1024,"flags":[],"pref":"medium"},{"dst":"2001:db8:300::1","dev":"v0","metric":1024,"flags":[],"metrics":[{"mtu":1400}],"pref":"medium"},{"type":"blackhole","dst":"2001:db8:dead::/48","dev":"lo","metric":1024,"flags":[],"pref":"medium"},{"dst":"default","gateway":"2001:db8:8::fe","dev":"v0","metric":1024,"flags":[],"pref":"medium"}]`
+
+func TestMatchRouteIPv6(t *testing.T) {
+	var routes iproute2.Routes
+	if err := json.Unmarshal([]byte(ipv6RoutesJSON), &routes); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		desc    string
+		name    string
+		route   config.Route
+		index   int
+		drifted bool
+	}{
+		{
+			desc:  "default with IPv6 gateway",
+			name:  "v0",
+			route: config.Route{To: "default", Via: "2001:db8:8::fe"},
+			index: 6,
+		},
+		{
+			desc:    "default6 with another gateway",
+			name:    "v0",
+			route:   config.Route{To: "default6", Via: "2001:db8:8::1"},
+			index:   6,
+			drifted: true,
+		},
+		{
+			desc:  "::/0",
+			name:  "v0",
+			route: config.Route{To: "::/0", Via: "2001:0db8:0008::00fe"},
+			index: 6,
+		},
+		{
+			desc:  "metric",
+			name:  "v0",
+			route: config.Route{To: "2001:db8:100::/48", Via: "2001:db8:8::fe", Metric: 50},
+			index: 2,
+		},
+		{
+			desc:  "default metric is 1024",
+			name:  "v0",
+			route: config.Route{To: "2001:db8:100::/48", Via: "2001:db8:8::fe"},
+			index: -1,
+		},
+		{
+			desc:  "device route",
+			name:  "v0",
+			route: config.Route{To: "2001:db8:200::/48"},
+			index: 3,
+		},
+		{
+			desc:  "host route with mtu",
+			name:  "v0",
+			route: config.Route{To: "2001:db8:300::1/128", Mtu: 1400},
+			index: 4,
+		},
+		{
+			desc:    "host route with another mtu",
+			name:    "v0",
+			route:   config.Route{To: "2001:db8:300::1", Mtu: 1500},
+			index:   4,
+			drifted: true,
+		},
+		{
+			desc:  "blackhole",
+			route: config.Route{To: "2001:db8:dead::/48", Type: config.RouteTypeBlackhole},
+			index: 5,
+		},
+		{
+			desc:  "default6 in another table",
+			route: config.Route{To: "default6", Table: "100", Type: config.RouteTypeUnreachable},
+			index: 0,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			if family := routeFamily(tc.route); family != iproute2.FamilyInet6 {
+				t.Fatalf("routeFamily() = %v, want %v", family, iproute2.FamilyInet6)
+			}
+
+			i := slices.IndexFunc(routes, func(r iproute2.Route) bool {
+				return matchRoute(r, tc.route, nil)
+			})
+			if i != tc.index {
+				t.Fatalf("matchRoute() matched %d, want %d", i, tc.index)
+			}
+			if i < 0 {
+				return
+			}
+			if drifted := routeDrifted(routes[i], tc.name, tc.route, nil); drifted != tc.drifted {
+				t.Errorf("routeDrifted() = %v, want %v", drifted, tc.drifted)
+			}
+		})
+	}
+}
+
+func TestRouteFamily(t *testing.T) {
+	testCases := []struct {
+		route  config.Route
+		family string
+		dst    string
+	}{
+		{route: config.Route{To: "default", Via: "10.0.0.1"}, family: iproute2.FamilyInet, dst: "default"},
+		{route: config.Route{To: "default", Via: "fe80::1"}, family: iproute2.FamilyInet6, dst: "::/0"},
+		{route: config.Route{To: "default6"}, family: iproute2.FamilyInet6, dst: "::/0"},
+		{route: config.Route{To: "default", Nexthops: []config.Nexthop{{Via: "2001:db8::1"}}}, family: iproute2.FamilyInet6, dst: "::/0"},
+		{route: config.Route{To: "10.0.0.0/8"}, family: iproute2.FamilyInet, dst: "10.0.0.0/8"},
+		{route: config.Route{To: "2001:db8::/32"}, family: iproute2.FamilyInet6, dst: "2001:db8::/32"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.route.To+" via "+tc.route.Via, func(t *testing.T) {
+			if got := routeFamily(tc.route); got != tc.family {
+				t.Errorf("routeFamily() = %v, want %v", got, tc.family)
+			}
+			if got := routeDst(tc.route); got != tc.dst {
+				t.Errorf("routeDst() = %v, want %v", got, tc.dst)
+			}
+		})
+	}
+}
+
+func TestNamedRouteTables(t *testing.T) {
+	tables := map[string]int{"vpn": 100}
+
+	testCases := []struct {
+		a, b     string
+		expected bool
+	}{
+		{"vpn", "100", true},
+		{"100", "vpn", true},
+		{"vpn", "vpn", true},
+		{"vpn", "200", false},
+		{"", "main", true},
+		{"main", "254", true},
+		{"local", "255", true},
+		{"100", "0100", true},
+		{"other", "100", false},
+	}
+	for _, tc := range testCases {
+		if got := sameTable(tc.a, tc.b, tables); got != tc.expected {
+			t.Errorf("sameTable(%q, %q) = %v, want %v", tc.a, tc.b, got, tc.expected)
+		}
+	}
+
+	// the live route of a config route with "table: 100", as listed
+	// inside a netns whose rt_tables names it
+	r := iproute2.Route{Dst: "10.40.0.0/16", Gateway: "192.168.1.254", Dev: "eth1", Table: "vpn", Metric: 100}
+	route := config.Route{To: "10.40.0.0/16", Via: "192.168.1.254", Metric: 100, Table: "100"}
+	if !matchRoute(r, route, tables) {
+		t.Errorf("matchRoute() = false, want true")
+	}
+	if routeDrifted(r, "eth1", route, tables) {
+		t.Errorf("routeDrifted() = true, want false")
+	}
+	if matchRoute(r, route, nil) {
+		t.Errorf("matchRoute() without tables = true, want false")
+	}
+
+	rule := iproute2.Rule{Priority: 100, Src: "192.168.1.0", SrcLen: 24, Table: "vpn"}
+	if !matchRule(rule, config.RoutingPolicy{From: "192.168.1.0/24", Table: "100", Priority: 100}, tables) {
+		t.Errorf("matchRule() = false, want true")
+	}
+
+	encap := &iproute2.RouteEncap{Type: "seg6local", Action: "End.DT6", Table: "vpn"}
+	if !sameEncap(encap, &config.Encap{Type: config.EncapSeg6Local, Action: "End.DT6", Table: "100"}, tables) {
+		t.Errorf("sameEncap() = false, want true")
+	}
+}
+
+func TestRouteDriftedSingleNexthop(t *testing.T) {
+	// "ip route add 10.50.0.0/16 nexthop via 192.168.0.254" is listed as
+	// a plain route without multipath
+	r := iproute2.Route{Dst: "10.50.0.0/16", Gateway: "192.168.0.254", Dev: "eth0"}
+
+	testCases := []struct {
+		desc     string
+		nexthops []config.Nexthop
+		expected bool
+	}{
+		{
+			desc:     "Same gateway",
+			nexthops: []config.Nexthop{{Via: "192.168.0.254", Weight: 2}},
+			expected: false,
+		},
+		{
+			desc:     "Same gateway and dev",
+			nexthops: []config.Nexthop{{Via: "192.168.0.254", Dev: "eth0"}},
+			expected: false,
+		},
+		{
+			desc:     "Another gateway",
+			nexthops: []config.Nexthop{{Via: "192.168.0.253"}},
+			expected: true,
+		},
+		{
+			desc:     "Another dev",
+			nexthops: []config.Nexthop{{Via: "192.168.0.254", Dev: "eth1"}},
+			expected: true,
+		},
+		{
+			desc:     "Two nexthops",
+			nexthops: []config.Nexthop{{Via: "192.168.0.254"}, {Via: "192.168.1.254"}},
+			expected: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			route := config.Route{To: "10.50.0.0/16", Nexthops: tc.nexthops}
+			if !matchRoute(r, route, nil) {
+				t.Fatalf("matchRoute() = false, want true")
+			}
+			if got := routeDrifted(r, "", route, nil); got != tc.expected {
+				t.Errorf("routeDrifted() = %v, want %v", got, tc.expected)
+			}
+		})
+	}
+}
+
+// output of "ip -json -4 route show table all" without the local table
+const ipv4RoutesJSON = `[{"dst":"default","gateway":"10.0.0.254","dev":"eth0","flags":[]},{"dst":"10.0.0.0/24","dev":"eth0","protocol":"kernel","scope":"link","prefsrc":"10.0.0.1","flags":[]},{"dst":"10.20.0.0/16","dev":"eth0","scope":"link","flags":[]},{"dst":"10.30.0.0/16","gateway":"10.0.0.253","dev":"eth0","metric":100,"flags":[]},{"type":"blackhole","dst":"198.51.100.0/24","flags":[]},{"type":"unreachable","dst":"198.51.101.0/24","table":"100","flags":[]}]`
+
+func TestMatchRoute(t *testing.T) {
+	var routes iproute2.Routes
+	if err := json.Unmarshal([]byte(ipv4RoutesJSON), &routes); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		desc    string
+		name    string
+		route   config.Route
+		index   int
+		drifted bool
+	}{
+		{
+			desc:  "default route",
+			name:  "eth0",
+			route: config.Route{To: "default", Via: "10.0.0.254"},
+			index: 0,
+		},
+		{
+			desc:  "device route without gateway",
+			name:  "eth0",
+			route: config.Route{To: "10.20.0.0/16"},
+			index: 2,
+		},
+		{
+			desc:    "device route on another device",
+			name:    "eth1",
+			route:   config.Route{To: "10.20.0.0/16"},
+			index:   2,
+			drifted: true,
+		},
+		{
+			desc:    "gateway is added",
+			name:    "eth0",
+			route:   config.Route{To: "10.20.0.0/16", Via: "10.0.0.253"},
+			index:   2,
+			drifted: true,
+		},
+		{
+			desc:  "metric is part of the identity",
+			name:  "eth0",
+			route: config.Route{To: "10.30.0.0/16", Via: "10.0.0.253"},
+			index: -1,
+		},
+		{
+			desc:  "same metric",
+			name:  "eth0",
+			route: config.Route{To: "10.30.0.0/16", Via: "10.0.0.253", Metric: 100},
+			index: 3,
+		},
+		{
+			desc:  "blackhole",
+			route: config.Route{To: "198.51.100.0/24", Type: config.RouteTypeBlackhole},
+			index: 4,
+		},
+		{
+			desc:    "blackhole becomes prohibit",
+			route:   config.Route{To: "198.51.100.0/24", Type: config.RouteTypeProhibit},
+			index:   4,
+			drifted: true,
+		},
+		{
+			desc:  "unreachable in another table",
+			route: config.Route{To: "198.51.101.0/24", Table: "100", Type: config.RouteTypeUnreachable},
+			index: 5,
+		},
+		{
+			desc:  "unreachable in the main table",
+			route: config.Route{To: "198.51.101.0/24", Type: config.RouteTypeUnreachable},
+			index: -1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			i := slices.IndexFunc(routes, func(r iproute2.Route) bool {
+				return matchRoute(r, tc.route, nil)
+			})
+			if i != tc.index {
+				t.Fatalf("matchRoute() matched %d, want %d", i, tc.index)
+			}
+			if i < 0 {
+				return
+			}
+			if drifted := routeDrifted(routes[i], tc.name, tc.route, nil); drifted != tc.drifted {
+				t.Errorf("routeDrifted() = %v, want %v", drifted, tc.drifted)
+			}
+		})
+	}
+}
+
+func TestRouteOptions(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		route    config.Route
+		expected []string
+	}{
+		{
+			desc:     "gateway only",
+			route:    config.Route{To: "10.20.0.0/16", Via: "10.0.0.254"},
+			expected: nil,
+		},
+		{
+			desc: "attributes",
+			route: config.Route{
+				To: "10.40.0.0/16", Via: "192.168.1.254", From: "192.168.1.1", Metric: 100, Table: "100",
+				Scope: "global", Mtu: 1400, AdvMSS: 1360, Protocol: "static", OnLink: true,
+			},
+			expected: []string{
+				"src", "192.168.1.1", "metric", "100", "table", "100", "scope", "global",
+				"mtu", "1400", "advmss", "1360", "proto", "static", "onlink",
+			},
+		},
+		{
+			desc:     "type comes last",
+			route:    config.Route{To: "198.51.100.0/24", Metric: 10, Type: config.RouteTypeBlackhole},
+			expected: []string{"metric", "10", "blackhole"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			if got := routeOptions(tc.route); !slices.Equal(got, tc.expected) {
+				t.Errorf("routeOptions() = %v, want %v", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestRouteHasDevice(t *testing.T) {
+	testCases := []struct {
+		routeType string
+		expected  bool
+	}{
+		{"", true},
+		{config.RouteTypeUnicast, true},
+		{config.RouteTypeLocal, true},
+		{config.RouteTypeBlackhole, false},
+		{config.RouteTypeUnreachable, false},
+		{config.RouteTypeProhibit, false},
+	}
+
+	for _, tc := range testCases {
+		if got := routeHasDevice(config.Route{Type: tc.routeType}); got != tc.expected {
+			t.Errorf("routeHasDevice(%q) = %v, want %v", tc.routeType, got, tc.expected)
+		}
+	}
+}
+
+func TestCheckRouteConflicts(t *testing.T) {
+	netns := func(ethernets map[string]config.Ethernet, routes ...config.Route) *config.Config {
+		return &config.Config{Netns: map[string]config.Netns{
+			"ns1": {Ethernets: ethernets, Routes: routes, RouteTables: map[string]int{"vpn": 100}},
+		}}
+	}
+
+	testCases := []struct {
+		desc         string
+		cfg          *config.Config
+		expectingErr bool
+	}{
+		{
+			desc: "Routes to other destinations",
+			cfg: netns(map[string]config.Ethernet{
+				"eth0": {Routes: []config.Route{{To: "10.1.0.0/16", Via: "10.0.0.1"}}},
+				"eth1": {Routes: []config.Route{{To: "10.2.0.0/16", Via: "10.0.1.1"}}},
+			}),
+		},
+		{
+			desc: "Same destination on two devices",
+			cfg: netns(map[string]config.Ethernet{
+				"eth0": {Routes: []config.Route{{To: "10.1.0.0/16", Via: "10.0.0.1"}}},
+				"eth1": {Routes: []config.Route{{To: "10.1.0.0/16", Via: "10.0.1.1"}}},
+			}),
+			expectingErr: true,
+		},
+		{
+			desc: "Same destination with other metrics",
+			cfg: netns(map[string]config.Ethernet{
+				"eth0": {Routes: []config.Route{{To: "default", Via: "10.0.0.1"}}},
+				"eth1": {Routes: []config.Route{{To: "default", Via: "10.0.1.1", Metric: 100}}},
+			}),
+		},
+		{
+			desc: "Same destination in other tables",
+			cfg: netns(map[string]config.Ethernet{
+				"eth0": {Routes: []config.Route{{To: "default", Via: "10.0.0.1"}}},
+				"eth1": {Routes: []config.Route{{To: "default", Via: "10.0.1.1", Table: "100"}}},
+			}),
+		},
+		{
+			desc: "Table given by name and id",
+			cfg: netns(map[string]config.Ethernet{
+				"eth0": {Routes: []config.Route{{To: "default", Via: "10.0.0.1", Table: "vpn"}}},
+				"eth1": {Routes: []config.Route{{To: "0.0.0.0/0", Via: "10.0.1.1", Table: "100"}}},
+			}),
+			expectingErr: true,
+		},
+		{
+			desc: "IPv4 and IPv6 default routes",
+			cfg: netns(map[string]config.Ethernet{
+				"eth0": {Routes: []config.Route{{To: "default", Via: "10.0.0.1"}}},
+				"eth1": {Routes: []config.Route{{To: "default", Via: "2001:db8::1"}}},
+			}),
+		},
+		{
+			desc: "Device route and netns route",
+			cfg: netns(map[string]config.Ethernet{
+				"eth0": {Routes: []config.Route{{To: "10.1.0.0/16", Via: "10.0.0.1"}}},
+			}, config.Route{To: "10.1.0.0/16", Nexthops: []config.Nexthop{{Via: "10.0.0.1"}, {Via: "10.0.1.1"}}}),
+			expectingErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := checkRouteConflicts(tc.cfg)
+			if (err != nil) != tc.expectingErr {
+				t.Errorf("checkRouteConflicts() error = %v, expectingErr %v", err, tc.expectingErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,331 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"netnsplan/config"
+	"netnsplan/iproute2"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var removeStaleRules bool
+
+const hostIproute2Dir = "/etc/iproute2"
+
+// reservedTables are the tables iproute2 always knows by name.
+var reservedTables = map[string]int{
+	"unspec":  0,
+	"default": 253,
+	"main":    254,
+	"local":   255,
+}
+
+// SetupRouteTables writes /etc/netns/<netns>/iproute2/rt_tables, so that
+// "ip netns exec" resolves the table names of the netns. The directory is
+// mounted over /etc/iproute2, so the other files of the host are copied
+// along with it.
+func SetupRouteTables(ns string, tables map[string]int) error {
+	if len(tables) == 0 {
+		return nil
+	}
+
+	dir := filepath.Join(netnsEtcDir, ns, "iproute2")
+	entries, err := os.ReadDir(hostIproute2Dir)
+	if os.IsNotExist(err) {
+		// Newer distributions ship the iproute2 config in
+		// /usr/share/iproute2, which iproute2 falls back to for the files
+		// missing in /etc/iproute2. "ip netns exec" only mounts over an
+		// existing directory, so an empty one is made the mount point.
+		// The directory is left on the host by "netnsplan destroy".
+		slog.Info("create iproute2 config directory", "path", hostIproute2Dir)
+		err = os.MkdirAll(hostIproute2Dir, 0755)
+	}
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if !e.Type().IsRegular() || e.Name() == "rt_tables" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(hostIproute2Dir, e.Name()))
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(dir, e.Name())
+		if etcFileUpToDate(path, string(data)) {
+			continue
+		}
+		slog.Debug("copy iproute2 config", "path", path)
+		err = writeEtcFile(path, string(data))
+		if err != nil {
+			return err
+		}
+	}
+
+	data, err := renderRouteTables(tables)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, "rt_tables")
+	if etcFileUpToDate(path, data) {
+		slog.Debug("rt_tables is up to date", "path", path)
+		return nil
+	}
+
+	slog.Info("write rt_tables", "path", path, "tables", tables)
+	return writeEtcFile(path, data)
+}
+
+// netnsRouteTables returns the route tables of the netns ip runs in, for
+// the routes set up along with a device.
+func netnsRouteTables(ip IpCommand) map[string]int {
+	if cfg == nil || !ip.InNetns() {
+		return nil
+	}
+	return cfg.Netns[ip.Netns()].RouteTables
+}
+
+func renderRouteTables(tables map[string]int) (string, error) {
+	type entry struct {
+		id   int
+		name string
+	}
+	var entries []entry
+	for name, id := range tables {
+		if _, ok := reservedTables[name]; ok {
+			return "", fmt.Errorf("route table name %q is reserved", name)
+		}
+		if id <= 0 || id == 253 || id == 254 || id == 255 {
+			return "", fmt.Errorf("route table id %d of %q is reserved or invalid", id, name)
+		}
+		entries = append(entries, entry{id, name})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].id != entries[j].id {
+			return entries[i].id < entries[j].id
+		}
+		return entries[i].name < entries[j].name
+	})
+
+	var b strings.Builder
+	b.WriteString(generatedHeader)
+	b.WriteString("255\tlocal\n254\tmain\n253\tdefault\n0\tunspec\n")
+	for _, e := range entries {
+		fmt.Fprintf(&b, "%d\t%s\n", e.id, e.name)
+	}
+	return b.String(), nil
+}
+
+// SetupRoutingPolicy adds the missing policy routing rules. Rules that
+// are not in the config are removed only with --remove-stale-rules.
+func SetupRoutingPolicy(ip IpCommand, policies []config.RoutingPolicy, tables map[string]int) error {
+	families := []string{iproute2.FamilyInet, iproute2.FamilyInet6}
+	current := map[string]iproute2.Rules{}
+	used := map[string][]bool{}
+	if len(policies) > 0 || removeStaleRules {
+		for _, family := range families {
+			rules, err := ip.ListRules(family)
+			if err != nil {
+				return err
+			}
+			current[family] = rules
+			used[family] = make([]bool, len(rules))
+		}
+	}
+
+	for _, p := range policies {
+		family := ruleFamily(p)
+		i := slices.IndexFunc(current[family], func(r iproute2.Rule) bool {
+			return matchRule(r, p, tables)
+		})
+		if i >= 0 {
+			slog.Debug("rule is already exists", "rule", p)
+			used[family][i] = true
+			continue
+		}
+
+		options := ruleOptions(p)
+		logWithNetns(ip, "add rule", "family", family, "rule", strings.Join(options, " "))
+		err := ip.AddRule(family, options...)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !removeStaleRules {
+		return nil
+	}
+
+	for _, family := range families {
+		for i, r := range current[family] {
+			if used[family][i] || isDefaultRule(r) {
+				continue
+			}
+
+			logWithNetns(ip, "delete stale rule", "family", family, "rule", strings.Join(r.Args(), " "))
+			err := ip.DelRule(family, r.Args()...)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func ruleOptions(p config.RoutingPolicy) []string {
+	var options []string
+	if p.Priority != 0 {
+		options = append(options, "priority", strconv.Itoa(p.Priority))
+	}
+	if p.From != "" {
+		options = append(options, "from", p.From)
+	}
+	if p.To != "" {
+		options = append(options, "to", p.To)
+	}
+	if p.Iif != "" {
+		options = append(options, "iif", p.Iif)
+	}
+	if p.Oif != "" {
+		options = append(options, "oif", p.Oif)
+	}
+	if p.FwMark != "" {
+		options = append(options, "fwmark", p.FwMark)
+	}
+	if ruleAction(p) == config.RuleActionLookup {
+		options = append(options, "table", ruleTable(p))
+	} else {
+		options = append(options, p.Action)
+	}
+	return options
+}
+
+// ruleFamily infers the address family of the rule from its selectors.
+// Rules without addresses are IPv4 rules, as with "ip rule".
+func ruleFamily(p config.RoutingPolicy) string {
+	for _, s := range []string{p.From, p.To} {
+		if prefix, ok := parseDst(s, iproute2.FamilyInet); ok && prefix.Addr().Is6() {
+			return iproute2.FamilyInet6
+		}
+	}
+	return iproute2.FamilyInet
+}
+
+func ruleAction(p config.RoutingPolicy) string {
+	if p.Action == "" {
+		return config.RuleActionLookup
+	}
+	return p.Action
+}
+
+func ruleTable(p config.RoutingPolicy) string {
+	if p.Table == "" {
+		return "main"
+	}
+	return p.Table
+}
+
+// matchRule reports whether r has the selectors and the action of the
+// config. The priority is compared only when it is set, since the
+// kernel picks one otherwise.
+func matchRule(r iproute2.Rule, p config.RoutingPolicy, tables map[string]int) bool {
+	if p.Priority != 0 && r.Priority != p.Priority {
+		return false
+	}
+	if !sameSelector(r.Src, r.SrcLen, p.From) || !sameSelector(r.Dst, r.DstLen, p.To) {
+		return false
+	}
+	if r.Iif != p.Iif || r.Oif != p.Oif {
+		return false
+	}
+	if !sameFwMark(r.FwMark, r.FwMask, p.FwMark) {
+		return false
+	}
+	if ruleAction(p) == config.RuleActionLookup {
+		return r.Action == "" && sameTable(r.Table, ruleTable(p), tables)
+	}
+	return r.Action == p.Action
+}
+
+func sameSelector(addr string, length int, s string) bool {
+	if addr == "" || addr == "all" {
+		return s == ""
+	}
+	if s == "" {
+		return false
+	}
+	if length != 0 {
+		addr += "/" + strconv.Itoa(length)
+	}
+	return sameDst(addr, s, iproute2.FamilyInet)
+}
+
+// sameFwMark compares the mark and mask of a rule with "mark[/mask]".
+// A mark without mask matches all bits.
+func sameFwMark(mark string, mask string, s string) bool {
+	if mark == "" || s == "" {
+		return mark == s
+	}
+
+	m, k, _ := strings.Cut(s, "/")
+	if mask == "" {
+		mask = "0xffffffff"
+	}
+	if k == "" {
+		k = "0xffffffff"
+	}
+	return sameUint(mark, m) && sameUint(mask, k)
+}
+
+func sameUint(a, b string) bool {
+	ua, err1 := strconv.ParseUint(a, 0, 32)
+	ub, err2 := strconv.ParseUint(b, 0, 32)
+	if err1 != nil || err2 != nil {
+		return a == b
+	}
+	return ua == ub
+}
+
+// isDefaultRule reports whether r is one of the rules the kernel
+// creates in every netns.
+func isDefaultRule(r iproute2.Rule) bool {
+	if (r.Src != "" && r.Src != "all") || r.Dst != "" || r.Iif != "" || r.Oif != "" || r.FwMark != "" || r.Action != "" {
+		return false
+	}
+	switch r.Priority {
+	case 0:
+		return r.Table == "local"
+	case 32766:
+		return r.Table == "main"
+	case 32767:
+		return r.Table == "default"
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package cmd
+
+import (
+	"netnsplan/config"
+	"netnsplan/iproute2"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeRules is an IpCommand with the policy routing rules of a netns.
+type fakeRules struct {
+	fakeIp
+	rules map[string]iproute2.Rules
+}
+
+func (f *fakeRules) ListRules(family string) (iproute2.Rules, error) {
+	return f.rules[family], nil
+}
+
+func (f *fakeRules) AddRule(family string, options ...string) error {
+	return f.record("add rule %s %s", family, strings.Join(options, " "))
+}
+
+func (f *fakeRules) DelRule(family string, options ...string) error {
+	return f.record("del rule %s %s", family, strings.Join(options, " "))
+}
+
+func TestSetupRoutingPolicy(t *testing.T) {
+	tables := map[string]int{"vpn": 100}
+	defaults := iproute2.Rules{
+		{Priority: 0, Src: "all", Table: "local"},
+		{Priority: 32766, Src: "all", Table: "main"},
+		{Priority: 32767, Src: "all", Table: "default"},
+	}
+
+	testCases := []struct {
+		desc        string
+		rules       iproute2.Rules
+		policies    []config.RoutingPolicy
+		tables      map[string]int
+		removeStale bool
+		expected    []string
+	}{
+		{
+			desc:     "Missing rule",
+			rules:    defaults,
+			policies: []config.RoutingPolicy{{From: "192.168.1.0/24", Table: "vpn", Priority: 100}},
+			tables:   tables,
+			expected: []string{"add rule inet priority 100 from 192.168.1.0/24 table vpn"},
+		},
+		{
+			desc:     "Rule listed with the table name",
+			rules:    append(iproute2.Rules{{Priority: 100, Src: "192.168.1.0", SrcLen: 24, Table: "vpn"}}, defaults...),
+			policies: []config.RoutingPolicy{{From: "192.168.1.0/24", Table: "100", Priority: 100}},
+			tables:   tables,
+		},
+		{
+			desc:     "Rule listed with the table name without tables",
+			rules:    append(iproute2.Rules{{Priority: 100, Src: "192.168.1.0", SrcLen: 24, Table: "vpn"}}, defaults...),
+			policies: []config.RoutingPolicy{{From: "192.168.1.0/24", Table: "100", Priority: 100}},
+			expected: []string{"add rule inet priority 100 from 192.168.1.0/24 table 100"},
+		},
+		{
+			desc:     "Stale rule is kept",
+			rules:    append(iproute2.Rules{{Priority: 100, Src: "192.168.1.0", SrcLen: 24, Table: "vpn"}}, defaults...),
+			tables:   tables,
+			expected: nil,
+		},
+		{
+			desc:        "Stale rule is removed",
+			rules:       append(iproute2.Rules{{Priority: 100, Src: "192.168.1.0", SrcLen: 24, Table: "vpn"}}, defaults...),
+			tables:      tables,
+			removeStale: true,
+			expected:    []string{"del rule inet priority 100 from 192.168.1.0/24 table vpn"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			saved := removeStaleRules
+			removeStaleRules = tc.removeStale
+			defer func() { removeStaleRules = saved }()
+
+			f := &fakeRules{rules: map[string]iproute2.Rules{iproute2.FamilyInet: tc.rules}}
+			err := SetupRoutingPolicy(f, tc.policies, tc.tables)
+			if err != nil {
+				t.Fatalf("SetupRoutingPolicy() error = %v", err)
+			}
+			if !reflect.DeepEqual(f.calls, tc.expected) {
+				t.Errorf("SetupRoutingPolicy() calls = %v, want %v", f.calls, tc.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/spf13/cobra"
+)
+
+// statusCmd represents the status command
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show where the running system differs from the configuration",
+	Long: `Show where the running system differs from the configuration.
+The exit status is non-zero if anything differs.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		names := make([]string, 0, len(cfg.Netns))
+		for name := range cfg.Netns {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		out := cmd.OutOrStdout()
+		drifted := false
+		for _, name := range names {
+			drift, err := NetnsDrift(name)
+			if err != nil {
+				return err
+			}
+			if len(drift) == 0 {
+				fmt.Fprintf(out, "%s: ok\n", name)
+				continue
+			}
+			drifted = true
+			for _, d := range drift {
+				fmt.Fprintf(out, "%s: %s\n", name, d)
+			}
+		}
+
+		if drifted {
+			return fmt.Errorf("running system differs from the configuration")
+		}
+		return nil
+	},
+}
+
+// NetnsDrift returns how the netns differs from the config.
+func NetnsDrift(name string) ([]string, error) {
+	if !ip.ExistsNetns(name) {
+		return []string{"netns does not exist"}, nil
+	}
+	values := cfg.Netns[name]
+	n := IntoNetns(name)
+
+	drift, err := SysctlDrift(n, values.Sysctls)
+	if err != nil {
+		return nil, err
+	}
+
+	if values.Firewall != nil {
+		reason, err := FirewallDrift(n, name, values.Firewall)
+		if err != nil {
+			return nil, err
+		}
+		if reason != "" {
+			drift = append(drift, "firewall: "+reason)
+		}
+	}
+	if values.Uplink != nil {
+		reason, err := UplinkDrift(name, values.Uplink)
+		if err != nil {
+			return nil, err
+		}
+		if reason != "" {
+			drift = append(drift, "uplink nat: "+reason)
+		}
+	}
+	reason, err := PortForwardDrift(name, values.PortForwards)
+	if err != nil {
+		return nil, err
+	}
+	if reason != "" {
+		drift = append(drift, "port forwards: "+reason)
+	}
+	for _, d := range ListDevices(cfg) {
+		if d.Netns != name || d.Values.Netem == nil {
+			continue
+		}
+		reason, err := NetemDrift(n, d.Name, *d.Values.Netem)
+		if err != nil {
+			return nil, err
+		}
+		if reason != "" {
+			drift = append(drift, "netem of "+d.Name+": "+reason)
+		}
+	}
+	return drift, nil
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+}
@@ -0,0 +1,193 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"netnsplan/config"
+	"sort"
+	"strings"
+)
+
+var addrGenModes = map[string]string{
+	"eui64":          "0",
+	"none":           "1",
+	"stable-privacy": "2",
+	"random":         "3",
+}
+
+type sysctlValue struct {
+	key    string
+	value  string
+	secret bool
+}
+
+// SetIPv6Settings writes the per-device IPv6 sysctls under
+// /proc/sys/net/ipv6/conf/<name>/.
+func SetIPv6Settings(ip IpCommand, name string, values config.Ethernet) error {
+	prefix := "net/ipv6/conf/" + name + "/"
+
+	var settings []sysctlValue
+	if values.DisableIPv6 != nil {
+		settings = append(settings, sysctlValue{key: "disable_ipv6", value: boolValue(*values.DisableIPv6, "1")})
+	}
+	if values.AcceptRA != nil {
+		settings = append(settings, sysctlValue{key: "accept_ra", value: boolValue(*values.AcceptRA, "1")})
+	}
+	if values.Autoconf != nil {
+		settings = append(settings, sysctlValue{key: "autoconf", value: boolValue(*values.Autoconf, "1")})
+	}
+	if values.IPv6Privacy != nil {
+		settings = append(settings, sysctlValue{key: "use_tempaddr", value: boolValue(*values.IPv6Privacy, "2")})
+	}
+	// stable-privacy needs the secret to be set first
+	if values.StableSecret != "" {
+		settings = append(settings, sysctlValue{key: "stable_secret", value: values.StableSecret, secret: true})
+	}
+	if values.AddrGenMode != "" {
+		mode, ok := addrGenModes[values.AddrGenMode]
+		if !ok {
+			return fmt.Errorf("unknown addr-gen-mode %q for %s", values.AddrGenMode, name)
+		}
+		settings = append(settings, sysctlValue{key: "addr_gen_mode", value: mode})
+	}
+
+	for _, s := range settings {
+		err := SetSysctl(ip, prefix+s.key, s.value, s.secret)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetupSysctls writes the sysctls of the netns and corrects the ones
+// that have drifted. Only the keys under net are per netns; the others
+// would change the host.
+func SetupSysctls(ip IpCommand, sysctls map[string]string) error {
+	keys, err := sysctlKeys(sysctls)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		err := SetSysctl(ip, key, sysctls[key], false)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SysctlDrift returns a message for each sysctl whose value differs
+// from the config.
+func SysctlDrift(ip IpCommand, sysctls map[string]string) ([]string, error) {
+	keys, err := sysctlKeys(sysctls)
+	if err != nil {
+		return nil, err
+	}
+
+	var drift []string
+	for _, key := range keys {
+		current, err := ip.Sysctl(flags.SysctlCmdPath).Read(key)
+		if err != nil {
+			return nil, err
+		}
+		if !sameSysctlValue(current, sysctls[key]) {
+			drift = append(drift, fmt.Sprintf("sysctl %s is %q, want %q", key, current, sysctls[key]))
+		}
+	}
+	return drift, nil
+}
+
+// sysctlKeys returns the keys in order, so that they are applied and
+// reported the same way every time.
+func sysctlKeys(sysctls map[string]string) ([]string, error) {
+	keys := make([]string, 0, len(sysctls))
+	for key := range sysctls {
+		if !netnsSysctl(key) {
+			return nil, fmt.Errorf("sysctl %q is not per netns, only net.* keys are allowed", key)
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// netnsSysctl reports whether key is under /proc/sys/net. Keys are
+// separated by "." or, like sysctl(8), by "/". Empty, "." and ".."
+// components are rejected, since they could lead out of it.
+func netnsSysctl(key string) bool {
+	sep := "."
+	if strings.Contains(key, "/") {
+		sep = "/"
+	}
+	components := strings.Split(strings.TrimPrefix(key, sep), sep)
+	if len(components) < 2 || components[0] != "net" {
+		return false
+	}
+	for _, c := range components {
+		if c == "" || c == "." || c == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+func boolValue(b bool, on string) string {
+	if b {
+		return on
+	}
+	return "0"
+}
+
+func SetSysctl(ip IpCommand, key string, value string, secret bool) error {
+	sysctl := ip.Sysctl(flags.SysctlCmdPath)
+	// an unset value (e.g. stable_secret) cannot be read
+	current, err := sysctl.Read(key)
+	if err == nil && sameSysctlValue(current, value) {
+		slog.Debug("sysctl is already set", "key", key)
+		return nil
+	}
+
+	if secret {
+		logWithNetns(ip, "set sysctl", "key", key)
+	} else {
+		logWithNetns(ip, "set sysctl", "key", key, "current", current, "value", value)
+	}
+	return sysctl.Write(key, value)
+}
+
+func sameSysctlValue(current, value string) bool {
+	if current == value {
+		return true
+	}
+	// sysctl prints the fields of a multi-value key separated by tabs
+	if strings.Join(strings.Fields(current), " ") == strings.Join(strings.Fields(value), " ") {
+		return true
+	}
+	a, err1 := netip.ParseAddr(current)
+	b, err2 := netip.ParseAddr(value)
+	return err1 == nil && err2 == nil && a == b
+}
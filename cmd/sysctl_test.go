@@ -0,0 +1,175 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"netnsplan/config"
+	"netnsplan/iproute2"
+	"reflect"
+	"testing"
+)
+
+// fakeSysctls is an IpCommand in a netns that keeps sysctls and records
+// the ones written.
+type fakeSysctls struct {
+	IpCommand
+	sysctls map[string]string
+	written []string
+}
+
+func (f *fakeSysctls) InNetns() bool { return true }
+func (f *fakeSysctls) Netns() string { return "ns1" }
+
+func (f *fakeSysctls) Sysctl(path string) iproute2.SysctlCommand { return f }
+
+func (f *fakeSysctls) Read(key string) (string, error) {
+	value, ok := f.sysctls[key]
+	if !ok {
+		return "", fmt.Errorf("sysctl: cannot stat /proc/sys/%s", key)
+	}
+	return value, nil
+}
+
+func (f *fakeSysctls) Write(key string, value string) error {
+	f.sysctls[key] = value
+	f.written = append(f.written, key+"="+value)
+	return nil
+}
+
+func TestNetnsSysctl(t *testing.T) {
+	testCases := []struct {
+		key      string
+		expected bool
+	}{
+		{key: "net.ipv4.ip_forward", expected: true},
+		{key: "net/ipv4/conf/eth0.100/rp_filter", expected: true},
+		{key: "/net/ipv6/conf/all/forwarding", expected: true},
+		{key: "vm.swappiness", expected: false},
+		{key: "kernel/hostname", expected: false},
+		{key: "netfilter.foo", expected: false},
+		{key: "net", expected: false},
+		{key: "net/../kernel/hostname", expected: false},
+		{key: "net/./ipv4/ip_forward", expected: false},
+		{key: "net//ipv4/ip_forward", expected: false},
+		{key: "net/ipv4/", expected: false},
+		{key: "net..ipv4.ip_forward", expected: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.key, func(t *testing.T) {
+			if got := netnsSysctl(tc.key); got != tc.expected {
+				t.Errorf("netnsSysctl() = %v, want %v", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestSameSysctlValue(t *testing.T) {
+	testCases := []struct {
+		current, value string
+		expected       bool
+	}{
+		{current: "1", value: "1", expected: true},
+		{current: "0", value: "1", expected: false},
+		{current: "32768\t60999", value: "32768 60999", expected: true},
+		{current: "2001:db8::1", value: "2001:0db8::1", expected: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.value, func(t *testing.T) {
+			if got := sameSysctlValue(tc.current, tc.value); got != tc.expected {
+				t.Errorf("sameSysctlValue() = %v, want %v", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestSetIPv6Settings(t *testing.T) {
+	on, off := true, false
+	prefix := "net/ipv6/conf/eth0.100/"
+	current := map[string]string{
+		prefix + "disable_ipv6":  "0",
+		prefix + "accept_ra":     "1",
+		prefix + "autoconf":      "1",
+		prefix + "use_tempaddr":  "0",
+		prefix + "addr_gen_mode": "0",
+	}
+
+	testCases := []struct {
+		desc         string
+		values       config.Ethernet
+		expected     []string
+		expectingErr bool
+	}{
+		{
+			desc:     "Nothing is set",
+			values:   config.Ethernet{},
+			expected: nil,
+		},
+		{
+			desc:     "Up to date",
+			values:   config.Ethernet{DisableIPv6: &off, AcceptRA: &on, Autoconf: &on, IPv6Privacy: &off, AddrGenMode: "eui64"},
+			expected: nil,
+		},
+		{
+			desc: "Drifted",
+			values: config.Ethernet{
+				AcceptRA:     &off,
+				Autoconf:     &on,
+				IPv6Privacy:  &on,
+				StableSecret: "2001:db8::1",
+				AddrGenMode:  "stable-privacy",
+			},
+			// the secret cannot be read while unset, and is written
+			// before addr_gen_mode
+			expected: []string{
+				prefix + "accept_ra=0",
+				prefix + "use_tempaddr=2",
+				prefix + "stable_secret=2001:db8::1",
+				prefix + "addr_gen_mode=2",
+			},
+		},
+		{
+			desc:         "Unknown addr-gen-mode",
+			values:       config.Ethernet{AddrGenMode: "stable"},
+			expectingErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			ip := &fakeSysctls{sysctls: map[string]string{}}
+			for k, v := range current {
+				ip.sysctls[k] = v
+			}
+
+			err := SetIPv6Settings(ip, "eth0.100", tc.values)
+			if (err != nil) != tc.expectingErr {
+				t.Fatalf("SetIPv6Settings() error = %v, expectingErr %v", err, tc.expectingErr)
+			}
+			if !reflect.DeepEqual(ip.written, tc.expected) {
+				t.Errorf("SetIPv6Settings() written = %v, want %v", ip.written, tc.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"netnsplan/config"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// hostHostsFile is the hosts file of the host, where the topology hosts
+// are kept between markers so that the rest of the file is left alone.
+var hostHostsFile = "/etc/hosts"
+
+const (
+	topologyHostsBegin = "# BEGIN netnsplan topology-hosts"
+	topologyHostsEnd   = "# END netnsplan topology-hosts"
+)
+
+// TopologyHosts returns a hosts entry for each static address of the
+// devices in every netns, named "<netns>-<device>". The first IPv4 and
+// IPv6 address of a netns, in device name order, are also named
+// "<netns>". Loopback and link-local addresses are left out.
+func TopologyHosts(cfg *config.Config) []config.HostsEntry {
+	type deviceAddr struct {
+		netns  string
+		device string
+		addr   netip.Addr
+	}
+
+	var addrs []deviceAddr
+	for _, d := range ListDevices(cfg) {
+		if d.Netns == "" {
+			continue
+		}
+		for _, a := range d.Values.Addresses {
+			if addr, ok := topologyAddr(a.Address); ok {
+				addrs = append(addrs, deviceAddr{d.Netns, d.Name, addr})
+			}
+		}
+	}
+	for ns, values := range cfg.Netns {
+		if values.Uplink == nil {
+			continue
+		}
+		if _, _, addr, err := uplinkAddresses(values.Uplink.Subnet); err == nil {
+			addrs = append(addrs, deviceAddr{ns, uplinkDevice, addr})
+		}
+	}
+	sort.SliceStable(addrs, func(i, j int) bool {
+		if addrs[i].netns != addrs[j].netns {
+			return addrs[i].netns < addrs[j].netns
+		}
+		return addrs[i].device < addrs[j].device
+	})
+
+	var entries []config.HostsEntry
+	named := map[string]bool{}
+	for _, a := range addrs {
+		names := []string{a.netns + "-" + a.device}
+		key := a.netns + "/" + addrFamily(a.addr)
+		if !named[key] {
+			named[key] = true
+			names = append(names, a.netns)
+		}
+		entries = append(entries, config.HostsEntry{IP: a.addr.String(), Names: names})
+	}
+	return entries
+}
+
+func topologyAddr(s string) (netip.Addr, bool) {
+	var addr netip.Addr
+	if p, err := netip.ParsePrefix(s); err == nil {
+		addr = p.Addr()
+	} else if a, err := netip.ParseAddr(s); err == nil {
+		addr = a
+	} else {
+		return addr, false
+	}
+	if addr.IsLoopback() || addr.IsLinkLocalUnicast() || addr.IsUnspecified() {
+		return addr, false
+	}
+	return addr, true
+}
+
+// SetupHostTopologyHosts writes the entries to the hosts file of the
+// host, replacing the ones written before. No entries remove them.
+func SetupHostTopologyHosts(entries []config.HostsEntry) error {
+	current, err := os.ReadFile(hostHostsFile)
+	if os.IsNotExist(err) {
+		if len(entries) == 0 {
+			return nil
+		}
+	} else if err != nil {
+		return err
+	}
+
+	var block strings.Builder
+	if len(entries) > 0 {
+		block.WriteString(topologyHostsBegin + "\n")
+		for _, e := range entries {
+			fmt.Fprintf(&block, "%s\t%s\n", e.IP, strings.Join(e.Names, " "))
+		}
+		block.WriteString(topologyHostsEnd + "\n")
+	}
+
+	data := replaceHostsBlock(string(current), block.String())
+	if data == string(current) {
+		slog.Debug("topology hosts are up to date", "path", hostHostsFile)
+		return nil
+	}
+
+	if len(entries) > 0 {
+		slog.Info("write topology hosts", "path", hostHostsFile)
+	} else {
+		slog.Info("remove topology hosts", "path", hostHostsFile)
+	}
+	return replaceFile(hostHostsFile, []byte(data))
+}
+
+// replaceFile writes data to a temporary file next to path and renames
+// it over path, so that readers never see a partially written file. The
+// mode of the current file is kept. A path that is a bind mount, such as
+// /etc/hosts in a container, cannot be replaced and is written in place.
+func replaceFile(path string, data []byte) error {
+	// replace the target of a symlink, not the symlink itself
+	if target, err := filepath.EvalSymlinks(path); err == nil {
+		path = target
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	err = os.Rename(tmp.Name(), path)
+	if errors.Is(err, syscall.EBUSY) {
+		slog.Debug("file is a mount point, write it in place", "path", path)
+		return os.WriteFile(path, data, mode)
+	}
+	return err
+}
+
+// replaceHostsBlock replaces the lines between the topology markers of
+// data with block, or appends block if there are none.
+func replaceHostsBlock(data string, block string) string {
+	var b strings.Builder
+	inBlock := false
+	for _, line := range strings.SplitAfter(data, "\n") {
+		switch strings.TrimSpace(line) {
+		case topologyHostsBegin:
+			inBlock = true
+			b.WriteString(block)
+			block = ""
+			continue
+		case topologyHostsEnd:
+			inBlock = false
+			continue
+		}
+		if !inBlock {
+			b.WriteString(line)
+		}
+	}
+
+	if block != "" {
+		if b.Len() > 0 && !strings.HasSuffix(b.String(), "\n") {
+			b.WriteString("\n")
+		}
+		b.WriteString(block)
+	}
+	return b.String()
+}
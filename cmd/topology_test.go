@@ -0,0 +1,176 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package cmd
+
+import (
+	"netnsplan/config"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestTopologyHosts(t *testing.T) {
+	cfg := &config.Config{
+		Netns: map[string]config.Netns{
+			"ns1": {
+				Loopback: config.Ethernet{
+					Addresses: []config.Address{{Address: "127.0.0.53/8"}},
+				},
+				Ethernets: map[string]config.Ethernet{
+					"eth0": {Addresses: []config.Address{
+						{Address: "192.168.0.1/24"},
+						{Address: "fe80::1/64"},
+						{Address: "2001:db8::1/64"},
+					}},
+				},
+				DummyDevices: map[string]config.Ethernet{
+					"dummy0": {Addresses: []config.Address{{Address: "10.0.0.1"}}},
+				},
+				VethDevices: map[string]config.VethDevice{
+					"veth0": {
+						Ethernet: config.Ethernet{Addresses: []config.Address{{Address: "192.168.1.1/24"}}},
+						Peer: config.Peer{
+							Name:     "veth0-peer",
+							Netns:    "ns2",
+							Ethernet: config.Ethernet{Addresses: []config.Address{{Address: "192.168.1.2/24"}}},
+						},
+					},
+				},
+			},
+			"ns2": {
+				Uplink: &config.Uplink{Subnet: "10.200.0.0/30"},
+			},
+		},
+	}
+
+	expected := []config.HostsEntry{
+		{IP: "10.0.0.1", Names: []string{"ns1-dummy0", "ns1"}},
+		{IP: "192.168.0.1", Names: []string{"ns1-eth0"}},
+		{IP: "2001:db8::1", Names: []string{"ns1-eth0", "ns1"}},
+		{IP: "192.168.1.1", Names: []string{"ns1-veth0"}},
+		{IP: "10.200.0.2", Names: []string{"ns2-uplink", "ns2"}},
+		{IP: "192.168.1.2", Names: []string{"ns2-veth0-peer"}},
+	}
+	if got := TopologyHosts(cfg); !reflect.DeepEqual(got, expected) {
+		t.Errorf("TopologyHosts() = %v, want %v", got, expected)
+	}
+}
+
+func TestSetupHostTopologyHosts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hosts")
+	orig := hostHostsFile
+	hostHostsFile = path
+	defer func() { hostHostsFile = orig }()
+
+	base := "127.0.0.1\tlocalhost\n"
+	if err := os.WriteFile(path, []byte(base), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	read := func() string {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	entries := []config.HostsEntry{{IP: "10.0.0.1", Names: []string{"ns1-eth0", "ns1"}}}
+	expected := base +
+		"# BEGIN netnsplan topology-hosts\n" +
+		"10.0.0.1\tns1-eth0 ns1\n" +
+		"# END netnsplan topology-hosts\n"
+	for i := 0; i < 2; i++ {
+		if err := SetupHostTopologyHosts(entries); err != nil {
+			t.Fatalf("SetupHostTopologyHosts() error = %v", err)
+		}
+		if got := read(); got != expected {
+			t.Errorf("hosts = %q, want %q", got, expected)
+		}
+	}
+
+	if err := SetupHostTopologyHosts(nil); err != nil {
+		t.Fatalf("SetupHostTopologyHosts() error = %v", err)
+	}
+	if got := read(); got != base {
+		t.Errorf("hosts = %q, want %q", got, base)
+	}
+}
+
+func TestReplaceFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "hosts")
+	if err := os.WriteFile(path, []byte("old\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "hosts.link")
+	if err := os.Symlink(path, link); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := replaceFile(link, []byte("new\n")); err != nil {
+		t.Fatalf("replaceFile() error = %v", err)
+	}
+
+	if data, err := os.ReadFile(path); err != nil || string(data) != "new\n" {
+		t.Errorf("data = %q, %v, want %q", data, err, "new\n")
+	}
+	if info, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	} else if info.Mode().Perm() != 0600 {
+		t.Errorf("mode = %v, want %v", info.Mode().Perm(), os.FileMode(0600))
+	}
+	if info, err := os.Lstat(link); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("symlink is replaced: %v", err)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 2 {
+		t.Errorf("temporary file is left: %v", files)
+	}
+
+	// a file that does not exist yet
+	path = filepath.Join(dir, "new")
+	if err := replaceFile(path, []byte("new\n")); err != nil {
+		t.Fatalf("replaceFile() error = %v", err)
+	}
+	if info, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	} else if info.Mode().Perm() != 0644 {
+		t.Errorf("mode = %v, want %v", info.Mode().Perm(), os.FileMode(0644))
+	}
+}
+
+func TestReplaceHostsBlock(t *testing.T) {
+	block := "# BEGIN netnsplan topology-hosts\n10.0.0.2\tns2\n# END netnsplan topology-hosts\n"
+	data := "127.0.0.1\tlocalhost\n" +
+		"# BEGIN netnsplan topology-hosts\n10.0.0.1\tns1\n# END netnsplan topology-hosts\n" +
+		"192.0.2.1\tother\n"
+	expected := "127.0.0.1\tlocalhost\n" + block + "192.0.2.1\tother\n"
+	if got := replaceHostsBlock(data, block); got != expected {
+		t.Errorf("replaceHostsBlock() = %q, want %q", got, expected)
+	}
+
+	// a file without a trailing newline
+	if got := replaceHostsBlock("127.0.0.1\tlocalhost", block); got != "127.0.0.1\tlocalhost\n"+block {
+		t.Errorf("replaceHostsBlock() = %q", got)
+	}
+}
@@ -0,0 +1,191 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"netnsplan/config"
+	"netnsplan/iproute2"
+	"strconv"
+	"strings"
+)
+
+// uplinkDevice is the name of the uplink veth in the netns.
+const uplinkDevice = "uplink"
+
+// SetupUplink connects the netns to the host with a veth pair, routes
+// its traffic through the host and masquerades it. The NAT rules are in
+// a table of their own in the host, which is removed when the uplink
+// is removed from the config.
+func SetupUplink(netns string, uplink *config.Uplink) error {
+	path := rulesetStatePath(netns, "uplink")
+	if uplink == nil {
+		return removeRuleset(ip, path, "uplink nat")
+	}
+
+	subnet, hostAddr, netnsAddr, err := uplinkAddresses(uplink.Subnet)
+	if err != nil {
+		return fmt.Errorf("uplink of %s: %w", netns, err)
+	}
+
+	bits := strconv.Itoa(subnet.Bits())
+	veth := config.VethDevice{
+		Ethernet: config.Ethernet{
+			Addresses: []config.Address{{Address: netnsAddr.String() + "/" + bits}},
+			Routes:    []config.Route{{To: "default", Via: hostAddr.String()}},
+		},
+		Peer: config.Peer{
+			Name: uplinkPeerName(netns),
+			Ethernet: config.Ethernet{
+				Addresses: []config.Address{{Address: hostAddr.String() + "/" + bits}},
+			},
+		},
+	}
+	err = addUplinkDevice(netns)
+	if err != nil {
+		return err
+	}
+
+	err = SetupVethDevices(netns, map[string]config.VethDevice{uplinkDevice: veth})
+	if err != nil {
+		return err
+	}
+
+	err = SetSysctl(ip, "net/ipv4/ip_forward", "1", false)
+	if err != nil {
+		return err
+	}
+
+	return setupRuleset(ip, path, "uplink nat", uplinkRuleset(netns, subnet, uplink.Interface))
+}
+
+// addUplinkDevice creates the uplink veth pair. The netns end is created
+// with a name unique to the netns, since the host may have a device named
+// uplinkDevice, and it is renamed once it is in the netns.
+func addUplinkDevice(netns string) error {
+	n := ip.IntoNetns(netns)
+	_, err := n.ShowLink(uplinkDevice)
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*iproute2.NotExistError); !ok {
+		return err
+	}
+
+	// the pair may be left in the host by an interrupted apply
+	tempName := uplinkTempName(netns)
+	_, err = ip.ShowLink(tempName)
+	if err != nil {
+		if _, ok := err.(*iproute2.NotExistError); !ok {
+			return err
+		}
+		slog.Info("add veth device", "name", tempName, "peer name", uplinkPeerName(netns))
+		err = ip.AddVethDevice(tempName, uplinkPeerName(netns))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = SetNetns(tempName, netns)
+	if err != nil {
+		return err
+	}
+
+	logWithNetns(n, "rename device", "name", tempName, "new name", uplinkDevice)
+	return n.SetLinkName(tempName, uplinkDevice)
+}
+
+// RemoveUplink removes the NAT rules of the uplink from the host. The
+// veth pair goes away with the netns.
+func RemoveUplink(netns string) error {
+	return removeRuleset(ip, rulesetStatePath(netns, "uplink"), "uplink nat")
+}
+
+// UplinkDrift returns why the NAT rules of the uplink differ from the
+// config, or an empty string if they do not.
+func UplinkDrift(netns string, uplink *config.Uplink) (string, error) {
+	subnet, _, _, err := uplinkAddresses(uplink.Subnet)
+	if err != nil {
+		return "", fmt.Errorf("uplink of %s: %w", netns, err)
+	}
+	return rulesetDrift(ip, rulesetStatePath(netns, "uplink"), uplinkRuleset(netns, subnet, uplink.Interface))
+}
+
+// uplinkAddresses returns the subnet and the first two addresses in it,
+// for the host and the netns.
+func uplinkAddresses(s string) (subnet netip.Prefix, host netip.Addr, netns netip.Addr, err error) {
+	subnet, err = netip.ParsePrefix(s)
+	if err != nil {
+		return subnet, host, netns, fmt.Errorf("invalid subnet %q", s)
+	}
+	if !subnet.Addr().Is4() || subnet.Bits() > 30 {
+		return subnet, host, netns, fmt.Errorf("subnet %q must be IPv4 and at most /30", s)
+	}
+
+	subnet = subnet.Masked()
+	host = subnet.Addr().Next()
+	netns = host.Next()
+	return subnet, host, netns, nil
+}
+
+// uplinkPeerName returns the name of the host end of the uplink veth.
+func uplinkPeerName(netns string) string {
+	return uplinkHostName(netns, "up")
+}
+
+// uplinkTempName returns the name of the netns end of the uplink veth
+// while it is in the host.
+func uplinkTempName(netns string) string {
+	return uplinkHostName(netns, "ul")
+}
+
+// uplinkHostName returns a device name in the host for the uplink of the
+// netns. Device names are limited to 15 characters, so a long netns name
+// is replaced with its hash.
+func uplinkHostName(netns string, suffix string) string {
+	name := netns + "-" + suffix
+	if len(name) <= 15 {
+		return name
+	}
+	sum := sha256.Sum256([]byte(netns))
+	return suffix + "-" + hex.EncodeToString(sum[:])[:12]
+}
+
+func uplinkRuleset(netns string, subnet netip.Prefix, iface string) string {
+	match := fmt.Sprintf("ip saddr %s ip daddr != %s", subnet, subnet)
+	if iface != "" {
+		match = fmt.Sprintf("ip saddr %s oifname %s", subnet, strconv.Quote(iface))
+	}
+
+	var ruleset strings.Builder
+	fmt.Fprintf(&ruleset, "table ip netnsplan-uplink-%s {\n", netns)
+	fmt.Fprintf(&ruleset, "\tchain postrouting {\n")
+	fmt.Fprintf(&ruleset, "\t\ttype nat hook postrouting priority 100; policy accept;\n")
+	fmt.Fprintf(&ruleset, "\t\t%s masquerade\n", match)
+	fmt.Fprintf(&ruleset, "\t}\n")
+	fmt.Fprintf(&ruleset, "}\n")
+	return ruleset.String()
+}
@@ -0,0 +1,79 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package cmd
+
+import (
+	"testing"
+)
+
+func TestUplinkAddresses(t *testing.T) {
+	testCases := []struct {
+		subnet       string
+		host, netns  string
+		expectingErr bool
+	}{
+		{subnet: "10.200.0.0/30", host: "10.200.0.1", netns: "10.200.0.2"},
+		{subnet: "10.200.1.5/24", host: "10.200.1.1", netns: "10.200.1.2"},
+		{subnet: "10.200.0.0/31", expectingErr: true},
+		{subnet: "2001:db8::/64", expectingErr: true},
+		{subnet: "10.200.0.1", expectingErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.subnet, func(t *testing.T) {
+			_, host, netns, err := uplinkAddresses(tc.subnet)
+			if (err != nil) != tc.expectingErr {
+				t.Fatalf("uplinkAddresses() error = %v, expectingErr %v", err, tc.expectingErr)
+			}
+			if tc.expectingErr {
+				return
+			}
+			if host.String() != tc.host || netns.String() != tc.netns {
+				t.Errorf("uplinkAddresses() = %v, %v, want %v, %v", host, netns, tc.host, tc.netns)
+			}
+		})
+	}
+}
+
+func TestUplinkPeerName(t *testing.T) {
+	if got := uplinkPeerName("sample1"); got != "sample1-up" {
+		t.Errorf("uplinkPeerName() = %v, want sample1-up", got)
+	}
+	if got := uplinkPeerName("a-very-long-netns"); len(got) > 15 {
+		t.Errorf("uplinkPeerName() = %v, longer than 15 characters", got)
+	}
+}
+
+func TestUplinkTempName(t *testing.T) {
+	for _, netns := range []string{"sample1", "a-very-long-netns"} {
+		got := uplinkTempName(netns)
+		if len(got) > 15 {
+			t.Errorf("uplinkTempName(%q) = %v, longer than 15 characters", netns, got)
+		}
+		if got == uplinkPeerName(netns) || got == uplinkDevice {
+			t.Errorf("uplinkTempName(%q) = %v, collides with the other names", netns, got)
+		}
+	}
+	if uplinkTempName("a-very-long-netns") == uplinkTempName("a-very-long-netns2") {
+		t.Errorf("uplinkTempName() is not unique per netns")
+	}
+}
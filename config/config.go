@@ -22,6 +22,7 @@ SOFTWARE.
 package config
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 
@@ -30,40 +31,407 @@ import (
 )
 
 type Config struct {
-	Netns map[string]Netns `yaml:"netns"`
+	Netns         map[string]Netns `yaml:"netns"`
+	TopologyHosts TopologyHosts    `yaml:"topology-hosts,omitempty"`
+}
+
+// TopologyHosts adds the addresses of every netns to the hosts file of
+// each netns, and of the host if Host is set. It is either a bool or a
+// mapping, which enables it:
+//
+//	topology-hosts: true
+//	topology-hosts:
+//	  host: true
+type TopologyHosts struct {
+	Enabled bool
+	Host    bool
+}
+
+func (t *TopologyHosts) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		t.Host = false
+		return node.Decode(&t.Enabled)
+	case yaml.MappingNode:
+		var options struct {
+			Host bool `yaml:"host,omitempty"`
+		}
+		if err := node.Decode(&options); err != nil {
+			return err
+		}
+		t.Enabled = true
+		t.Host = options.Host
+		return nil
+	}
+	return fmt.Errorf("line %d: topology-hosts must be a bool or a mapping", node.Line)
+}
+
+func (t TopologyHosts) MarshalYAML() (interface{}, error) {
+	if !t.Host {
+		return t.Enabled, nil
+	}
+	return map[string]bool{"host": true}, nil
+}
+
+func (t TopologyHosts) IsZero() bool {
+	return !t.Enabled
 }
 
 type Netns struct {
-	Loopback     Ethernet              `yaml:"loopback,omitempty"`
-	Ethernets    map[string]Ethernet   `yaml:"ethernets,omitempty"`
-	DummyDevices map[string]Ethernet   `yaml:"dummy-devices,omitempty"`
-	VethDevices  map[string]VethDevice `yaml:"veth-devices,omitempty"`
-	PostScript   string                `yaml:"post-script,omitempty"`
+	Loopback           Ethernet              `yaml:"loopback,omitempty"`
+	Ethernets          map[string]Ethernet   `yaml:"ethernets,omitempty"`
+	DummyDevices       map[string]Ethernet   `yaml:"dummy-devices,omitempty"`
+	VethDevices        map[string]VethDevice `yaml:"veth-devices,omitempty"`
+	Geneves            map[string]Geneve     `yaml:"geneves,omitempty"`
+	BareUDP            map[string]BareUDP    `yaml:"bareudp,omitempty"`
+	Nexthops           []NexthopObject       `yaml:"nexthops,omitempty"`
+	Routes             []Route               `yaml:"routes,omitempty"`
+	RoutingPolicy      []RoutingPolicy       `yaml:"routing-policy,omitempty"`
+	RouteTables        map[string]int        `yaml:"route-tables,omitempty"`
+	MplsPlatformLabels int                   `yaml:"mpls-platform-labels,omitempty"`
+	MplsRoutes         []MplsRoute           `yaml:"mpls-routes,omitempty"`
+	Sysctls            map[string]string     `yaml:"sysctls,omitempty"`
+	Firewall           *Firewall             `yaml:"firewall,omitempty"`
+	Uplink             *Uplink               `yaml:"uplink,omitempty"`
+	PortForwards       []PortForward         `yaml:"port-forwards,omitempty"`
+	DNS                *DNS                  `yaml:"dns,omitempty"`
+	Hosts              []HostsEntry          `yaml:"hosts,omitempty"`
+	PostScript         string                `yaml:"post-script,omitempty"`
+}
+
+// Firewall is an nftables ruleset, given inline or as a path to a file.
+// A relative path is resolved from the config directory.
+type Firewall struct {
+	Ruleset string `yaml:"ruleset,omitempty"`
+	File    string `yaml:"file,omitempty"`
+}
+
+// Uplink connects the netns to the host with a veth pair and NATs its
+// traffic out of the host, the way a container gets network access.
+// The host takes the first address of the subnet and the netns the
+// second.
+type Uplink struct {
+	Subnet    string `yaml:"subnet"`
+	Interface string `yaml:"interface,omitempty"`
+}
+
+// PortForward forwards a port of the host to an address in the netns.
+// It is done with DNAT unless Proxy is set or the target is a loopback
+// address, in which case "netnsplan proxy" relays the connections.
+// TargetPort defaults to HostPort.
+type PortForward struct {
+	HostPort    int    `yaml:"host-port"`
+	Proto       string `yaml:"proto,omitempty"`
+	Target      string `yaml:"target"`
+	TargetPort  int    `yaml:"target-port,omitempty"`
+	HostAddress string `yaml:"host-address,omitempty"`
+	Proxy       bool   `yaml:"proxy,omitempty"`
+}
+
+const (
+	ProtoTCP = "tcp"
+	ProtoUDP = "udp"
+)
+
+// DNS is written to /etc/netns/<netns>/resolv.conf, which
+// "ip netns exec" mounts over /etc/resolv.conf.
+type DNS struct {
+	Nameservers []string `yaml:"nameservers,omitempty"`
+	Search      []string `yaml:"search,omitempty"`
+	Options     []string `yaml:"options,omitempty"`
+}
+
+// HostsEntry is a line of /etc/netns/<netns>/hosts.
+type HostsEntry struct {
+	IP    string   `yaml:"ip"`
+	Names []string `yaml:"names"`
 }
 
 type Ethernet struct {
-	Addresses []string `yaml:"addresses"`
-	Routes    []Route  `yaml:"routes,omitempty"`
+	Match          *Match          `yaml:"match,omitempty"`
+	SetName        string          `yaml:"set-name,omitempty"`
+	Mtu            int             `yaml:"mtu,omitempty"`
+	MacAddress     string          `yaml:"macaddress,omitempty"`
+	TxQueueLen     int             `yaml:"txqueuelen,omitempty"`
+	Alias          string          `yaml:"alias,omitempty"`
+	Group          string          `yaml:"group,omitempty"`
+	AltNames       []string        `yaml:"altnames,omitempty"`
+	Offload        map[string]bool `yaml:"offload,omitempty"`
+	Ring           *Ring           `yaml:"ring,omitempty"`
+	Activation     string          `yaml:"activation,omitempty"`
+	Optional       bool            `yaml:"optional,omitempty"`
+	AcceptRA       *bool           `yaml:"accept-ra,omitempty"`
+	Autoconf       *bool           `yaml:"autoconf,omitempty"`
+	AddrGenMode    string          `yaml:"addr-gen-mode,omitempty"`
+	IPv6Privacy    *bool           `yaml:"ipv6-privacy,omitempty"`
+	DisableIPv6    *bool           `yaml:"disable-ipv6,omitempty"`
+	StableSecret   string          `yaml:"stable-secret,omitempty"`
+	MplsInput      *bool           `yaml:"mpls-input,omitempty"`
+	DHCP4          bool            `yaml:"dhcp4,omitempty"`
+	DHCP6          bool            `yaml:"dhcp6,omitempty"`
+	Addresses      []Address       `yaml:"addresses"`
+	Routes         []Route         `yaml:"routes,omitempty"`
+	Neighbors      []Neighbor      `yaml:"neighbors,omitempty"`
+	ProxyNeighbors []string        `yaml:"proxy-neighbors,omitempty"`
+	Netem          *Netem          `yaml:"netem,omitempty"`
+}
+
+const (
+	ActivationUp     = "up"
+	ActivationDown   = "down"
+	ActivationManual = "manual"
+)
+
+// Address is either a plain address string or a single-key mapping from
+// an address to its options (same as netplan):
+//
+//	addresses:
+//	  - 10.0.0.1/24
+//	  - 2001:db8::1/64:
+//	      nodad: true
+type Address struct {
+	Address string
+	AddressOptions
+}
+
+type AddressOptions struct {
+	Peer              string `yaml:"peer,omitempty"`
+	Label             string `yaml:"label,omitempty"`
+	Scope             string `yaml:"scope,omitempty"`
+	ValidLifetime     string `yaml:"valid-lft,omitempty"`
+	PreferredLifetime string `yaml:"preferred-lft,omitempty"`
+	NoDad             bool   `yaml:"nodad,omitempty"`
+	NoPrefixRoute     bool   `yaml:"noprefixroute,omitempty"`
+}
+
+func (a *Address) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		a.Address = node.Value
+		return nil
+	case yaml.MappingNode:
+		if len(node.Content) != 2 {
+			return fmt.Errorf("line %d: address mapping must have exactly one key", node.Line)
+		}
+		a.Address = node.Content[0].Value
+		return node.Content[1].Decode(&a.AddressOptions)
+	}
+	return fmt.Errorf("line %d: address must be a string or a mapping", node.Line)
+}
+
+func (a Address) MarshalYAML() (interface{}, error) {
+	if a.AddressOptions == (AddressOptions{}) {
+		return a.Address, nil
+	}
+	return map[string]AddressOptions{a.Address: a.AddressOptions}, nil
+}
+
+type Match struct {
+	Name       string `yaml:"name,omitempty"`
+	MacAddress string `yaml:"macaddress,omitempty"`
+	Driver     string `yaml:"driver,omitempty"`
+	Path       string `yaml:"path,omitempty"`
+}
+
+type Ring struct {
+	RX      int `yaml:"rx,omitempty"`
+	RXMini  int `yaml:"rx-mini,omitempty"`
+	RXJumbo int `yaml:"rx-jumbo,omitempty"`
+	TX      int `yaml:"tx,omitempty"`
+}
+
+// Netem impairs the traffic sent from the device with the netem qdisc.
+// Times are tc times (e.g. "100ms"), probabilities are percentages
+// (e.g. "1%") and the rate is a tc rate (e.g. "1mbit").
+type Netem struct {
+	Delay        string `yaml:"delay,omitempty"`
+	Jitter       string `yaml:"jitter,omitempty"`
+	Distribution string `yaml:"distribution,omitempty"`
+	Loss         string `yaml:"loss,omitempty"`
+	Duplicate    string `yaml:"duplicate,omitempty"`
+	Reorder      string `yaml:"reorder,omitempty"`
+	Corrupt      string `yaml:"corrupt,omitempty"`
+	Rate         string `yaml:"rate,omitempty"`
+	Limit        int    `yaml:"limit,omitempty"`
 }
 
 type VethDevice struct {
-	Addresses []string `yaml:"addresses"`
-	Routes    []Route  `yaml:"routes,omitempty"`
-	Peer      Peer     `yaml:"peer"`
+	Ethernet `yaml:",inline"`
+	Peer     Peer `yaml:"peer"`
 }
 
 type Peer struct {
-	Name      string   `yaml:"name"`
-	Netns     string   `yaml:"netns,omitempty"`
-	Addresses []string `yaml:"addresses"`
-	Routes    []Route  `yaml:"routes,omitempty"`
+	Name     string       `yaml:"name"`
+	Netns    string       `yaml:"netns,omitempty"`
+	Bridge   string       `yaml:"bridge,omitempty"`
+	Vlans    []BridgeVlan `yaml:"vlans,omitempty"`
+	Fdb      []FdbEntry   `yaml:"fdb,omitempty"`
+	Ethernet `yaml:",inline"`
+}
+
+// BridgeVlan is a VLAN of a bridge port. The bridge needs
+// vlan_filtering enabled for the VLANs to take effect.
+type BridgeVlan struct {
+	Vid      int  `yaml:"vid"`
+	PVID     bool `yaml:"pvid,omitempty"`
+	Untagged bool `yaml:"untagged,omitempty"`
+}
+
+// FdbEntry is a static forwarding database entry of a bridge port.
+type FdbEntry struct {
+	Mac  string `yaml:"mac"`
+	Vlan int    `yaml:"vlan,omitempty"`
+}
+
+type Geneve struct {
+	Ethernet `yaml:",inline"`
+	ID       int    `yaml:"id,omitempty"`
+	Remote   string `yaml:"remote,omitempty"`
+	DstPort  int    `yaml:"dstport,omitempty"`
+	TTL      string `yaml:"ttl,omitempty"`
+	TOS      string `yaml:"tos,omitempty"`
+	External bool   `yaml:"external,omitempty"`
 }
 
+type BareUDP struct {
+	Ethernet   `yaml:",inline"`
+	DstPort    int    `yaml:"dstport,omitempty"`
+	EtherType  string `yaml:"ethertype,omitempty"`
+	SrcPortMin int    `yaml:"srcportmin,omitempty"`
+	MultiProto bool   `yaml:"multiproto,omitempty"`
+}
+
+// Route is a static route. From sets the preferred source address
+// ("src" of ip route) as netplan does.
 type Route struct {
-	To  string `yaml:"to"`
-	Via string `yaml:"via"`
+	To       string    `yaml:"to"`
+	Via      string    `yaml:"via,omitempty"`
+	OnLink   bool      `yaml:"on-link,omitempty"`
+	From     string    `yaml:"from,omitempty"`
+	Metric   int       `yaml:"metric,omitempty"`
+	Table    string    `yaml:"table,omitempty"`
+	Scope    string    `yaml:"scope,omitempty"`
+	Mtu      int       `yaml:"mtu,omitempty"`
+	AdvMSS   int       `yaml:"advmss,omitempty"`
+	Protocol string    `yaml:"protocol,omitempty"`
+	Type     string    `yaml:"type,omitempty"`
+	Nexthops []Nexthop `yaml:"nexthops,omitempty"`
+	Nhid     int       `yaml:"nhid,omitempty"`
+	Encap    *Encap    `yaml:"encap,omitempty"`
+}
+
+// Encap is the lightweight tunnel encapsulation of a route: "mpls"
+// pushes labels, "seg6" adds an SRv6 header (mode encap or inline) and
+// "seg6local" applies an SRv6 action.
+type Encap struct {
+	Type     string   `yaml:"type"`
+	Labels   []int    `yaml:"labels,omitempty"`
+	Mode     string   `yaml:"mode,omitempty"`
+	Segments []string `yaml:"segments,omitempty"`
+	Action   string   `yaml:"action,omitempty"`
+	Nh4      string   `yaml:"nh4,omitempty"`
+	Nh6      string   `yaml:"nh6,omitempty"`
+	Table    string   `yaml:"table,omitempty"`
+	VrfTable string   `yaml:"vrftable,omitempty"`
+}
+
+const (
+	EncapMPLS      = "mpls"
+	EncapSeg6      = "seg6"
+	EncapSeg6Local = "seg6local"
+)
+
+func (e *Encap) UnmarshalYAML(node *yaml.Node) error {
+	// the alias has no UnmarshalYAML, so it decodes the fields as usual
+	type encap Encap
+	if err := node.Decode((*encap)(e)); err != nil {
+		return err
+	}
+	switch e.Type {
+	case EncapMPLS, EncapSeg6, EncapSeg6Local:
+		return nil
+	}
+	return fmt.Errorf("line %d: unknown encap type %q", node.Line, e.Type)
 }
 
+// MplsRoute is a label switching entry. The incoming label is swapped
+// for the labels of As, or popped if As is empty.
+type MplsRoute struct {
+	Label int    `yaml:"label"`
+	As    []int  `yaml:"as,omitempty"`
+	Via   string `yaml:"via,omitempty"`
+	Dev   string `yaml:"dev,omitempty"`
+}
+
+// NexthopObject is a nexthop object ("ip nexthop") that netns routes
+// refer to with nhid. It is either a single nexthop (via, dev or
+// blackhole) or a group of other nexthop objects.
+type NexthopObject struct {
+	ID        int                  `yaml:"id"`
+	Via       string               `yaml:"via,omitempty"`
+	Dev       string               `yaml:"dev,omitempty"`
+	Blackhole bool                 `yaml:"blackhole,omitempty"`
+	Group     []NexthopGroupMember `yaml:"group,omitempty"`
+	Resilient *Resilient           `yaml:"resilient,omitempty"`
+}
+
+type NexthopGroupMember struct {
+	ID     int `yaml:"id"`
+	Weight int `yaml:"weight,omitempty"`
+}
+
+// Resilient makes a nexthop group use resilient hashing.
+type Resilient struct {
+	Buckets         int `yaml:"buckets,omitempty"`
+	IdleTimer       int `yaml:"idle-timer,omitempty"`
+	UnbalancedTimer int `yaml:"unbalanced-timer,omitempty"`
+}
+
+// RoutingPolicy is a policy routing rule ("ip rule"). Action defaults
+// to "lookup" of Table; the other actions are "blackhole",
+// "unreachable" and "prohibit".
+type RoutingPolicy struct {
+	From     string `yaml:"from,omitempty"`
+	To       string `yaml:"to,omitempty"`
+	Iif      string `yaml:"iif,omitempty"`
+	Oif      string `yaml:"oif,omitempty"`
+	FwMark   string `yaml:"fwmark,omitempty"`
+	Table    string `yaml:"table,omitempty"`
+	Priority int    `yaml:"priority,omitempty"`
+	Action   string `yaml:"action,omitempty"`
+}
+
+const RuleActionLookup = "lookup"
+
+// Nexthop is a path of an ECMP (multipath) route. Multipath routes span
+// several devices, so they are only allowed in the netns routes.
+type Nexthop struct {
+	Via    string `yaml:"via,omitempty"`
+	Dev    string `yaml:"dev,omitempty"`
+	Weight int    `yaml:"weight,omitempty"`
+}
+
+const (
+	RouteTypeUnicast     = "unicast"
+	RouteTypeBlackhole   = "blackhole"
+	RouteTypeUnreachable = "unreachable"
+	RouteTypeProhibit    = "prohibit"
+	RouteTypeLocal       = "local"
+)
+
+// Neighbor is a static ARP/NDP entry. State defaults to "permanent".
+type Neighbor struct {
+	IP     string `yaml:"ip"`
+	LLAddr string `yaml:"lladdr,omitempty"`
+	State  string `yaml:"state,omitempty"`
+}
+
+const (
+	NeighborStatePermanent = "permanent"
+	NeighborStateNoArp     = "noarp"
+	NeighborStateReachable = "reachable"
+)
+
 func mergeMaps(dst, src map[string]interface{}) {
 	for key, valueSrc := range src {
 		if valueDst, ok := dst[key]; ok {
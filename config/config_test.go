@@ -26,17 +26,21 @@ import (
 	"path/filepath"
 	"reflect"
 	"testing"
+
+	yaml "gopkg.in/yaml.v3"
 )
 
 func TestLoadYamlFiles(t *testing.T) {
 	wd, _ := os.Getwd()
 	testdataDir := filepath.Join(wd, "..", "testdata", "config")
+	acceptRA := false
+	mplsInput := true
 
 	expected := &Config{
 		Netns: map[string]Netns{
 			"sample1": {
 				Loopback: Ethernet{
-					Addresses: []string{"127.0.0.53/8"},
+					Addresses: []Address{{Address: "127.0.0.53/8"}},
 					Routes: []Route{{
 						To:  "10.10.0.0/24",
 						Via: "127.0.0.53",
@@ -44,25 +48,41 @@ func TestLoadYamlFiles(t *testing.T) {
 				},
 				Ethernets: map[string]Ethernet{
 					"eth0": {
-						Addresses: []string{
-							"192.168.0.1/24",
-							"2001:db8:beaf:cafe::1/112",
+						Addresses: []Address{
+							{Address: "192.168.0.1/24"},
+							{Address: "2001:db8:beaf:cafe::1/112", AddressOptions: AddressOptions{NoDad: true}},
 						},
 						Routes: []Route{{
 							To:  "default",
 							Via: "192.168.0.254",
 						}},
+						Neighbors: []Neighbor{
+							{IP: "192.168.0.254", LLAddr: "02:00:00:00:00:fe"},
+							{IP: "2001:db8:beaf:cafe::2", LLAddr: "02:00:00:00:00:02", State: NeighborStateReachable},
+						},
+						ProxyNeighbors: []string{"192.168.0.100"},
 					},
 					"eth1": {
-						Addresses: []string{
-							"192.168.1.1/24",
-							"10.0.0.1/24",
+						Addresses: []Address{
+							{Address: "192.168.1.1/24"},
+							{Address: "10.0.0.1/24"},
+						},
+						Routes: []Route{
+							{To: "10.20.0.0/16"},
+							{To: "10.30.0.0/16", Via: "10.99.0.1", OnLink: true},
+							{To: "10.40.0.0/16", Via: "192.168.1.254", From: "192.168.1.1", Metric: 100, Table: "100", Scope: "global", Mtu: 1400, AdvMSS: 1360, Protocol: "static"},
 						},
 					},
 				},
 				DummyDevices: map[string]Ethernet{
 					"dummy0": {
-						Addresses: []string{"192.168.10.1/24"},
+						TxQueueLen:  500,
+						Alias:       "test dummy",
+						Group:       "10",
+						AltNames:    []string{"dummy-alt"},
+						AcceptRA:    &acceptRA,
+						AddrGenMode: "eui64",
+						Addresses:   []Address{{Address: "192.168.10.1/24"}},
 						Routes: []Route{{
 							To:  "192.168.11.0/24",
 							Via: "192.168.10.254",
@@ -71,32 +91,145 @@ func TestLoadYamlFiles(t *testing.T) {
 				},
 				VethDevices: map[string]VethDevice{
 					"veth0": {
-						Addresses: []string{"192.168.20.1/24"},
-						Routes: []Route{{
-							To:  "192.168.21.0/24",
-							Via: "192.168.20.254",
-						}},
-						Peer: Peer{
-							Name:      "veth0-peer",
-							Netns:     "sample2",
-							Addresses: []string{"192.168.20.2/24"},
+						Ethernet: Ethernet{
+							Mtu: 9000,
+							Offload: map[string]bool{
+								"tso":         false,
+								"rx-checksum": false,
+							},
+							Ring:      &Ring{RX: 512},
+							Addresses: []Address{{Address: "192.168.20.1/24"}},
 							Routes: []Route{{
 								To:  "192.168.21.0/24",
-								Via: "192.168.20.2",
+								Via: "192.168.20.254",
 							}},
 						},
+						Peer: Peer{
+							Name:   "veth0-peer",
+							Netns:  "sample2",
+							Bridge: "br0",
+							Vlans: []BridgeVlan{
+								{Vid: 10, PVID: true, Untagged: true},
+								{Vid: 20},
+							},
+							Fdb: []FdbEntry{{Mac: "02:00:00:00:20:03", Vlan: 10}},
+							Ethernet: Ethernet{
+								Mtu:        9000,
+								MacAddress: "02:00:00:00:20:02",
+								Addresses:  []Address{{Address: "192.168.20.2/24"}},
+								Routes: []Route{{
+									To:  "192.168.21.0/24",
+									Via: "192.168.20.2",
+								}},
+							},
+						},
+					},
+				},
+				Geneves: map[string]Geneve{
+					"gnv0": {
+						Ethernet: Ethernet{
+							Addresses: []Address{{Address: "10.100.0.1/24"}},
+						},
+						ID:      100,
+						Remote:  "192.168.0.2",
+						DstPort: 6081,
+						TTL:     "64",
+					},
+					"gnv1": {
+						External: true,
+					},
+				},
+				BareUDP: map[string]BareUDP{
+					"bareudp0": {
+						DstPort:   6635,
+						EtherType: "mpls_uc",
 					},
 				},
+				Nexthops: []NexthopObject{
+					{ID: 1, Via: "192.168.0.254", Dev: "eth0"},
+					{ID: 2, Blackhole: true},
+					{
+						ID:        10,
+						Group:     []NexthopGroupMember{{ID: 1}, {ID: 2, Weight: 3}},
+						Resilient: &Resilient{Buckets: 32, IdleTimer: 120},
+					},
+				},
+				Routes: []Route{
+					{To: "198.51.100.0/24", Type: RouteTypeBlackhole},
+					{To: "10.70.0.0/16", Nhid: 10},
+					{
+						To: "10.50.0.0/16",
+						Nexthops: []Nexthop{
+							{Via: "192.168.0.254", Weight: 1},
+							{Via: "192.168.1.254", Dev: "eth1", Weight: 2},
+						},
+					},
+				},
+				RoutingPolicy: []RoutingPolicy{
+					{From: "192.168.1.0/24", Table: "vpn", Priority: 100},
+					{To: "10.60.0.0/16", Iif: "eth0", FwMark: "0x1/0xff", Action: "blackhole"},
+				},
+				RouteTables: map[string]int{"vpn": 100},
+				DNS: &DNS{
+					Nameservers: []string{"192.168.0.53"},
+					Search:      []string{"example.com"},
+					Options:     []string{"ndots:2"},
+				},
+				Hosts: []HostsEntry{{IP: "192.168.0.254", Names: []string{"gw", "gw.example.com"}}},
+				Firewall: &Firewall{
+					Ruleset: "table inet filter {\n  chain input {\n    type filter hook input priority 0; policy drop;\n    ct state established,related accept\n  }\n}\n",
+				},
 				PostScript: "echo 'Hello, World!'\n",
 			},
 			"sample2": {
 				Ethernets: map[string]Ethernet{
 					"eth2": {
-						Addresses: []string{"172.16.0.1/24"},
+						MplsInput: &mplsInput,
+						Netem: &Netem{
+							Delay:        "100ms",
+							Jitter:       "10ms",
+							Distribution: "normal",
+							Loss:         "1%",
+							Rate:         "1mbit",
+							Limit:        100,
+						},
+						Addresses: []Address{{Address: "172.16.0.1/24"}, {Address: "2001:db8:16::1/64"}},
+						Routes: []Route{
+							{To: "10.80.0.0/16", Via: "172.16.0.254", Encap: &Encap{Type: EncapMPLS, Labels: []int{100, 200}}},
+							{To: "2001:db8:90::/48", Encap: &Encap{Type: EncapSeg6, Mode: "inline", Segments: []string{"fc00::1", "fc00::2"}}},
+							{To: "fc00::100/128", Encap: &Encap{Type: EncapSeg6Local, Action: "End.DT6", Table: "100"}},
+						},
 					},
+					"eth3": {
+						Match: &Match{
+							MacAddress: "52:54:00:12:34:56",
+							Driver:     "virtio*",
+						},
+						SetName:    "uplink0",
+						Activation: "down",
+						Optional:   true,
+						DHCP4:      true,
+						DHCP6:      true,
+					},
+				},
+				MplsPlatformLabels: 1000,
+				MplsRoutes: []MplsRoute{
+					{Label: 100, As: []int{300}, Via: "172.16.0.254", Dev: "eth2"},
+					{Label: 101, Via: "172.16.0.253"},
+				},
+				Sysctls: map[string]string{
+					"net.ipv4.ip_forward":          "1",
+					"net/ipv6/conf/all/forwarding": "1",
+				},
+				Uplink: &Uplink{Subnet: "10.200.0.0/30", Interface: "eth0"},
+				PortForwards: []PortForward{
+					{HostPort: 8080, Target: "10.200.0.2", TargetPort: 80},
+					{HostPort: 5353, Proto: ProtoUDP, HostAddress: "192.0.2.1", Target: "10.200.0.2"},
+					{HostPort: 9000, Target: "127.0.0.1"},
 				},
 			},
 		},
+		TopologyHosts: TopologyHosts{Enabled: true, Host: true},
 	}
 
 	result, err := LoadYamlFiles(testdataDir)
@@ -108,3 +241,130 @@ func TestLoadYamlFiles(t *testing.T) {
 		t.Errorf("Expected %v, got %v", expected, result)
 	}
 }
+
+func TestAddressYAML(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		input    string
+		expected Address
+	}{
+		{
+			desc:     "Plain address",
+			input:    `10.0.0.1/24`,
+			expected: Address{Address: "10.0.0.1/24"},
+		},
+		{
+			desc: "Address with options",
+			input: `2001:db8::1/64:
+  nodad: true
+  noprefixroute: true
+  valid-lft: forever
+  preferred-lft: "300"
+`,
+			expected: Address{
+				Address: "2001:db8::1/64",
+				AddressOptions: AddressOptions{
+					ValidLifetime:     "forever",
+					PreferredLifetime: "300",
+					NoDad:             true,
+					NoPrefixRoute:     true,
+				},
+			},
+		},
+		{
+			desc: "Point-to-point address",
+			input: `10.0.0.1:
+  peer: 10.0.0.2/32
+  label: eth0:1
+  scope: link
+`,
+			expected: Address{
+				Address: "10.0.0.1",
+				AddressOptions: AddressOptions{
+					Peer:  "10.0.0.2/32",
+					Label: "eth0:1",
+					Scope: "link",
+				},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			var got Address
+			if err := yaml.Unmarshal([]byte(tc.input), &got); err != nil {
+				t.Fatalf("yaml.Unmarshal() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("yaml.Unmarshal() = %v, want %v", got, tc.expected)
+			}
+
+			out, err := yaml.Marshal(got)
+			if err != nil {
+				t.Fatalf("yaml.Marshal() error = %v", err)
+			}
+			var roundTrip Address
+			if err := yaml.Unmarshal(out, &roundTrip); err != nil {
+				t.Fatalf("yaml.Unmarshal() error = %v", err)
+			}
+			if !reflect.DeepEqual(roundTrip, tc.expected) {
+				t.Errorf("round trip = %v, want %v", roundTrip, tc.expected)
+			}
+		})
+	}
+
+	var a Address
+	if err := yaml.Unmarshal([]byte("[10.0.0.1/24]"), &a); err == nil {
+		t.Errorf("yaml.Unmarshal() expected error for sequence")
+	}
+}
+
+func TestEncapYAML(t *testing.T) {
+	testCases := []struct {
+		desc         string
+		input        string
+		expected     Encap
+		expectingErr bool
+	}{
+		{
+			desc: "MPLS",
+			input: `type: mpls
+labels: [100, 200]
+`,
+			expected: Encap{Type: EncapMPLS, Labels: []int{100, 200}},
+		},
+		{
+			desc: "seg6local",
+			input: `type: seg6local
+action: End.DT6
+table: "100"
+`,
+			expected: Encap{Type: EncapSeg6Local, Action: "End.DT6", Table: "100"},
+		},
+		{
+			desc: "Unknown type",
+			input: `type: mpsl
+labels: [100]
+`,
+			expectingErr: true,
+		},
+		{
+			desc:         "No type",
+			input:        `labels: [100]`,
+			expectingErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			var got Encap
+			err := yaml.Unmarshal([]byte(tc.input), &got)
+			if (err != nil) != tc.expectingErr {
+				t.Fatalf("yaml.Unmarshal() error = %v, expectingErr %v", err, tc.expectingErr)
+			}
+			if !tc.expectingErr && !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("yaml.Unmarshal() = %v, want %v", got, tc.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+// Package dhcp implements a minimal DHCPv4 (RFC 2131) and DHCPv6
+// (RFC 8415) client for interfaces in a network namespace.
+package dhcp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// parseDomainNames decodes a list of names in DNS wire format (RFC 1035).
+// Compression pointers are only allowed for DHCPv4 (RFC 3397).
+func parseDomainNames(data []byte, compression bool) ([]string, error) {
+	var names []string
+	for off := 0; off < len(data); {
+		name, next, err := readDomainName(data, off, compression)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+		off = next
+	}
+	return names, nil
+}
+
+func readDomainName(data []byte, off int, compression bool) (string, int, error) {
+	var labels []string
+	next := -1
+	for hops := 0; ; hops++ {
+		if off >= len(data) || hops > len(data) {
+			return "", 0, errors.New("dhcp: malformed domain name")
+		}
+
+		n := int(data[off])
+		switch {
+		case n == 0:
+			if next < 0 {
+				next = off + 1
+			}
+			return strings.Join(labels, "."), next, nil
+		case n&0xc0 == 0xc0:
+			if !compression || off+1 >= len(data) {
+				return "", 0, errors.New("dhcp: unexpected compression pointer")
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			off = (n&0x3f)<<8 | int(data[off+1])
+		default:
+			if off+1+n > len(data) {
+				return "", 0, fmt.Errorf("dhcp: truncated label")
+			}
+			labels = append(labels, string(data[off+1:off+1+n]))
+			off += 1 + n
+		}
+	}
+}
+
+func controlBindToDevice(ifname string, broadcast bool) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		var serr error
+		err := c.Control(func(fd uintptr) {
+			serr = unix.SetsockoptString(int(fd), unix.SOL_SOCKET, unix.SO_BINDTODEVICE, ifname)
+			if serr == nil {
+				serr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEADDR, 1)
+			}
+			if serr == nil && broadcast {
+				serr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_BROADCAST, 1)
+			}
+		})
+		if err != nil {
+			return err
+		}
+		return serr
+	}
+}
@@ -0,0 +1,409 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package dhcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/netip"
+	"time"
+)
+
+const (
+	opBootRequest = 1
+	opBootReply   = 2
+
+	magicCookie = 0x63825363
+
+	flagBroadcast = 0x8000
+)
+
+type MessageType4 byte
+
+const (
+	Discover MessageType4 = 1
+	Offer    MessageType4 = 2
+	Request  MessageType4 = 3
+	Decline  MessageType4 = 4
+	Ack      MessageType4 = 5
+	Nak      MessageType4 = 6
+	Release  MessageType4 = 7
+)
+
+const (
+	optSubnetMask      = 1
+	optRouter          = 3
+	optDNS             = 6
+	optDomainName      = 15
+	optRequestedIP     = 50
+	optLeaseTime       = 51
+	optMessageType     = 53
+	optServerID        = 54
+	optParamRequest    = 55
+	optRenewalTime     = 58
+	optRebindingTime   = 59
+	optClientID        = 61
+	optDomainSearch    = 119
+	optClasslessRoutes = 121
+	optEnd             = 255
+	optPad             = 0
+)
+
+// Packet4 is a DHCPv4 message (RFC 2131).
+type Packet4 struct {
+	Op      byte
+	XID     uint32
+	Flags   uint16
+	CIAddr  netip.Addr
+	YIAddr  netip.Addr
+	CHAddr  net.HardwareAddr
+	Options map[byte][]byte
+}
+
+func (p *Packet4) MessageType() MessageType4 {
+	if v := p.Options[optMessageType]; len(v) == 1 {
+		return MessageType4(v[0])
+	}
+	return 0
+}
+
+func (p *Packet4) Marshal() []byte {
+	buf := make([]byte, 240)
+	buf[0] = p.Op
+	buf[1] = 1 // ethernet
+	buf[2] = 6
+	binary.BigEndian.PutUint32(buf[4:8], p.XID)
+	binary.BigEndian.PutUint16(buf[10:12], p.Flags)
+	if p.CIAddr.Is4() {
+		a := p.CIAddr.As4()
+		copy(buf[12:16], a[:])
+	}
+	if p.YIAddr.Is4() {
+		a := p.YIAddr.As4()
+		copy(buf[16:20], a[:])
+	}
+	copy(buf[28:44], p.CHAddr)
+	binary.BigEndian.PutUint32(buf[236:240], magicCookie)
+
+	// message type goes first for the benefit of picky servers
+	if v, ok := p.Options[optMessageType]; ok {
+		buf = append(buf, optMessageType, byte(len(v)))
+		buf = append(buf, v...)
+	}
+	for code := 1; code < optEnd; code++ {
+		v, ok := p.Options[byte(code)]
+		if !ok || code == optMessageType {
+			continue
+		}
+		buf = append(buf, byte(code), byte(len(v)))
+		buf = append(buf, v...)
+	}
+	return append(buf, optEnd)
+}
+
+func ParsePacket4(data []byte) (*Packet4, error) {
+	if len(data) < 240 {
+		return nil, errors.New("dhcp4: packet too short")
+	}
+	if binary.BigEndian.Uint32(data[236:240]) != magicCookie {
+		return nil, errors.New("dhcp4: bad magic cookie")
+	}
+
+	hlen := int(data[2])
+	if hlen > 16 {
+		hlen = 16
+	}
+	p := &Packet4{
+		Op:      data[0],
+		XID:     binary.BigEndian.Uint32(data[4:8]),
+		Flags:   binary.BigEndian.Uint16(data[10:12]),
+		CIAddr:  netip.AddrFrom4([4]byte(data[12:16])),
+		YIAddr:  netip.AddrFrom4([4]byte(data[16:20])),
+		CHAddr:  net.HardwareAddr(bytes.Clone(data[28 : 28+hlen])),
+		Options: map[byte][]byte{},
+	}
+
+	opts := data[240:]
+	for len(opts) > 0 {
+		code := opts[0]
+		if code == optEnd {
+			break
+		}
+		if code == optPad {
+			opts = opts[1:]
+			continue
+		}
+		if len(opts) < 2 || len(opts) < 2+int(opts[1]) {
+			return nil, errors.New("dhcp4: truncated option")
+		}
+		n := int(opts[1])
+		// long options may be split into several instances (RFC 3396)
+		p.Options[code] = append(p.Options[code], opts[2:2+n]...)
+		opts = opts[2+n:]
+	}
+	return p, nil
+}
+
+// Route4 is a static route delivered by DHCP.
+type Route4 struct {
+	Dst     netip.Prefix
+	Gateway netip.Addr
+}
+
+// Lease4 is the result of a DHCPv4 exchange.
+type Lease4 struct {
+	Address      netip.Prefix
+	ServerID     netip.Addr
+	Routers      []netip.Addr
+	Routes       []Route4
+	DNS          []netip.Addr
+	DomainName   string
+	DomainSearch []string
+	LeaseTime    time.Duration
+	RenewalTime  time.Duration
+	RebindTime   time.Duration
+	Acquired     time.Time
+}
+
+func leaseFromPacket4(p *Packet4) (*Lease4, error) {
+	mask := p.Options[optSubnetMask]
+	bits := 32
+	if len(mask) == 4 {
+		bits, _ = net.IPMask(mask).Size()
+	}
+
+	lease := &Lease4{
+		Address:  netip.PrefixFrom(p.YIAddr, bits),
+		Acquired: time.Now(),
+	}
+
+	if v := p.Options[optServerID]; len(v) == 4 {
+		lease.ServerID = netip.AddrFrom4([4]byte(v))
+	}
+	lease.Routers = parseAddrs4(p.Options[optRouter])
+	lease.DNS = parseAddrs4(p.Options[optDNS])
+	lease.DomainName = string(p.Options[optDomainName])
+
+	if v, ok := p.Options[optDomainSearch]; ok {
+		names, err := parseDomainNames(v, true)
+		if err != nil {
+			return nil, err
+		}
+		lease.DomainSearch = names
+	}
+
+	if v, ok := p.Options[optClasslessRoutes]; ok {
+		routes, err := parseClasslessRoutes(v)
+		if err != nil {
+			return nil, err
+		}
+		lease.Routes = routes
+	}
+
+	lease.LeaseTime = parseSeconds(p.Options[optLeaseTime], 0)
+	if lease.LeaseTime == 0 {
+		return nil, errors.New("dhcp4: lease time is missing")
+	}
+	lease.RenewalTime = parseSeconds(p.Options[optRenewalTime], lease.LeaseTime/2)
+	lease.RebindTime = parseSeconds(p.Options[optRebindingTime], lease.LeaseTime*7/8)
+
+	return lease, nil
+}
+
+func parseAddrs4(v []byte) []netip.Addr {
+	var addrs []netip.Addr
+	for len(v) >= 4 {
+		addrs = append(addrs, netip.AddrFrom4([4]byte(v[:4])))
+		v = v[4:]
+	}
+	return addrs
+}
+
+func parseSeconds(v []byte, def time.Duration) time.Duration {
+	if len(v) != 4 {
+		return def
+	}
+	return time.Duration(binary.BigEndian.Uint32(v)) * time.Second
+}
+
+// parseClasslessRoutes parses option 121 (RFC 3442).
+func parseClasslessRoutes(v []byte) ([]Route4, error) {
+	var routes []Route4
+	for len(v) > 0 {
+		bits := int(v[0])
+		if bits > 32 {
+			return nil, fmt.Errorf("dhcp4: invalid classless route prefix length %d", bits)
+		}
+		n := (bits + 7) / 8
+		if len(v) < 1+n+4 {
+			return nil, errors.New("dhcp4: truncated classless route")
+		}
+
+		var dst [4]byte
+		copy(dst[:], v[1:1+n])
+		routes = append(routes, Route4{
+			Dst:     netip.PrefixFrom(netip.AddrFrom4(dst), bits),
+			Gateway: netip.AddrFrom4([4]byte(v[1+n : 5+n])),
+		})
+		v = v[5+n:]
+	}
+	return routes, nil
+}
+
+// Client4 is a DHCPv4 client bound to a single interface.
+type Client4 struct {
+	ifname string
+	hwaddr net.HardwareAddr
+	conn   net.PacketConn
+
+	Timeout time.Duration
+	Retries int
+}
+
+// NewClient4 opens the client socket. It must be called in the netns
+// of the interface (see netns.Do).
+func NewClient4(ifname string) (*Client4, error) {
+	iface, err := net.InterfaceByName(ifname)
+	if err != nil {
+		return nil, err
+	}
+
+	lc := net.ListenConfig{Control: controlBindToDevice(ifname, true)}
+	conn, err := lc.ListenPacket(context.Background(), "udp4", ":68")
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client4{
+		ifname:  ifname,
+		hwaddr:  iface.HardwareAddr,
+		conn:    conn,
+		Timeout: 4 * time.Second,
+		Retries: 4,
+	}, nil
+}
+
+func (c *Client4) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Client4) newPacket(t MessageType4, xid uint32) *Packet4 {
+	clientID := append([]byte{1}, c.hwaddr...)
+	return &Packet4{
+		Op:     opBootRequest,
+		XID:    xid,
+		Flags:  flagBroadcast,
+		CHAddr: c.hwaddr,
+		Options: map[byte][]byte{
+			optMessageType: {byte(t)},
+			optClientID:    clientID,
+			optParamRequest: {
+				optSubnetMask, optRouter, optDNS, optDomainName, optLeaseTime, optServerID,
+				optRenewalTime, optRebindingTime, optDomainSearch, optClasslessRoutes,
+			},
+		},
+	}
+}
+
+// Acquire runs the full DISCOVER/OFFER/REQUEST/ACK exchange.
+func (c *Client4) Acquire() (*Lease4, error) {
+	xid := rand.Uint32()
+
+	offer, err := c.exchange(c.newPacket(Discover, xid), Offer)
+	if err != nil {
+		return nil, fmt.Errorf("dhcp4 %s: %w", c.ifname, err)
+	}
+
+	req := c.newPacket(Request, xid)
+	a := offer.YIAddr.As4()
+	req.Options[optRequestedIP] = a[:]
+	req.Options[optServerID] = offer.Options[optServerID]
+
+	return c.request(req)
+}
+
+// Renew extends lease. The address must still be configured on the
+// interface.
+func (c *Client4) Renew(lease *Lease4) (*Lease4, error) {
+	req := c.newPacket(Request, rand.Uint32())
+	req.CIAddr = lease.Address.Addr()
+	return c.request(req)
+}
+
+func (c *Client4) request(req *Packet4) (*Lease4, error) {
+	ack, err := c.exchange(req, Ack)
+	if err != nil {
+		return nil, fmt.Errorf("dhcp4 %s: %w", c.ifname, err)
+	}
+	return leaseFromPacket4(ack)
+}
+
+func (c *Client4) exchange(p *Packet4, want MessageType4) (*Packet4, error) {
+	dst := &net.UDPAddr{IP: net.IPv4bcast, Port: 67}
+	data := p.Marshal()
+	buf := make([]byte, 1500)
+
+	for attempt := 0; attempt < c.Retries; attempt++ {
+		if _, err := c.conn.WriteTo(data, dst); err != nil {
+			return nil, err
+		}
+
+		deadline := time.Now().Add(c.Timeout)
+		c.conn.SetReadDeadline(deadline)
+		for {
+			n, _, err := c.conn.ReadFrom(buf)
+			if err != nil {
+				if isTimeout(err) {
+					break
+				}
+				return nil, err
+			}
+
+			reply, err := ParsePacket4(buf[:n])
+			if err != nil || reply.Op != opBootReply || reply.XID != p.XID {
+				continue
+			}
+			switch reply.MessageType() {
+			case want:
+				return reply, nil
+			case Nak:
+				return nil, errors.New("server sent NAK")
+			}
+		}
+	}
+	return nil, fmt.Errorf("no %s received", messageTypeName4(want))
+}
+
+func messageTypeName4(t MessageType4) string {
+	switch t {
+	case Offer:
+		return "DHCPOFFER"
+	case Ack:
+		return "DHCPACK"
+	}
+	return fmt.Sprintf("message type %d", t)
+}
@@ -0,0 +1,422 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package dhcp
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type MessageType6 byte
+
+const (
+	Solicit   MessageType6 = 1
+	Advertise MessageType6 = 2
+	Request6  MessageType6 = 3
+	Renew6    MessageType6 = 5
+	Reply     MessageType6 = 7
+)
+
+const (
+	opt6ClientID     = 1
+	opt6ServerID     = 2
+	opt6IANA         = 3
+	opt6IAAddr       = 5
+	opt6ORO          = 6
+	opt6ElapsedTime  = 8
+	opt6StatusCode   = 13
+	opt6DNSServers   = 23
+	opt6DomainList   = 24
+	statusSuccess    = 0
+	duidTypeLL       = 3
+	hardwareEthernet = 1
+)
+
+// address flags of /proc/net/if_inet6 (IFA_F_*)
+const (
+	ifaFlagDadFailed = 0x08
+	ifaFlagTentative = 0x40
+)
+
+// Option6 is a DHCPv6 option. Options may appear more than once, so
+// they are kept in order.
+type Option6 struct {
+	Code uint16
+	Data []byte
+}
+
+type Options6 []Option6
+
+func (o Options6) Get(code uint16) ([]byte, bool) {
+	for _, opt := range o {
+		if opt.Code == code {
+			return opt.Data, true
+		}
+	}
+	return nil, false
+}
+
+func (o Options6) marshal() []byte {
+	var buf []byte
+	for _, opt := range o {
+		buf = binary.BigEndian.AppendUint16(buf, opt.Code)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(opt.Data)))
+		buf = append(buf, opt.Data...)
+	}
+	return buf
+}
+
+func parseOptions6(data []byte) (Options6, error) {
+	var opts Options6
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return nil, errors.New("dhcp6: truncated option")
+		}
+		code := binary.BigEndian.Uint16(data[0:2])
+		n := int(binary.BigEndian.Uint16(data[2:4]))
+		if len(data) < 4+n {
+			return nil, errors.New("dhcp6: truncated option")
+		}
+		opts = append(opts, Option6{Code: code, Data: data[4 : 4+n]})
+		data = data[4+n:]
+	}
+	return opts, nil
+}
+
+// Packet6 is a DHCPv6 client/server message.
+type Packet6 struct {
+	Type    MessageType6
+	TxID    uint32
+	Options Options6
+}
+
+func (p *Packet6) Marshal() []byte {
+	buf := []byte{byte(p.Type), byte(p.TxID >> 16), byte(p.TxID >> 8), byte(p.TxID)}
+	return append(buf, p.Options.marshal()...)
+}
+
+func ParsePacket6(data []byte) (*Packet6, error) {
+	if len(data) < 4 {
+		return nil, errors.New("dhcp6: packet too short")
+	}
+	opts, err := parseOptions6(data[4:])
+	if err != nil {
+		return nil, err
+	}
+	return &Packet6{
+		Type:    MessageType6(data[0]),
+		TxID:    uint32(data[1])<<16 | uint32(data[2])<<8 | uint32(data[3]),
+		Options: opts,
+	}, nil
+}
+
+// Lease6 is the result of a DHCPv6 exchange. DHCPv6 does not deliver
+// routes; the default router is learned from router advertisements.
+type Lease6 struct {
+	Address           netip.Addr
+	ServerID          []byte
+	IAID              uint32
+	DNS               []netip.Addr
+	DomainSearch      []string
+	RenewalTime       time.Duration
+	RebindTime        time.Duration
+	PreferredLifetime time.Duration
+	ValidLifetime     time.Duration
+	Acquired          time.Time
+}
+
+func leaseFromPacket6(p *Packet6) (*Lease6, error) {
+	if err := statusError(p.Options); err != nil {
+		return nil, err
+	}
+
+	serverID, ok := p.Options.Get(opt6ServerID)
+	if !ok {
+		return nil, errors.New("dhcp6: server identifier is missing")
+	}
+	iana, ok := p.Options.Get(opt6IANA)
+	if !ok || len(iana) < 12 {
+		return nil, errors.New("dhcp6: IA_NA is missing")
+	}
+
+	lease := &Lease6{
+		ServerID:    serverID,
+		IAID:        binary.BigEndian.Uint32(iana[0:4]),
+		RenewalTime: time.Duration(binary.BigEndian.Uint32(iana[4:8])) * time.Second,
+		RebindTime:  time.Duration(binary.BigEndian.Uint32(iana[8:12])) * time.Second,
+		Acquired:    time.Now(),
+	}
+
+	iaOpts, err := parseOptions6(iana[12:])
+	if err != nil {
+		return nil, err
+	}
+	if err := statusError(iaOpts); err != nil {
+		return nil, err
+	}
+	iaaddr, ok := iaOpts.Get(opt6IAAddr)
+	if !ok || len(iaaddr) < 24 {
+		return nil, errors.New("dhcp6: no address assigned")
+	}
+	lease.Address = netip.AddrFrom16([16]byte(iaaddr[0:16]))
+	lease.PreferredLifetime = time.Duration(binary.BigEndian.Uint32(iaaddr[16:20])) * time.Second
+	lease.ValidLifetime = time.Duration(binary.BigEndian.Uint32(iaaddr[20:24])) * time.Second
+
+	// T1/T2 of zero leave the choice to the client (RFC 8415 21.4)
+	if lease.RenewalTime == 0 {
+		lease.RenewalTime = lease.PreferredLifetime / 2
+	}
+	if lease.RebindTime == 0 {
+		lease.RebindTime = lease.PreferredLifetime * 4 / 5
+	}
+
+	if v, ok := p.Options.Get(opt6DNSServers); ok {
+		for len(v) >= 16 {
+			lease.DNS = append(lease.DNS, netip.AddrFrom16([16]byte(v[:16])))
+			v = v[16:]
+		}
+	}
+	if v, ok := p.Options.Get(opt6DomainList); ok {
+		names, err := parseDomainNames(v, false)
+		if err != nil {
+			return nil, err
+		}
+		lease.DomainSearch = names
+	}
+
+	return lease, nil
+}
+
+func statusError(opts Options6) error {
+	v, ok := opts.Get(opt6StatusCode)
+	if !ok || len(v) < 2 {
+		return nil
+	}
+	code := binary.BigEndian.Uint16(v[0:2])
+	if code == statusSuccess {
+		return nil
+	}
+	return fmt.Errorf("dhcp6: status %d: %s", code, v[2:])
+}
+
+// Client6 is a DHCPv6 client bound to a single interface.
+type Client6 struct {
+	ifname string
+	index  int
+	duid   []byte
+	iaid   uint32
+	conn   net.PacketConn
+	dst    *net.UDPAddr
+
+	Timeout    time.Duration
+	Retries    int
+	DADTimeout time.Duration
+}
+
+// NewClient6 opens the client socket. It must be called in the netns
+// of the interface (see netns.Do).
+func NewClient6(ifname string) (*Client6, error) {
+	iface, err := net.InterfaceByName(ifname)
+	if err != nil {
+		return nil, err
+	}
+
+	lc := net.ListenConfig{Control: controlBindToDevice(ifname, false)}
+	conn, err := lc.ListenPacket(context.Background(), "udp6", "[::]:546")
+	if err != nil {
+		return nil, err
+	}
+
+	// DUID-LL (RFC 8415 11.4)
+	duid := []byte{0, duidTypeLL, 0, hardwareEthernet}
+	duid = append(duid, iface.HardwareAddr...)
+
+	return &Client6{
+		ifname: ifname,
+		index:  iface.Index,
+		duid:   duid,
+		iaid:   uint32(iface.Index),
+		conn:   conn,
+		// All_DHCP_Relay_Agents_and_Servers
+		// the zone is given by index, as names are resolved in the
+		// netns of the calling thread
+		dst:        &net.UDPAddr{IP: net.ParseIP("ff02::1:2"), Port: 547, Zone: strconv.Itoa(iface.Index)},
+		Timeout:    4 * time.Second,
+		Retries:    4,
+		DADTimeout: 10 * time.Second,
+	}, nil
+}
+
+func (c *Client6) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Client6) newPacket(t MessageType6, serverID []byte, addr netip.Addr) *Packet6 {
+	iana := binary.BigEndian.AppendUint32(nil, c.iaid)
+	iana = append(iana, make([]byte, 8)...)
+	if addr.IsValid() {
+		a := addr.As16()
+		iaaddr := append(a[:], make([]byte, 8)...)
+		iana = append(iana, Options6{{Code: opt6IAAddr, Data: iaaddr}}.marshal()...)
+	}
+
+	opts := Options6{
+		{Code: opt6ClientID, Data: c.duid},
+		{Code: opt6ElapsedTime, Data: []byte{0, 0}},
+		{Code: opt6ORO, Data: []byte{0, opt6DNSServers, 0, opt6DomainList}},
+		{Code: opt6IANA, Data: iana},
+	}
+	if serverID != nil {
+		opts = append(opts, Option6{Code: opt6ServerID, Data: serverID})
+	}
+
+	return &Packet6{Type: t, TxID: rand.Uint32() & 0xffffff, Options: opts}
+}
+
+// Acquire runs the SOLICIT/ADVERTISE/REQUEST/REPLY exchange.
+func (c *Client6) Acquire() (*Lease6, error) {
+	if err := c.waitLinkLocal(); err != nil {
+		return nil, fmt.Errorf("dhcp6 %s: %w", c.ifname, err)
+	}
+
+	adv, err := c.exchange(c.newPacket(Solicit, nil, netip.Addr{}), Advertise)
+	if err != nil {
+		return nil, fmt.Errorf("dhcp6 %s: %w", c.ifname, err)
+	}
+	offered, err := leaseFromPacket6(adv)
+	if err != nil {
+		return nil, fmt.Errorf("dhcp6 %s: %w", c.ifname, err)
+	}
+
+	return c.request(c.newPacket(Request6, offered.ServerID, offered.Address))
+}
+
+// Renew extends lease with the server that assigned it.
+func (c *Client6) Renew(lease *Lease6) (*Lease6, error) {
+	return c.request(c.newPacket(Renew6, lease.ServerID, lease.Address))
+}
+
+func (c *Client6) request(req *Packet6) (*Lease6, error) {
+	reply, err := c.exchange(req, Reply)
+	if err != nil {
+		return nil, fmt.Errorf("dhcp6 %s: %w", c.ifname, err)
+	}
+	lease, err := leaseFromPacket6(reply)
+	if err != nil {
+		return nil, fmt.Errorf("dhcp6 %s: %w", c.ifname, err)
+	}
+	return lease, nil
+}
+
+func (c *Client6) exchange(p *Packet6, want MessageType6) (*Packet6, error) {
+	data := p.Marshal()
+	buf := make([]byte, 1500)
+
+	for attempt := 0; attempt < c.Retries; attempt++ {
+		if _, err := c.conn.WriteTo(data, c.dst); err != nil {
+			return nil, err
+		}
+
+		c.conn.SetReadDeadline(time.Now().Add(c.Timeout))
+		for {
+			n, _, err := c.conn.ReadFrom(buf)
+			if err != nil {
+				if isTimeout(err) {
+					break
+				}
+				return nil, err
+			}
+
+			reply, err := ParsePacket6(buf[:n])
+			if err != nil || reply.TxID != p.TxID || reply.Type != want {
+				continue
+			}
+			return reply, nil
+		}
+	}
+	return nil, fmt.Errorf("no reply (message type %d) received", want)
+}
+
+// waitLinkLocal waits until the interface has a link-local address that
+// has passed duplicate address detection. Right after link up the
+// address is tentative, and the kernel does not send from it.
+func (c *Client6) waitLinkLocal() error {
+	deadline := time.Now().Add(c.DADTimeout)
+	for {
+		// thread-self, as the netns is per thread (see netns.Do)
+		data, err := os.ReadFile("/proc/thread-self/net/if_inet6")
+		if err != nil {
+			return err
+		}
+		ready, err := linkLocalReady(string(data), c.index)
+		if err != nil || ready {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return errors.New("no usable link-local address")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// linkLocalReady reports whether the interface has a link-local address
+// that is not tentative. data is in the format of /proc/net/if_inet6.
+func linkLocalReady(data string, index int) (bool, error) {
+	failed := false
+	for _, line := range strings.Split(data, "\n") {
+		f := strings.Fields(line)
+		if len(f) < 6 {
+			continue
+		}
+		b, err := hex.DecodeString(f[0])
+		if err != nil || len(b) != 16 {
+			continue
+		}
+		addr := netip.AddrFrom16([16]byte(b))
+		idx, err1 := strconv.ParseUint(f[1], 16, 32)
+		flags, err2 := strconv.ParseUint(f[4], 16, 32)
+		if err1 != nil || err2 != nil || int(idx) != index || !addr.IsLinkLocalUnicast() {
+			continue
+		}
+
+		switch {
+		case flags&ifaFlagDadFailed != 0:
+			failed = true
+		case flags&ifaFlagTentative == 0:
+			return true, nil
+		}
+	}
+	if failed {
+		return false, errors.New("duplicate link-local address detected")
+	}
+	return false, nil
+}
@@ -0,0 +1,231 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package dhcp
+
+import (
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseDomainNames(t *testing.T) {
+	// example from RFC 3397 section 2
+	input := []byte{
+		3, 'e', 'n', 'g', 5, 'a', 'p', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0,
+		9, 'm', 'a', 'r', 'k', 'e', 't', 'i', 'n', 'g', 0xc0, 4,
+	}
+	expected := []string{"eng.apple.com", "marketing.apple.com"}
+
+	got, err := parseDomainNames(input, true)
+	if err != nil {
+		t.Fatalf("parseDomainNames() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("parseDomainNames() = %v, want %v", got, expected)
+	}
+
+	if _, err := parseDomainNames(input, false); err == nil {
+		t.Errorf("parseDomainNames() expected error for compression pointer")
+	}
+
+	loop := []byte{0xc0, 0}
+	if _, err := parseDomainNames(loop, true); err == nil {
+		t.Errorf("parseDomainNames() expected error for pointer loop")
+	}
+}
+
+func TestParseClasslessRoutes(t *testing.T) {
+	input := []byte{
+		0, 10, 0, 0, 1, // default via 10.0.0.1
+		24, 192, 168, 1, 10, 0, 0, 254, // 192.168.1.0/24 via 10.0.0.254
+	}
+	expected := []Route4{
+		{Dst: netip.MustParsePrefix("0.0.0.0/0"), Gateway: netip.MustParseAddr("10.0.0.1")},
+		{Dst: netip.MustParsePrefix("192.168.1.0/24"), Gateway: netip.MustParseAddr("10.0.0.254")},
+	}
+
+	got, err := parseClasslessRoutes(input)
+	if err != nil {
+		t.Fatalf("parseClasslessRoutes() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("parseClasslessRoutes() = %v, want %v", got, expected)
+	}
+
+	if _, err := parseClasslessRoutes([]byte{24, 192, 168}); err == nil {
+		t.Errorf("parseClasslessRoutes() expected error for truncated route")
+	}
+}
+
+func TestLeaseFromPacket4(t *testing.T) {
+	hwaddr, _ := net.ParseMAC("52:54:00:12:34:56")
+	ack := &Packet4{
+		Op:     opBootReply,
+		XID:    0x12345678,
+		YIAddr: netip.MustParseAddr("10.0.0.10"),
+		CHAddr: hwaddr,
+		Options: map[byte][]byte{
+			optMessageType: {byte(Ack)},
+			optSubnetMask:  {255, 255, 255, 0},
+			optRouter:      {10, 0, 0, 1},
+			optDNS:         {10, 0, 0, 2, 10, 0, 0, 3},
+			optServerID:    {10, 0, 0, 1},
+			optLeaseTime:   {0, 0, 0x0e, 0x10},
+			optDomainName:  []byte("example.com"),
+		},
+	}
+
+	p, err := ParsePacket4(ack.Marshal())
+	if err != nil {
+		t.Fatalf("ParsePacket4() error = %v", err)
+	}
+	if p.MessageType() != Ack || p.XID != ack.XID || p.CHAddr.String() != hwaddr.String() {
+		t.Errorf("ParsePacket4() = %+v, want %+v", p, ack)
+	}
+
+	lease, err := leaseFromPacket4(p)
+	if err != nil {
+		t.Fatalf("leaseFromPacket4() error = %v", err)
+	}
+	lease.Acquired = time.Time{}
+
+	expected := &Lease4{
+		Address:     netip.MustParsePrefix("10.0.0.10/24"),
+		ServerID:    netip.MustParseAddr("10.0.0.1"),
+		Routers:     []netip.Addr{netip.MustParseAddr("10.0.0.1")},
+		DNS:         []netip.Addr{netip.MustParseAddr("10.0.0.2"), netip.MustParseAddr("10.0.0.3")},
+		DomainName:  "example.com",
+		LeaseTime:   time.Hour,
+		RenewalTime: 30 * time.Minute,
+		RebindTime:  52*time.Minute + 30*time.Second,
+	}
+	if !reflect.DeepEqual(lease, expected) {
+		t.Errorf("leaseFromPacket4() = %+v, want %+v", lease, expected)
+	}
+}
+
+func TestLeaseFromPacket6(t *testing.T) {
+	addr := netip.MustParseAddr("2001:db8::100").As16()
+	iaaddr := append(addr[:], 0, 0, 0x0e, 0x10, 0, 0, 0x1c, 0x20) // preferred 3600, valid 7200
+
+	iana := binary.BigEndian.AppendUint32(nil, 2)
+	iana = append(iana, 0, 0, 0, 0, 0, 0, 0, 0) // T1/T2 left to the client
+	iana = append(iana, Options6{{Code: opt6IAAddr, Data: iaaddr}}.marshal()...)
+
+	dns := netip.MustParseAddr("2001:db8::53").As16()
+	reply := &Packet6{
+		Type: Reply,
+		TxID: 0xabcdef,
+		Options: Options6{
+			{Code: opt6ServerID, Data: []byte{0, 3, 0, 1, 1, 2, 3, 4, 5, 6}},
+			{Code: opt6IANA, Data: iana},
+			{Code: opt6DNSServers, Data: dns[:]},
+			{Code: opt6DomainList, Data: []byte{7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0}},
+		},
+	}
+
+	p, err := ParsePacket6(reply.Marshal())
+	if err != nil {
+		t.Fatalf("ParsePacket6() error = %v", err)
+	}
+	if p.Type != Reply || p.TxID != reply.TxID {
+		t.Errorf("ParsePacket6() = %+v, want %+v", p, reply)
+	}
+
+	lease, err := leaseFromPacket6(p)
+	if err != nil {
+		t.Fatalf("leaseFromPacket6() error = %v", err)
+	}
+	lease.Acquired = time.Time{}
+
+	expected := &Lease6{
+		Address:           netip.MustParseAddr("2001:db8::100"),
+		ServerID:          []byte{0, 3, 0, 1, 1, 2, 3, 4, 5, 6},
+		IAID:              2,
+		DNS:               []netip.Addr{netip.MustParseAddr("2001:db8::53")},
+		DomainSearch:      []string{"example.com"},
+		RenewalTime:       30 * time.Minute,
+		RebindTime:        48 * time.Minute,
+		PreferredLifetime: time.Hour,
+		ValidLifetime:     2 * time.Hour,
+	}
+	if !reflect.DeepEqual(lease, expected) {
+		t.Errorf("leaseFromPacket6() = %+v, want %+v", lease, expected)
+	}
+
+	nak := &Packet6{Type: Reply, Options: Options6{{Code: opt6StatusCode, Data: append([]byte{0, 2}, "NoAddrsAvail"...)}}}
+	if _, err := leaseFromPacket6(nak); err == nil {
+		t.Errorf("leaseFromPacket6() expected error for status code")
+	}
+}
+
+func TestLinkLocalReady(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    bool
+		wantErr bool
+	}{
+		{
+			name: "ready",
+			data: "fe800000000000000000000000000001 04 40 20 80     eth0\n",
+			want: true,
+		},
+		{
+			name: "tentative",
+			data: "fe800000000000000000000000000001 04 40 20 c0     eth0\n",
+		},
+		{
+			name:    "duplicate address",
+			data:    "fe800000000000000000000000000001 04 40 20 c8     eth0\n",
+			wantErr: true,
+		},
+		{
+			name: "global address only",
+			data: "fd000000000000000000000000000002 04 40 00 80     eth0\n",
+		},
+		{
+			name: "other interface",
+			data: "fe800000000000000000000000000001 05 40 20 80     eth1\n",
+		},
+		{
+			name: "one of several is ready",
+			data: "fe800000000000000000000000000001 04 40 20 c0     eth0\n" +
+				"fe800000000000000000000000000002 04 40 20 80     eth0\n",
+			want: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := linkLocalReady(tt.data, 4)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("linkLocalReady() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("linkLocalReady() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -6,11 +6,11 @@ require (
 	github.com/TwiN/deepmerge v0.2.1
 	github.com/spf13/cobra v1.8.1
 	gitlab.com/greyxor/slogor v1.4.1
+	golang.org/x/sys v0.26.0
 	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
 	github.com/spf13/pflag v1.0.5 // indirect
-	golang.org/x/sys v0.26.0 // indirect
 )
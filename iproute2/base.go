@@ -27,10 +27,25 @@ import (
 	"io"
 	"log/slog"
 	"os/exec"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
 )
 
+const (
+	FamilyInet  = "inet"
+	FamilyInet6 = "inet6"
+)
+
+// familyOption returns the "ip" option that selects the address family.
+func familyOption(family string) string {
+	if family == FamilyInet6 {
+		return "-6"
+	}
+	return "-4"
+}
+
 type BaseCommand struct {
 	path    string
 	prepend []string
@@ -42,16 +57,18 @@ type CommandOut struct {
 }
 
 func (b *BaseCommand) run(args ...string) error {
-	_, err := b.runIpCommand(args...)
+	_, err := b.runTool(args...)
 	return err
 }
 
-func (b *BaseCommand) runIpCommand(args ...string) (string, error) {
+// runTool runs the command at b.path and returns its output. Warnings are
+// logged with the name of the command, e.g. "ethtool command warning".
+func (b *BaseCommand) runTool(args ...string) (string, error) {
 	cmd := append([]string{b.path}, args...)
 	out, err := b.runCommand(cmd, nil)
 	if err == nil {
 		if out.Stderr != "" {
-			slog.Warn("ip command warning", "msg", out.Stderr)
+			slog.Warn(filepath.Base(b.path)+" command warning", "msg", out.Stderr)
 		}
 
 		return out.Stdout, nil
@@ -124,24 +141,128 @@ func (b *BaseCommand) AddVethDevice(name string, peerName string) error {
 	return b.AddLink(name, "veth", "peer", "name", peerName)
 }
 
+func (b *BaseCommand) AddGeneveDevice(name string, options ...string) error {
+	return b.AddLink(name, "geneve", options...)
+}
+
+func (b *BaseCommand) AddBareUDPDevice(name string, options ...string) error {
+	return b.AddLink(name, "bareudp", options...)
+}
+
 func (b *BaseCommand) SetLinkUp(name string) error {
 	return b.run("link", "set", "dev", name, "up")
 }
 
-func (b *BaseCommand) AddAddress(name string, address string) error {
-	return b.run("address", "add", address, "dev", name)
+func (b *BaseCommand) SetLinkDown(name string) error {
+	return b.run("link", "set", "dev", name, "down")
+}
+
+func (b *BaseCommand) SetLinkMtu(name string, mtu int) error {
+	return b.run("link", "set", "dev", name, "mtu", strconv.Itoa(mtu))
+}
+
+func (b *BaseCommand) SetLinkAddress(name string, address string) error {
+	return b.run("link", "set", "dev", name, "address", address)
+}
+
+func (b *BaseCommand) SetLinkTxQueueLen(name string, txqueuelen int) error {
+	return b.run("link", "set", "dev", name, "txqueuelen", strconv.Itoa(txqueuelen))
+}
+
+func (b *BaseCommand) SetLinkAlias(name string, alias string) error {
+	return b.run("link", "set", "dev", name, "alias", alias)
+}
+
+func (b *BaseCommand) SetLinkGroup(name string, group string) error {
+	return b.run("link", "set", "dev", name, "group", group)
+}
+
+func (b *BaseCommand) AddLinkAltName(name string, altname string) error {
+	return b.run("link", "property", "add", "dev", name, "altname", altname)
+}
+
+func (b *BaseCommand) SetLinkName(name string, newName string) error {
+	return b.run("link", "set", "dev", name, "name", newName)
 }
 
-func (b *BaseCommand) DelAddress(name string, address string) error {
-	return b.run("address", "del", address, "dev", name)
+func (b *BaseCommand) SetLinkMaster(name string, master string) error {
+	return b.run("link", "set", "dev", name, "master", master)
 }
 
-func (b *BaseCommand) AddRoute(name string, to string, via string) error {
-	return b.run("route", "add", to, "via", via, "dev", name)
+func (b *BaseCommand) SetLinkNoMaster(name string) error {
+	return b.run("link", "set", "dev", name, "nomaster")
 }
 
-func (b *BaseCommand) DelRoute(name string, to string, via string) error {
-	return b.run("route", "del", to, "via", via, "dev", name)
+func (b *BaseCommand) AddAddress(name string, address string, options ...string) error {
+	args := append([]string{"address", "add", address, "dev", name}, options...)
+	return b.run(args...)
+}
+
+func (b *BaseCommand) ReplaceAddress(name string, address string, options ...string) error {
+	args := append([]string{"address", "replace", address, "dev", name}, options...)
+	return b.run(args...)
+}
+
+func (b *BaseCommand) DelAddress(name string, address string, options ...string) error {
+	args := append([]string{"address", "del", address, "dev", name}, options...)
+	return b.run(args...)
+}
+
+func (b *BaseCommand) AddRoute(name string, to string, via string, options ...string) error {
+	return b.run(routeArgs("add", name, to, via, options)...)
+}
+
+func (b *BaseCommand) ReplaceRoute(name string, to string, via string, options ...string) error {
+	return b.run(routeArgs("replace", name, to, via, options)...)
+}
+
+func (b *BaseCommand) DelRoute(name string, to string, via string, options ...string) error {
+	return b.run(routeArgs("del", name, to, via, options)...)
+}
+
+// AddMultipathRoute adds an ECMP route to "to" through the nexthops.
+func (b *BaseCommand) AddMultipathRoute(to string, nexthops []RouteNexthop, options ...string) error {
+	return b.run(multipathRouteArgs("add", to, nexthops, options)...)
+}
+
+func (b *BaseCommand) ReplaceMultipathRoute(to string, nexthops []RouteNexthop, options ...string) error {
+	return b.run(multipathRouteArgs("replace", to, nexthops, options)...)
+}
+
+// multipathRouteArgs builds "ip route" arguments for a multipath route.
+// "nexthop" consumes the rest of the arguments, so the nexthops come last.
+func multipathRouteArgs(cmd string, to string, nexthops []RouteNexthop, options []string) []string {
+	args := append([]string{"route", cmd}, options...)
+	args = append(args, to)
+	for _, nh := range nexthops {
+		args = append(args, "nexthop")
+		if nh.Gateway != "" {
+			args = append(args, "via", nh.Gateway)
+		}
+		if nh.Dev != "" {
+			args = append(args, "dev", nh.Dev)
+		}
+		if nh.Weight != 0 {
+			args = append(args, "weight", strconv.Itoa(nh.Weight))
+		}
+	}
+	return args
+}
+
+// routeArgs builds "ip route" arguments. An empty via makes a device
+// (on-link) route and an empty name leaves the device to the kernel.
+// The options are placed before the destination, so they may end with
+// a route type such as "blackhole".
+func routeArgs(cmd string, name string, to string, via string, options []string) []string {
+	args := append([]string{"route", cmd}, options...)
+	args = append(args, to)
+	if via != "" {
+		args = append(args, "via", via)
+	}
+	if name != "" {
+		args = append(args, "dev", name)
+	}
+	return args
 }
 
 func (i *IpCmd) InNetns() bool {
@@ -155,13 +276,19 @@ func (i *IpCmd) Netns() string {
 type AddressInfo struct {
 	Family            string `json:"family"`
 	Local             string `json:"local"`
+	Address           string `json:"address,omitempty"`
 	Prefixlen         int    `json:"prefixlen"`
 	Scope             string `json:"scope"`
 	Label             string `json:"label"`
+	NoDad             bool   `json:"nodad,omitempty"`
+	NoPrefixRoute     bool   `json:"noprefixroute,omitempty"`
 	ValidLifeTime     uint64 `json:"valid_life_time"`
 	PreferredLifeTime uint64 `json:"preferred_life_time"`
 }
 
+// ForeverLifetime is the lifetime of an address that never expires.
+const ForeverLifetime = 4294967295
+
 type OperState string
 
 const (
@@ -188,7 +315,7 @@ type InterfaceInfo struct {
 type Interfaces []InterfaceInfo
 
 func (b *BaseCommand) ListInterfaces() (Interfaces, error) {
-	data, err := b.runIpCommand("-json", "address", "show")
+	data, err := b.runTool("-json", "address", "show")
 	if err != nil {
 		return nil, err
 	}
@@ -197,7 +324,7 @@ func (b *BaseCommand) ListInterfaces() (Interfaces, error) {
 }
 
 func (b *BaseCommand) ShowInterface(name string) (*InterfaceInfo, error) {
-	data, err := b.runIpCommand("-json", "address", "show", "dev", name)
+	data, err := b.runTool("-json", "address", "show", "dev", name)
 	if err != nil {
 		return nil, err
 	}
@@ -226,6 +353,7 @@ type Link struct {
 	Flags     []string  `json:"flags"`
 	Mtu       int       `json:"mtu"`
 	Qdisc     string    `json:"qdisc"`
+	Master    string    `json:"master,omitempty"`
 	Operstate OperState `json:"operstate"`
 	Linkmode  string    `json:"linkmode"`
 	Group     string    `json:"group"`
@@ -233,20 +361,41 @@ type Link struct {
 	LinkType  string    `json:"link_type"`
 	Address   string    `json:"address"`
 	Broadcast string    `json:"broadcast"`
+	IfAlias   string    `json:"ifalias,omitempty"`
+	AltNames  []string  `json:"altnames,omitempty"`
+	ParentBus string    `json:"parentbus,omitempty"`
+	ParentDev string    `json:"parentdev,omitempty"`
+	LinkInfo  *LinkInfo `json:"linkinfo,omitempty"`
+}
+
+// LinkInfo is only reported with -details. Virtual links such as
+// veth and bridge devices have an InfoKind, physical devices do not.
+type LinkInfo struct {
+	InfoKind string `json:"info_kind,omitempty"`
 }
 
 type Links []Link
 
 func (b *BaseCommand) ListLinks() (Links, error) {
-	data, err := b.runIpCommand("-json", "link", "show")
+	data, err := b.runTool("-json", "link", "show")
 	if err != nil {
 		return nil, err
 	}
 
 	return unmarshalLinksData(data)
 }
+
+func (b *BaseCommand) ListLinkDetails() (Links, error) {
+	data, err := b.runTool("-json", "-details", "link", "show")
+	if err != nil {
+		return nil, err
+	}
+
+	return unmarshalLinksData(data)
+}
+
 func (b *BaseCommand) ShowLink(name string) (*Link, error) {
-	data, err := b.runIpCommand("-json", "link", "show", "dev", name)
+	data, err := b.runTool("-json", "link", "show", "dev", name)
 	if err != nil {
 		return nil, err
 	}
@@ -270,29 +419,64 @@ func unmarshalLinksData(data string) (Links, error) {
 }
 
 type Route struct {
-	Dst      string   `json:"dst,omitempty"`
-	Gateway  string   `json:"gateway,omitempty"`
-	Dev      string   `json:"dev,omitempty"`
-	Type     string   `json:"type,omitempty"`
-	Protocol string   `json:"protocol,omitempty"`
-	Scope    string   `json:"scope,omitempty"`
-	PrefSrc  string   `json:"prefsrc,omitempty"`
-	Flags    []string `json:"flags,omitempty"`
+	Dst      string         `json:"dst,omitempty"`
+	Gateway  string         `json:"gateway,omitempty"`
+	Dev      string         `json:"dev,omitempty"`
+	Type     string         `json:"type,omitempty"`
+	Protocol string         `json:"protocol,omitempty"`
+	Scope    string         `json:"scope,omitempty"`
+	PrefSrc  string         `json:"prefsrc,omitempty"`
+	Flags    []string       `json:"flags,omitempty"`
+	From     string         `json:"from,omitempty"`
+	Table    string         `json:"table,omitempty"`
+	Metric   int            `json:"metric,omitempty"`
+	Metrics  []RouteMetrics `json:"metrics,omitempty"`
+	Nexthops []RouteNexthop `json:"nexthops,omitempty"`
+	Nhid     int            `json:"nhid,omitempty"`
+	Pref     string         `json:"pref,omitempty"`
+	Encap    *RouteEncap    `json:"-"`
 }
 
-type Routes []Route
+// RouteNexthop is a path of a multipath route.
+type RouteNexthop struct {
+	Gateway string   `json:"gateway,omitempty"`
+	Dev     string   `json:"dev,omitempty"`
+	Weight  int      `json:"weight,omitempty"`
+	Flags   []string `json:"flags,omitempty"`
+}
 
-func (b *BaseCommand) ListRoutes() (Routes, error) {
-	data, err := b.runIpCommand("-json", "route", "show")
-	if err != nil {
-		return nil, err
+// RouteMetrics holds the per-route metrics such as "mtu" and "advmss".
+type RouteMetrics struct {
+	Mtu    int `json:"mtu,omitempty"`
+	AdvMSS int `json:"advmss,omitempty"`
+}
+
+// Mtu returns the mtu metric of the route, or 0 if it is not set.
+func (r Route) Mtu() int {
+	for _, m := range r.Metrics {
+		if m.Mtu != 0 {
+			return m.Mtu
+		}
 	}
+	return 0
+}
 
-	return unmarshalRoutesData(data)
+// AdvMSS returns the advmss metric of the route, or 0 if it is not set.
+func (r Route) AdvMSS() int {
+	for _, m := range r.Metrics {
+		if m.AdvMSS != 0 {
+			return m.AdvMSS
+		}
+	}
+	return 0
 }
 
-func (b *BaseCommand) ShowRoutes(name string) (Routes, error) {
-	data, err := b.runIpCommand("-json", "route", "show", "dev", name)
+type Routes []Route
+
+// ListRoutes returns the routes of the address family in all tables.
+// Routes outside the main table have Table set.
+func (b *BaseCommand) ListRoutes(family string) (Routes, error) {
+	data, err := b.runTool("-json", familyOption(family), "route", "show", "table", "all")
 	if err != nil {
 		return nil, err
 	}
@@ -22,10 +22,31 @@ SOFTWARE.
 package iproute2
 
 import (
+	"bytes"
+	"log/slog"
 	"reflect"
+	"strings"
 	"testing"
 )
 
+func TestRunToolWarning(t *testing.T) {
+	var buf bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+
+	b := &BaseCommand{path: "/bin/sh"}
+	out, err := b.runTool("-c", "echo out; echo warn >&2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "out\n" {
+		t.Errorf("runTool() = %q, want %q", out, "out\n")
+	}
+	if log := buf.String(); !strings.Contains(log, `msg="sh command warning"`) {
+		t.Errorf("warning is not logged with the command name: %s", log)
+	}
+}
+
 func TestUnmarshalInterfacesData(t *testing.T) {
 	testCases := []struct {
 		desc         string
@@ -57,6 +78,30 @@ func TestUnmarshalInterfacesData(t *testing.T) {
 			},
 			expectingErr: false,
 		},
+		{
+			desc:  "Valid input with peer and flags",
+			input: `[{"ifindex":3,"link":"vb","ifname":"va","flags":["BROADCAST","MULTICAST","UP","LOWER_UP"],"mtu":1500,"qdisc":"noqueue","operstate":"UP","group":"default","txqlen":1000,"link_type":"ether","address":"5e:a5:ee:95:b7:65","broadcast":"ff:ff:ff:ff:ff:ff","addr_info":[{"family":"inet","local":"10.0.0.1","address":"10.0.0.2","prefixlen":32,"scope":"link","label":"va:1","valid_life_time":4294967295,"preferred_life_time":4294967295},{"family":"inet6","local":"2001:db8::1","prefixlen":64,"scope":"global","nodad":true,"dynamic":true,"noprefixroute":true,"valid_life_time":300,"preferred_life_time":200}]}]`,
+			expected: Interfaces{
+				{
+					Ifindex:   3,
+					Ifname:    "va",
+					Flags:     []string{"BROADCAST", "MULTICAST", "UP", "LOWER_UP"},
+					Mtu:       1500,
+					Qdisc:     "noqueue",
+					Operstate: OperStateUp,
+					Group:     "default",
+					Txqlen:    1000,
+					LinkType:  "ether",
+					Address:   "5e:a5:ee:95:b7:65",
+					Broadcast: "ff:ff:ff:ff:ff:ff",
+					AddrInfo: []AddressInfo{
+						{Family: "inet", Local: "10.0.0.1", Address: "10.0.0.2", Prefixlen: 32, Scope: "link", Label: "va:1", ValidLifeTime: ForeverLifetime, PreferredLifeTime: ForeverLifetime},
+						{Family: "inet6", Local: "2001:db8::1", Prefixlen: 64, Scope: "global", NoDad: true, NoPrefixRoute: true, ValidLifeTime: 300, PreferredLifeTime: 200},
+					},
+				},
+			},
+			expectingErr: false,
+		},
 		{
 			desc:         "Invalid input",
 			input:        `invalid JSON`,
@@ -107,6 +152,51 @@ func TestUnmarshalLinksData(t *testing.T) {
 			},
 			expectingErr: false,
 		},
+		{
+			desc:  "Valid input with details",
+			input: `[{"ifindex":2,"ifname":"enp1s0","flags":["BROADCAST","MULTICAST","UP","LOWER_UP"],"mtu":1500,"qdisc":"fq_codel","operstate":"UP","linkmode":"DEFAULT","group":"default","txqlen":1000,"link_type":"ether","address":"52:54:00:12:34:56","broadcast":"ff:ff:ff:ff:ff:ff","promiscuity":0,"min_mtu":68,"max_mtu":65535,"num_tx_queues":1,"num_rx_queues":1,"parentbus":"pci","parentdev":"0000:01:00.0"}]`,
+			expected: Links{
+				{
+					Ifindex:   2,
+					Ifname:    "enp1s0",
+					Flags:     []string{"BROADCAST", "MULTICAST", "UP", "LOWER_UP"},
+					Mtu:       1500,
+					Qdisc:     "fq_codel",
+					Operstate: OperStateUp,
+					Linkmode:  "DEFAULT",
+					Group:     "default",
+					Txqlen:    1000,
+					LinkType:  "ether",
+					Address:   "52:54:00:12:34:56",
+					Broadcast: "ff:ff:ff:ff:ff:ff",
+					ParentBus: "pci",
+					ParentDev: "0000:01:00.0",
+				},
+			},
+			expectingErr: false,
+		},
+		{
+			desc:  "Valid input with linkinfo",
+			input: `[{"ifindex":5,"link":"veth1","ifname":"veth0","flags":["BROADCAST","MULTICAST"],"mtu":1500,"qdisc":"noop","operstate":"DOWN","linkmode":"DEFAULT","group":"default","txqlen":1000,"link_type":"ether","address":"ca:6a:21:26:f6:b2","broadcast":"ff:ff:ff:ff:ff:ff","promiscuity":0,"linkinfo":{"info_kind":"veth"},"num_tx_queues":1,"num_rx_queues":1}]`,
+			expected: Links{
+				{
+					Ifindex:   5,
+					Ifname:    "veth0",
+					Flags:     []string{"BROADCAST", "MULTICAST"},
+					Mtu:       1500,
+					Qdisc:     "noop",
+					Operstate: OperStateDown,
+					Linkmode:  "DEFAULT",
+					Group:     "default",
+					Txqlen:    1000,
+					LinkType:  "ether",
+					Address:   "ca:6a:21:26:f6:b2",
+					Broadcast: "ff:ff:ff:ff:ff:ff",
+					LinkInfo:  &LinkInfo{InfoKind: "veth"},
+				},
+			},
+			expectingErr: false,
+		},
 		{
 			desc:         "Invalid input",
 			input:        `invalid JSON`,
@@ -128,3 +218,85 @@ func TestUnmarshalLinksData(t *testing.T) {
 		})
 	}
 }
+
+func TestUnmarshalRoutesData(t *testing.T) {
+	testCases := []struct {
+		desc         string
+		input        string
+		expected     Routes
+		expectingErr bool
+	}{
+		{
+			desc:  "Valid input",
+			input: `[{"dst":"10.1.0.0/24","dev":"va","protocol":"kernel","scope":"link","prefsrc":"10.1.0.1","flags":[]},{"dst":"10.9.0.0/16","gateway":"10.1.0.2","dev":"va","protocol":"static","prefsrc":"10.1.0.1","metric":5,"flags":[],"metrics":[{"mtu":1400,"advmss":1360}]}]`,
+			expected: Routes{
+				{Dst: "10.1.0.0/24", Dev: "va", Protocol: "kernel", Scope: "link", PrefSrc: "10.1.0.1", Flags: []string{}},
+				{Dst: "10.9.0.0/16", Gateway: "10.1.0.2", Dev: "va", Protocol: "static", PrefSrc: "10.1.0.1", Metric: 5, Flags: []string{}, Metrics: []RouteMetrics{{Mtu: 1400, AdvMSS: 1360}}},
+			},
+			expectingErr: false,
+		},
+		{
+			desc:  "Valid input with route types and tables",
+			input: `[{"type":"blackhole","dst":"10.8.0.0/16","metric":7,"flags":[]},{"type":"prohibit","dst":"10.3.0.0/16","table":"60","flags":[]},{"type":"local","dst":"10.1.0.1","dev":"va","table":"local","protocol":"kernel","scope":"host","prefsrc":"10.1.0.1","flags":[]}]`,
+			expected: Routes{
+				{Type: "blackhole", Dst: "10.8.0.0/16", Metric: 7, Flags: []string{}},
+				{Type: "prohibit", Dst: "10.3.0.0/16", Table: "60", Flags: []string{}},
+				{Type: "local", Dst: "10.1.0.1", Dev: "va", Table: "local", Protocol: "kernel", Scope: "host", PrefSrc: "10.1.0.1", Flags: []string{}},
+			},
+			expectingErr: false,
+		},
+		{
+			desc:  "Valid IPv6 input",
+			input: `[{"type":"unreachable","dst":"default","dev":"lo","table":"100","metric":1024,"flags":[],"pref":"medium"},{"dst":"2001:db8:300::1","dev":"v0","metric":1024,"flags":[],"metrics":[{"mtu":1400}],"pref":"medium"},{"dst":"default","gateway":"2001:db8:8::fe","dev":"v0","metric":1024,"flags":[],"pref":"medium"}]`,
+			expected: Routes{
+				{Type: "unreachable", Dst: "default", Dev: "lo", Table: "100", Metric: 1024, Flags: []string{}, Pref: "medium"},
+				{Dst: "2001:db8:300::1", Dev: "v0", Metric: 1024, Flags: []string{}, Metrics: []RouteMetrics{{Mtu: 1400}}, Pref: "medium"},
+				{Dst: "default", Gateway: "2001:db8:8::fe", Dev: "v0", Metric: 1024, Flags: []string{}, Pref: "medium"},
+			},
+			expectingErr: false,
+		},
+		{
+			desc:  "Valid input with nexthop object",
+			input: `[{"dst":"10.0.0.0/8","nhid":4,"dev":"lo","flags":[]}]`,
+			expected: Routes{
+				{Dst: "10.0.0.0/8", Nhid: 4, Dev: "lo", Flags: []string{}},
+			},
+			expectingErr: false,
+		},
+		{
+			desc:  "Valid input with multipath route",
+			input: `[{"dst":"default","metric":5,"flags":[],"nexthops":[{"gateway":"10.1.0.2","dev":"va","weight":1,"flags":[]},{"gateway":"10.2.0.2","dev":"vb","weight":3,"flags":[]}]}]`,
+			expected: Routes{
+				{
+					Dst:    "default",
+					Metric: 5,
+					Flags:  []string{},
+					Nexthops: []RouteNexthop{
+						{Gateway: "10.1.0.2", Dev: "va", Weight: 1, Flags: []string{}},
+						{Gateway: "10.2.0.2", Dev: "vb", Weight: 3, Flags: []string{}},
+					},
+				},
+			},
+			expectingErr: false,
+		},
+		{
+			desc:         "Invalid input",
+			input:        `invalid JSON`,
+			expected:     nil,
+			expectingErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, err := unmarshalRoutesData(tc.input)
+			if (err != nil) != tc.expectingErr {
+				t.Errorf("unmarshalRoutesData() error = %v, expectingErr %v", err, tc.expectingErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("unmarshalRoutesData() = %v, want %v", got, tc.expected)
+			}
+		})
+	}
+}
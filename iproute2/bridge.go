@@ -0,0 +1,142 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package iproute2
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+type BridgeCmd struct {
+	BaseCommand
+}
+
+// Bridge returns a bridge command that runs in the same netns as b.
+func (b *BaseCommand) Bridge(path string) *BridgeCmd {
+	return &BridgeCmd{
+		BaseCommand: BaseCommand{path: path, prepend: b.prepend},
+	}
+}
+
+// BridgeVlan is a VLAN (or a range of VLANs up to VlanEnd) of a bridge
+// port. Flags are "PVID" and "Egress Untagged".
+type BridgeVlan struct {
+	Vlan    int      `json:"vlan"`
+	VlanEnd int      `json:"vlanEnd,omitempty"`
+	Flags   []string `json:"flags,omitempty"`
+}
+
+type BridgePortVlans struct {
+	IfName string       `json:"ifname"`
+	Vlans  []BridgeVlan `json:"vlans"`
+}
+
+const (
+	BridgeVlanPVID     = "PVID"
+	BridgeVlanUntagged = "Egress Untagged"
+)
+
+// ListVlans returns the VLANs of the bridge port, with ranges expanded.
+func (c *BridgeCmd) ListVlans(name string) ([]BridgeVlan, error) {
+	data, err := c.runTool("-json", "vlan", "show", "dev", name)
+	if err != nil {
+		return nil, err
+	}
+
+	return unmarshalBridgeVlansData(data, name)
+}
+
+func (c *BridgeCmd) AddVlan(name string, vid int, options ...string) error {
+	args := append([]string{"vlan", "add", "vid", strconv.Itoa(vid), "dev", name}, options...)
+	return c.run(args...)
+}
+
+func (c *BridgeCmd) DelVlan(name string, vid int) error {
+	return c.run("vlan", "del", "vid", strconv.Itoa(vid), "dev", name)
+}
+
+func unmarshalBridgeVlansData(data string, name string) ([]BridgeVlan, error) {
+	var ports []BridgePortVlans
+	err := json.Unmarshal([]byte(data), &ports)
+	if err != nil {
+		return nil, err
+	}
+
+	var vlans []BridgeVlan
+	for _, port := range ports {
+		if port.IfName != name {
+			continue
+		}
+		for _, v := range port.Vlans {
+			end := v.VlanEnd
+			if end < v.Vlan {
+				end = v.Vlan
+			}
+			for vid := v.Vlan; vid <= end; vid++ {
+				vlans = append(vlans, BridgeVlan{Vlan: vid, Flags: v.Flags})
+			}
+		}
+	}
+	return vlans, nil
+}
+
+// FdbEntry is a forwarding database entry ("bridge fdb"). State is
+// "permanent", "static" or empty for learned entries.
+type FdbEntry struct {
+	Mac    string   `json:"mac"`
+	IfName string   `json:"ifname,omitempty"`
+	Vlan   int      `json:"vlan,omitempty"`
+	Master string   `json:"master,omitempty"`
+	Flags  []string `json:"flags,omitempty"`
+	State  string   `json:"state,omitempty"`
+}
+
+type FdbEntries []FdbEntry
+
+func (c *BridgeCmd) ListFdb(name string) (FdbEntries, error) {
+	data, err := c.runTool("-json", "fdb", "show", "dev", name)
+	if err != nil {
+		return nil, err
+	}
+
+	return unmarshalFdbData(data)
+}
+
+func (c *BridgeCmd) ReplaceFdb(name string, mac string, options ...string) error {
+	args := append([]string{"fdb", "replace", mac, "dev", name}, options...)
+	return c.run(args...)
+}
+
+func (c *BridgeCmd) DelFdb(name string, mac string, options ...string) error {
+	args := append([]string{"fdb", "del", mac, "dev", name}, options...)
+	return c.run(args...)
+}
+
+func unmarshalFdbData(data string) (FdbEntries, error) {
+	var entries FdbEntries
+	err := json.Unmarshal([]byte(data), &entries)
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,113 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package iproute2
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUnmarshalBridgeVlansData(t *testing.T) {
+	testCases := []struct {
+		desc         string
+		input        string
+		expected     []BridgeVlan
+		expectingErr bool
+	}{
+		{
+			desc:  "Valid input",
+			input: `[{"ifname":"br0","vlans":[{"vlan":1,"flags":["PVID","Egress Untagged"]}]},{"ifname":"va","vlans":[{"vlan":10,"flags":["PVID","Egress Untagged"]},{"vlan":20},{"vlan":30,"vlanEnd":32}]}]`,
+			expected: []BridgeVlan{
+				{Vlan: 10, Flags: []string{BridgeVlanPVID, BridgeVlanUntagged}},
+				{Vlan: 20},
+				{Vlan: 30},
+				{Vlan: 31},
+				{Vlan: 32},
+			},
+			expectingErr: false,
+		},
+		{
+			desc:         "No vlans",
+			input:        `[]`,
+			expected:     nil,
+			expectingErr: false,
+		},
+		{
+			desc:         "Invalid input",
+			input:        `invalid JSON`,
+			expected:     nil,
+			expectingErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, err := unmarshalBridgeVlansData(tc.input, "va")
+			if (err != nil) != tc.expectingErr {
+				t.Errorf("unmarshalBridgeVlansData() error = %v, expectingErr %v", err, tc.expectingErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("unmarshalBridgeVlansData() = %v, want %v", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestUnmarshalFdbData(t *testing.T) {
+	testCases := []struct {
+		desc         string
+		input        string
+		expected     FdbEntries
+		expectingErr bool
+	}{
+		{
+			desc:  "Valid input",
+			input: `[{"mac":"02:00:00:00:00:01","vlan":10,"master":"br0","flags":[],"state":"static"},{"mac":"02:00:00:00:00:02","master":"br0","flags":[],"state":""},{"mac":"33:33:00:00:00:01","flags":["self"],"state":"permanent"}]`,
+			expected: FdbEntries{
+				{Mac: "02:00:00:00:00:01", Vlan: 10, Master: "br0", Flags: []string{}, State: "static"},
+				{Mac: "02:00:00:00:00:02", Master: "br0", Flags: []string{}},
+				{Mac: "33:33:00:00:00:01", Flags: []string{"self"}, State: "permanent"},
+			},
+			expectingErr: false,
+		},
+		{
+			desc:         "Invalid input",
+			input:        `invalid JSON`,
+			expected:     nil,
+			expectingErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, err := unmarshalFdbData(tc.input)
+			if (err != nil) != tc.expectingErr {
+				t.Errorf("unmarshalFdbData() error = %v, expectingErr %v", err, tc.expectingErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("unmarshalFdbData() = %v, want %v", got, tc.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package iproute2
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+)
+
+const (
+	EncapMPLS      = "mpls"
+	EncapSeg6      = "seg6"
+	EncapSeg6Local = "seg6local"
+)
+
+// RouteEncap is the lightweight tunnel encapsulation of a route.
+type RouteEncap struct {
+	Type     string   `json:"encap"`
+	Labels   string   `json:"dst,omitempty"`
+	TTL      int      `json:"ttl,omitempty"`
+	Mode     string   `json:"mode,omitempty"`
+	Segs     []string `json:"segs,omitempty"`
+	Action   string   `json:"action,omitempty"`
+	Table    string   `json:"table,omitempty"`
+	VrfTable string   `json:"vrftable,omitempty"`
+	Nh4      string   `json:"nh4,omitempty"`
+	Nh6      string   `json:"nh6,omitempty"`
+	Iif      string   `json:"iif,omitempty"`
+	Oif      string   `json:"oif,omitempty"`
+}
+
+// encapKeys are the keys "ip -json route" prints right after "encap".
+// Some of them are also keys of the route itself, e.g. "dst" holds the
+// labels of mpls and "table" is the lookup table of seg6local.
+var encapKeys = map[string][]string{
+	EncapMPLS:      {"dst", "ttl"},
+	EncapSeg6:      {"mode", "segs"},
+	EncapSeg6Local: {"action", "srh", "table", "vrftable", "nh4", "nh6", "iif", "oif", "flavors", "counters"},
+}
+
+// UnmarshalJSON separates the encap keys from the keys of the route, since
+// both may appear in the same object.
+func (r *Route) UnmarshalJSON(data []byte) error {
+	fields, encap, err := splitEncap(data)
+	if err != nil {
+		return err
+	}
+
+	type plain Route
+	err = remarshal(fields, (*plain)(r))
+	if err != nil {
+		return err
+	}
+
+	if encap != nil {
+		r.Encap = &RouteEncap{}
+		return remarshal(encap, r.Encap)
+	}
+	return nil
+}
+
+func splitEncap(data []byte) (map[string]json.RawMessage, map[string]json.RawMessage, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, nil, err
+	}
+	if tok != json.Delim('{') {
+		return nil, nil, fmt.Errorf("route must be a JSON object")
+	}
+
+	fields := map[string]json.RawMessage{}
+	var encap map[string]json.RawMessage
+	var encapType string
+	inEncap := false
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		key, _ := tok.(string)
+
+		var value json.RawMessage
+		err = dec.Decode(&value)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		switch {
+		case key == "encap":
+			err = json.Unmarshal(value, &encapType)
+			if err != nil {
+				return nil, nil, err
+			}
+			encap = map[string]json.RawMessage{key: value}
+			inEncap = true
+		case inEncap && slices.Contains(encapKeys[encapType], key):
+			encap[key] = value
+		default:
+			inEncap = false
+			fields[key] = value
+		}
+	}
+	return fields, encap, nil
+}
+
+func remarshal(fields map[string]json.RawMessage, v any) error {
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,71 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package iproute2
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUnmarshalRoutesDataWithEncap(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		input    string
+		expected Routes
+	}{
+		{
+			desc:  "mpls",
+			input: `[{"dst":"10.10.0.0/16","encap":"mpls","dst":"100/200","gateway":"10.1.0.2","dev":"va","flags":[]}]`,
+			expected: Routes{
+				{Dst: "10.10.0.0/16", Gateway: "10.1.0.2", Dev: "va", Flags: []string{}, Encap: &RouteEncap{Type: "mpls", Labels: "100/200"}},
+			},
+		},
+		{
+			desc:  "seg6",
+			input: `[{"dst":"2001:db8:9::/48","encap":"seg6","mode":"encap","segs":["fc00::1","fc00::2"],"dev":"va","metric":1024,"flags":[],"pref":"medium"},{"dst":"2001:db8:a::/48","encap":"seg6","mode":"inline","segs":["fc00::3","::"],"dev":"va","metric":1024,"flags":[],"pref":"medium"}]`,
+			expected: Routes{
+				{Dst: "2001:db8:9::/48", Dev: "va", Metric: 1024, Flags: []string{}, Pref: "medium", Encap: &RouteEncap{Type: "seg6", Mode: "encap", Segs: []string{"fc00::1", "fc00::2"}}},
+				{Dst: "2001:db8:a::/48", Dev: "va", Metric: 1024, Flags: []string{}, Pref: "medium", Encap: &RouteEncap{Type: "seg6", Mode: "inline", Segs: []string{"fc00::3", "::"}}},
+			},
+		},
+		{
+			desc:  "seg6local",
+			input: `[{"dst":"fc00::101","encap":"seg6local","action":"End.X","nh6":"2001:db8:1::2","dev":"va","metric":1024,"flags":[],"pref":"medium"},{"dst":"fc00::102","encap":"seg6local","action":"End.DT6","table":"100","dev":"va","table":"200","metric":1024,"flags":["linkdown"],"pref":"medium"}]`,
+			expected: Routes{
+				{Dst: "fc00::101", Dev: "va", Metric: 1024, Flags: []string{}, Pref: "medium", Encap: &RouteEncap{Type: "seg6local", Action: "End.X", Nh6: "2001:db8:1::2"}},
+				{Dst: "fc00::102", Dev: "va", Table: "200", Metric: 1024, Flags: []string{"linkdown"}, Pref: "medium", Encap: &RouteEncap{Type: "seg6local", Action: "End.DT6", Table: "100"}},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, err := unmarshalRoutesData(tc.input)
+			if err != nil {
+				t.Fatalf("unmarshalRoutesData() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("unmarshalRoutesData() = %+v, want %+v", got, tc.expected)
+			}
+		})
+	}
+}
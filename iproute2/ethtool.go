@@ -0,0 +1,181 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package iproute2
+
+import (
+	"bufio"
+	"strconv"
+	"strings"
+)
+
+type EthtoolCmd struct {
+	BaseCommand
+}
+
+// Ethtool returns an ethtool command that runs in the same netns as b.
+func (b *BaseCommand) Ethtool(path string) *EthtoolCmd {
+	return &EthtoolCmd{
+		BaseCommand: BaseCommand{path: path, prepend: b.prepend},
+	}
+}
+
+type offloadFeature struct {
+	shortName string
+	longName  string
+}
+
+// offloadFeatures maps config keys to the legacy ethtool names.
+// Keys not listed here are passed through as kernel feature names.
+var offloadFeatures = map[string]offloadFeature{
+	"rx-checksum":     {"rx", "rx-checksumming"},
+	"tx-checksum":     {"tx", "tx-checksumming"},
+	"sg":              {"sg", "scatter-gather"},
+	"tso":             {"tso", "tcp-segmentation-offload"},
+	"gso":             {"gso", "generic-segmentation-offload"},
+	"gro":             {"gro", "generic-receive-offload"},
+	"lro":             {"lro", "large-receive-offload"},
+	"rx-vlan-offload": {"rxvlan", "rx-vlan-offload"},
+	"tx-vlan-offload": {"txvlan", "tx-vlan-offload"},
+	"ntuple":          {"ntuple", "ntuple-filters"},
+	"rxhash":          {"rxhash", "receive-hashing"},
+}
+
+// FeatureName returns the name of key as shown by `ethtool -k`.
+func FeatureName(key string) string {
+	if f, ok := offloadFeatures[key]; ok {
+		return f.longName
+	}
+	return key
+}
+
+func featureArg(key string) string {
+	if f, ok := offloadFeatures[key]; ok {
+		return f.shortName
+	}
+	return key
+}
+
+type Feature struct {
+	Enabled bool
+	Fixed   bool
+}
+
+type Features map[string]Feature
+
+func (e *EthtoolCmd) ShowFeatures(name string) (Features, error) {
+	data, err := e.runTool("-k", name)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseFeaturesData(data), nil
+}
+
+func parseFeaturesData(data string) Features {
+	features := Features{}
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		key, value, ok := strings.Cut(line, ": ")
+		if !ok {
+			continue
+		}
+
+		state, rest, _ := strings.Cut(value, " ")
+		if state != "on" && state != "off" {
+			continue
+		}
+		features[key] = Feature{
+			Enabled: state == "on",
+			Fixed:   strings.Contains(rest, "[fixed]"),
+		}
+	}
+	return features
+}
+
+func (e *EthtoolCmd) SetFeatures(name string, features map[string]bool) error {
+	args := []string{"-K", name}
+	for key, enabled := range features {
+		state := "off"
+		if enabled {
+			state = "on"
+		}
+		args = append(args, featureArg(key), state)
+	}
+	return e.run(args...)
+}
+
+type Ring struct {
+	RX      int
+	RXMini  int
+	RXJumbo int
+	TX      int
+}
+
+func (e *EthtoolCmd) ShowRing(name string) (*Ring, error) {
+	data, err := e.runTool("-g", name)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseRingData(data), nil
+}
+
+// parseRingData returns the current hardware settings of `ethtool -g`.
+// Unsupported parameters ("n/a") are reported as 0.
+func parseRingData(data string) *Ring {
+	var ring Ring
+	current := false
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "Current hardware settings") {
+			current = true
+			continue
+		}
+		if !current {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		n, _ := strconv.Atoi(strings.TrimSpace(value))
+		switch key {
+		case "RX":
+			ring.RX = n
+		case "RX Mini":
+			ring.RXMini = n
+		case "RX Jumbo":
+			ring.RXJumbo = n
+		case "TX":
+			ring.TX = n
+		}
+	}
+	return &ring
+}
+
+func (e *EthtoolCmd) SetRing(name string, options ...string) error {
+	args := append([]string{"-G", name}, options...)
+	return e.run(args...)
+}
@@ -0,0 +1,96 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package iproute2
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseFeaturesData(t *testing.T) {
+	input := `Features for veth0:
+rx-checksumming: on
+tx-checksumming: on
+	tx-checksum-ipv4: off [fixed]
+	tx-checksum-ip-generic: on
+scatter-gather: on
+tcp-segmentation-offload: on
+generic-receive-offload: off
+large-receive-offload: off [fixed]
+rx-vlan-offload: on [requested off]
+`
+	expected := Features{
+		"rx-checksumming":          {Enabled: true},
+		"tx-checksumming":          {Enabled: true},
+		"tx-checksum-ipv4":         {Enabled: false, Fixed: true},
+		"tx-checksum-ip-generic":   {Enabled: true},
+		"scatter-gather":           {Enabled: true},
+		"tcp-segmentation-offload": {Enabled: true},
+		"generic-receive-offload":  {Enabled: false},
+		"large-receive-offload":    {Enabled: false, Fixed: true},
+		"rx-vlan-offload":          {Enabled: true},
+	}
+
+	got := parseFeaturesData(input)
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("parseFeaturesData() = %v, want %v", got, expected)
+	}
+}
+
+func TestFeatureName(t *testing.T) {
+	testCases := []struct {
+		key      string
+		expected string
+	}{
+		{"tso", "tcp-segmentation-offload"},
+		{"rx-checksum", "rx-checksumming"},
+		{"tx-udp-segmentation", "tx-udp-segmentation"},
+	}
+
+	for _, tc := range testCases {
+		if got := FeatureName(tc.key); got != tc.expected {
+			t.Errorf("FeatureName(%q) = %v, want %v", tc.key, got, tc.expected)
+		}
+	}
+}
+
+func TestParseRingData(t *testing.T) {
+	input := `Ring parameters for eth0:
+Pre-set maximums:
+RX:		4096
+RX Mini:	n/a
+RX Jumbo:	n/a
+TX:		4096
+Current hardware settings:
+RX:		256
+RX Mini:	n/a
+RX Jumbo:	n/a
+TX:		512
+RX Buf Len:		n/a
+`
+	expected := &Ring{RX: 256, TX: 512}
+
+	got := parseRingData(input)
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("parseRingData() = %v, want %v", got, expected)
+	}
+}
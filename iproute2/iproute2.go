@@ -67,7 +67,7 @@ func (i *IpCmd) DelNetns(name string) error {
 }
 
 func (i *IpCmd) ListNetns() []string {
-	data, _ := i.runIpCommand("netns", "list")
+	data, _ := i.runTool("netns", "list")
 
 	var netns []string
 	for _, line := range strings.Split(data, "\n") {
@@ -83,7 +83,7 @@ func (i *IpCmd) SetNetns(name string, netns string) error {
 }
 
 func (i *IpCmd) ListNetnsProcesses(netns string) ([]int, error) {
-	out, err := i.runIpCommand("netns", "pids", netns)
+	out, err := i.runTool("netns", "pids", netns)
 	if err != nil {
 		return nil, err
 	}
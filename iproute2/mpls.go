@@ -0,0 +1,72 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package iproute2
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// MplsRoute is a label switching entry ("ip -f mpls route").
+type MplsRoute struct {
+	Dst    string   `json:"dst"`
+	NewDst string   `json:"newdst,omitempty"`
+	Via    *MplsVia `json:"via,omitempty"`
+	Dev    string   `json:"dev,omitempty"`
+	Flags  []string `json:"flags,omitempty"`
+}
+
+type MplsVia struct {
+	Family string `json:"family"`
+	Host   string `json:"host"`
+}
+
+type MplsRoutes []MplsRoute
+
+func (b *BaseCommand) ListMplsRoutes() (MplsRoutes, error) {
+	data, err := b.runTool("-json", "-f", "mpls", "route", "show")
+	if err != nil {
+		return nil, err
+	}
+
+	return unmarshalMplsRoutesData(data)
+}
+
+func (b *BaseCommand) AddMplsRoute(label int, options ...string) error {
+	args := append([]string{"-f", "mpls", "route", "add", strconv.Itoa(label)}, options...)
+	return b.run(args...)
+}
+
+func (b *BaseCommand) ReplaceMplsRoute(label int, options ...string) error {
+	args := append([]string{"-f", "mpls", "route", "replace", strconv.Itoa(label)}, options...)
+	return b.run(args...)
+}
+
+func unmarshalMplsRoutesData(data string) (MplsRoutes, error) {
+	var routes MplsRoutes
+	err := json.Unmarshal([]byte(data), &routes)
+	if err != nil {
+		return nil, err
+	}
+
+	return routes, nil
+}
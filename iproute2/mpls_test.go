@@ -0,0 +1,65 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package iproute2
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUnmarshalMplsRoutesData(t *testing.T) {
+	testCases := []struct {
+		desc         string
+		input        string
+		expected     MplsRoutes
+		expectingErr bool
+	}{
+		{
+			desc:  "Valid input",
+			input: `[{"dst":"100","newdst":"200","via":{"family":"inet","host":"10.1.0.2"},"dev":"va","flags":[]},{"dst":"101","via":{"family":"inet6","host":"2001:db8:1::2"},"dev":"va","flags":[]}]`,
+			expected: MplsRoutes{
+				{Dst: "100", NewDst: "200", Via: &MplsVia{Family: "inet", Host: "10.1.0.2"}, Dev: "va", Flags: []string{}},
+				{Dst: "101", Via: &MplsVia{Family: "inet6", Host: "2001:db8:1::2"}, Dev: "va", Flags: []string{}},
+			},
+			expectingErr: false,
+		},
+		{
+			desc:         "Invalid input",
+			input:        `invalid JSON`,
+			expected:     nil,
+			expectingErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, err := unmarshalMplsRoutesData(tc.input)
+			if (err != nil) != tc.expectingErr {
+				t.Errorf("unmarshalMplsRoutesData() error = %v, expectingErr %v", err, tc.expectingErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("unmarshalMplsRoutesData() = %v, want %v", got, tc.expected)
+			}
+		})
+	}
+}
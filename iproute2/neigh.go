@@ -0,0 +1,88 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package iproute2
+
+import (
+	"encoding/json"
+)
+
+// Neighbor is an ARP/NDP entry ("ip neigh").
+type Neighbor struct {
+	Dst    string   `json:"dst"`
+	Dev    string   `json:"dev,omitempty"`
+	LLAddr string   `json:"lladdr,omitempty"`
+	State  []string `json:"state,omitempty"`
+}
+
+type Neighbors []Neighbor
+
+// ListNeighbors lists the entries of the device in any state. "ip neigh"
+// hides noarp entries unless asked for all of them.
+func (b *BaseCommand) ListNeighbors(name string) (Neighbors, error) {
+	return b.listNeighbors("show", "nud", "all", "dev", name)
+}
+
+// ListProxyNeighbors lists the proxy ARP/NDP entries of the device.
+func (b *BaseCommand) ListProxyNeighbors(name string) (Neighbors, error) {
+	return b.listNeighbors("show", "proxy", "dev", name)
+}
+
+func (b *BaseCommand) listNeighbors(args ...string) (Neighbors, error) {
+	data, err := b.runTool(append([]string{"-json", "neigh"}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	return unmarshalNeighborsData(data)
+}
+
+func (b *BaseCommand) AddNeighbor(name string, address string, options ...string) error {
+	args := append([]string{"neigh", "add", address, "dev", name}, options...)
+	return b.run(args...)
+}
+
+func (b *BaseCommand) ReplaceNeighbor(name string, address string, options ...string) error {
+	args := append([]string{"neigh", "replace", address, "dev", name}, options...)
+	return b.run(args...)
+}
+
+func (b *BaseCommand) DelNeighbor(name string, address string) error {
+	return b.run("neigh", "del", address, "dev", name)
+}
+
+func (b *BaseCommand) AddProxyNeighbor(name string, address string) error {
+	return b.run("neigh", "add", "proxy", address, "dev", name)
+}
+
+func (b *BaseCommand) DelProxyNeighbor(name string, address string) error {
+	return b.run("neigh", "del", "proxy", address, "dev", name)
+}
+
+func unmarshalNeighborsData(data string) (Neighbors, error) {
+	var neighbors Neighbors
+	err := json.Unmarshal([]byte(data), &neighbors)
+	if err != nil {
+		return nil, err
+	}
+
+	return neighbors, nil
+}
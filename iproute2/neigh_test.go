@@ -0,0 +1,74 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package iproute2
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUnmarshalNeighborsData(t *testing.T) {
+	testCases := []struct {
+		desc         string
+		input        string
+		expected     Neighbors
+		expectingErr bool
+	}{
+		{
+			desc:  "Valid input",
+			input: `[{"dst":"10.1.0.9","lladdr":"02:00:00:00:00:09","state":["PERMANENT"]},{"dst":"2001:db8::9","dev":"va","lladdr":"02:00:00:00:00:09","state":["REACHABLE"]}]`,
+			expected: Neighbors{
+				{Dst: "10.1.0.9", LLAddr: "02:00:00:00:00:09", State: []string{"PERMANENT"}},
+				{Dst: "2001:db8::9", Dev: "va", LLAddr: "02:00:00:00:00:09", State: []string{"REACHABLE"}},
+			},
+			expectingErr: false,
+		},
+		{
+			desc:  "Proxy entries",
+			input: `[{"dst":"10.1.0.50","proxy":null},{"dst":"2001:db8::50","proxy":null}]`,
+			expected: Neighbors{
+				{Dst: "10.1.0.50"},
+				{Dst: "2001:db8::50"},
+			},
+			expectingErr: false,
+		},
+		{
+			desc:         "Invalid input",
+			input:        `invalid JSON`,
+			expected:     nil,
+			expectingErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, err := unmarshalNeighborsData(tc.input)
+			if (err != nil) != tc.expectingErr {
+				t.Errorf("unmarshalNeighborsData() error = %v, expectingErr %v", err, tc.expectingErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("unmarshalNeighborsData() = %v, want %v", got, tc.expected)
+			}
+		})
+	}
+}
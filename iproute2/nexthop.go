@@ -0,0 +1,104 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package iproute2
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// Nexthop is a nexthop object ("ip nexthop").
+type Nexthop struct {
+	ID            int                  `json:"id"`
+	Gateway       string               `json:"gateway,omitempty"`
+	Dev           string               `json:"dev,omitempty"`
+	Scope         string               `json:"scope,omitempty"`
+	Blackhole     bool                 `json:"-"`
+	Group         []NexthopGroupMember `json:"group,omitempty"`
+	Type          string               `json:"type,omitempty"`
+	ResilientArgs *ResilientArgs       `json:"resilient_args,omitempty"`
+	Flags         []string             `json:"flags,omitempty"`
+}
+
+type NexthopGroupMember struct {
+	ID     int `json:"id"`
+	Weight int `json:"weight,omitempty"`
+}
+
+type ResilientArgs struct {
+	Buckets         int `json:"buckets"`
+	IdleTimer       int `json:"idle_timer"`
+	UnbalancedTimer int `json:"unbalanced_timer"`
+}
+
+type Nexthops []Nexthop
+
+// UnmarshalJSON detects blackhole nexthops, which "ip -json" prints as
+// "blackhole": null.
+func (n *Nexthop) UnmarshalJSON(data []byte) error {
+	type plain Nexthop
+	err := json.Unmarshal(data, (*plain)(n))
+	if err != nil {
+		return err
+	}
+
+	var keys map[string]json.RawMessage
+	err = json.Unmarshal(data, &keys)
+	if err != nil {
+		return err
+	}
+	_, n.Blackhole = keys["blackhole"]
+	return nil
+}
+
+func (b *BaseCommand) ListNexthops() (Nexthops, error) {
+	data, err := b.runTool("-json", "nexthop", "show")
+	if err != nil {
+		return nil, err
+	}
+
+	return unmarshalNexthopsData(data)
+}
+
+func (b *BaseCommand) AddNexthop(id int, options ...string) error {
+	args := append([]string{"nexthop", "add", "id", strconv.Itoa(id)}, options...)
+	return b.run(args...)
+}
+
+func (b *BaseCommand) ReplaceNexthop(id int, options ...string) error {
+	args := append([]string{"nexthop", "replace", "id", strconv.Itoa(id)}, options...)
+	return b.run(args...)
+}
+
+func (b *BaseCommand) DelNexthop(id int) error {
+	return b.run("nexthop", "del", "id", strconv.Itoa(id))
+}
+
+func unmarshalNexthopsData(data string) (Nexthops, error) {
+	var nexthops Nexthops
+	err := json.Unmarshal([]byte(data), &nexthops)
+	if err != nil {
+		return nil, err
+	}
+
+	return nexthops, nil
+}
@@ -0,0 +1,73 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package iproute2
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUnmarshalNexthopsData(t *testing.T) {
+	testCases := []struct {
+		desc         string
+		input        string
+		expected     Nexthops
+		expectingErr bool
+	}{
+		{
+			desc:  "Valid input",
+			input: `[{"id":1,"gateway":"10.1.0.2","dev":"va","scope":"link","flags":[]},{"id":3,"blackhole":null,"flags":[]},{"id":10,"group":[{"id":1},{"id":2,"weight":3}],"flags":[]},{"id":11,"group":[{"id":1,"weight":2},{"id":4}],"type":"resilient","resilient_args":{"buckets":32,"idle_timer":120,"unbalanced_timer":0,"unbalanced_time":0},"flags":[]}]`,
+			expected: Nexthops{
+				{ID: 1, Gateway: "10.1.0.2", Dev: "va", Scope: "link", Flags: []string{}},
+				{ID: 3, Blackhole: true, Flags: []string{}},
+				{ID: 10, Group: []NexthopGroupMember{{ID: 1}, {ID: 2, Weight: 3}}, Flags: []string{}},
+				{
+					ID:            11,
+					Group:         []NexthopGroupMember{{ID: 1, Weight: 2}, {ID: 4}},
+					Type:          "resilient",
+					ResilientArgs: &ResilientArgs{Buckets: 32, IdleTimer: 120},
+					Flags:         []string{},
+				},
+			},
+			expectingErr: false,
+		},
+		{
+			desc:         "Invalid input",
+			input:        `invalid JSON`,
+			expected:     nil,
+			expectingErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, err := unmarshalNexthopsData(tc.input)
+			if (err != nil) != tc.expectingErr {
+				t.Errorf("unmarshalNexthopsData() error = %v, expectingErr %v", err, tc.expectingErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("unmarshalNexthopsData() = %v, want %v", got, tc.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package iproute2
+
+import (
+	"strings"
+)
+
+type NftCmd struct {
+	BaseCommand
+}
+
+// Nft returns an nft command that runs in the same netns as b.
+func (b *BaseCommand) Nft(path string) *NftCmd {
+	return &NftCmd{
+		BaseCommand: BaseCommand{path: path, prepend: b.prepend},
+	}
+}
+
+// LoadRuleset loads the ruleset in a single transaction, so nothing is
+// changed if any part of it fails.
+func (n *NftCmd) LoadRuleset(ruleset string) error {
+	_, err := n.runCommand([]string{n.path, "-f", "-"}, &ruleset)
+	return err
+}
+
+// ListTable returns the table without the values of counters and quotas,
+// so that it only changes when the ruleset does.
+func (n *NftCmd) ListTable(family string, name string) (string, error) {
+	data, err := n.runTool("-s", "list", "table", family, name)
+	if err != nil {
+		if strings.Contains(err.Error(), "No such file or directory") {
+			return "", &NotExistError{Msg: err.Error()}
+		}
+		return "", err
+	}
+	return data, nil
+}
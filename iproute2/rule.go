@@ -0,0 +1,110 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package iproute2
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+type Rule struct {
+	Priority int    `json:"priority"`
+	Src      string `json:"src,omitempty"`
+	SrcLen   int    `json:"srclen,omitempty"`
+	Dst      string `json:"dst,omitempty"`
+	DstLen   int    `json:"dstlen,omitempty"`
+	Iif      string `json:"iif,omitempty"`
+	Oif      string `json:"oif,omitempty"`
+	FwMark   string `json:"fwmark,omitempty"`
+	FwMask   string `json:"fwmask,omitempty"`
+	Table    string `json:"table,omitempty"`
+	Action   string `json:"action,omitempty"`
+}
+
+type Rules []Rule
+
+// Args returns the "ip rule" selector and action arguments that
+// identify the rule, e.g. for deleting it.
+func (r Rule) Args() []string {
+	args := []string{"priority", strconv.Itoa(r.Priority)}
+	if r.Src != "" && r.Src != "all" {
+		args = append(args, "from", prefixArg(r.Src, r.SrcLen))
+	}
+	if r.Dst != "" && r.Dst != "all" {
+		args = append(args, "to", prefixArg(r.Dst, r.DstLen))
+	}
+	if r.Iif != "" {
+		args = append(args, "iif", r.Iif)
+	}
+	if r.Oif != "" {
+		args = append(args, "oif", r.Oif)
+	}
+	if r.FwMark != "" {
+		mark := r.FwMark
+		if r.FwMask != "" {
+			mark += "/" + r.FwMask
+		}
+		args = append(args, "fwmark", mark)
+	}
+	if r.Action != "" {
+		args = append(args, r.Action)
+	} else if r.Table != "" {
+		args = append(args, "table", r.Table)
+	}
+	return args
+}
+
+func prefixArg(addr string, length int) string {
+	if length == 0 {
+		return addr
+	}
+	return addr + "/" + strconv.Itoa(length)
+}
+
+func (b *BaseCommand) ListRules(family string) (Rules, error) {
+	data, err := b.runTool("-json", familyOption(family), "rule", "show")
+	if err != nil {
+		return nil, err
+	}
+
+	return unmarshalRulesData(data)
+}
+
+func (b *BaseCommand) AddRule(family string, options ...string) error {
+	args := append([]string{familyOption(family), "rule", "add"}, options...)
+	return b.run(args...)
+}
+
+func (b *BaseCommand) DelRule(family string, options ...string) error {
+	args := append([]string{familyOption(family), "rule", "del"}, options...)
+	return b.run(args...)
+}
+
+func unmarshalRulesData(data string) (Rules, error) {
+	var rules Rules
+	err := json.Unmarshal([]byte(data), &rules)
+	if err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
@@ -0,0 +1,105 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package iproute2
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUnmarshalRulesData(t *testing.T) {
+	testCases := []struct {
+		desc         string
+		input        string
+		expected     Rules
+		expectingErr bool
+	}{
+		{
+			desc:  "Valid input",
+			input: `[{"priority":0,"src":"all","table":"local"},{"priority":99,"src":"all","fwmark":"0x5","action":"prohibit"},{"priority":100,"src":"10.0.0.0","srclen":8,"table":"100"},{"priority":200,"src":"all","dst":"10.1.0.0","dstlen":16,"fwmark":"0x1","fwmask":"0xff","iif":"lo","action":"blackhole"},{"priority":300,"src":"all","oif":"lo","table":"main"}]`,
+			expected: Rules{
+				{Priority: 0, Src: "all", Table: "local"},
+				{Priority: 99, Src: "all", FwMark: "0x5", Action: "prohibit"},
+				{Priority: 100, Src: "10.0.0.0", SrcLen: 8, Table: "100"},
+				{Priority: 200, Src: "all", Dst: "10.1.0.0", DstLen: 16, FwMark: "0x1", FwMask: "0xff", Iif: "lo", Action: "blackhole"},
+				{Priority: 300, Src: "all", Oif: "lo", Table: "main"},
+			},
+			expectingErr: false,
+		},
+		{
+			desc:  "Valid IPv6 input",
+			input: `[{"priority":0,"src":"all","table":"local"},{"priority":32765,"src":"2001:db8::","srclen":32,"table":"200"}]`,
+			expected: Rules{
+				{Priority: 0, Src: "all", Table: "local"},
+				{Priority: 32765, Src: "2001:db8::", SrcLen: 32, Table: "200"},
+			},
+			expectingErr: false,
+		},
+		{
+			desc:         "Invalid input",
+			input:        `invalid JSON`,
+			expected:     nil,
+			expectingErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, err := unmarshalRulesData(tc.input)
+			if (err != nil) != tc.expectingErr {
+				t.Errorf("unmarshalRulesData() error = %v, expectingErr %v", err, tc.expectingErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("unmarshalRulesData() = %v, want %v", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestRuleArgs(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		rule     Rule
+		expected []string
+	}{
+		{
+			desc:     "lookup",
+			rule:     Rule{Priority: 100, Src: "10.0.0.0", SrcLen: 8, Table: "100"},
+			expected: []string{"priority", "100", "from", "10.0.0.0/8", "table", "100"},
+		},
+		{
+			desc:     "action",
+			rule:     Rule{Priority: 200, Src: "all", Dst: "10.1.0.0", DstLen: 16, FwMark: "0x1", FwMask: "0xff", Iif: "lo", Action: "blackhole"},
+			expected: []string{"priority", "200", "to", "10.1.0.0/16", "iif", "lo", "fwmark", "0x1/0xff", "blackhole"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			got := tc.rule.Args()
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("Args() = %v, want %v", got, tc.expected)
+			}
+		})
+	}
+}
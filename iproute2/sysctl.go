@@ -0,0 +1,59 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package iproute2
+
+import (
+	"strings"
+)
+
+// SysctlCommand reads and writes kernel parameters. Keys may be
+// separated by "/" to address devices whose names contain dots.
+type SysctlCommand interface {
+	Read(key string) (string, error)
+	Write(key string, value string) error
+}
+
+type SysctlCmd struct {
+	BaseCommand
+}
+
+// Sysctl returns a sysctl command that runs in the same netns as b. It
+// is returned as a SysctlCommand so that callers can fake it in tests.
+func (b *BaseCommand) Sysctl(path string) SysctlCommand {
+	return &SysctlCmd{
+		BaseCommand: BaseCommand{path: path, prepend: b.prepend},
+	}
+}
+
+// Read returns the value of key.
+func (s *SysctlCmd) Read(key string) (string, error) {
+	out, err := s.runCommand([]string{s.path, "-n", key}, nil)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out.Stdout), nil
+}
+
+func (s *SysctlCmd) Write(key string, value string) error {
+	_, err := s.runCommand([]string{s.path, "-q", "-w", key + "=" + value}, nil)
+	return err
+}
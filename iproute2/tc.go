@@ -0,0 +1,126 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package iproute2
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+type TcCmd struct {
+	BaseCommand
+}
+
+// Tc returns a tc command that runs in the same netns as b.
+func (b *BaseCommand) Tc(path string) *TcCmd {
+	return &TcCmd{
+		BaseCommand: BaseCommand{path: path, prepend: b.prepend},
+	}
+}
+
+type Qdisc struct {
+	Kind    string          `json:"kind"`
+	Handle  string          `json:"handle"`
+	Root    bool            `json:"root"`
+	Options json.RawMessage `json:"options,omitempty"`
+}
+
+// NetemOptions are the options of a netem qdisc as printed by
+// "tc -json". Times are in seconds, probabilities are fractions and the
+// rate is in bytes per second. The probabilities are keyed by their
+// names ("loss", "duplicate", "reorder" and "corrupt").
+type NetemOptions struct {
+	Limit     int                `json:"limit"`
+	Delay     *NetemDelay        `json:"delay,omitempty"`
+	Loss      map[string]float64 `json:"loss-random,omitempty"`
+	Duplicate map[string]float64 `json:"duplicate,omitempty"`
+	Reorder   map[string]float64 `json:"reorder,omitempty"`
+	Corrupt   map[string]float64 `json:"corrupt,omitempty"`
+	Rate      *NetemRate         `json:"rate,omitempty"`
+}
+
+type NetemDelay struct {
+	Delay  float64 `json:"delay"`
+	Jitter float64 `json:"jitter"`
+}
+
+type NetemRate struct {
+	Rate uint64 `json:"rate"`
+}
+
+// ShowRootQdisc returns the root qdisc of the device.
+func (t *TcCmd) ShowRootQdisc(name string) (*Qdisc, error) {
+	data, err := t.runTool("-json", "qdisc", "show", "dev", name, "root")
+	if err != nil {
+		return nil, err
+	}
+
+	return unmarshalRootQdiscData(data, name)
+}
+
+func (t *TcCmd) ReplaceRootQdisc(name string, kind string, options ...string) error {
+	args := append([]string{"qdisc", "replace", "dev", name, "root", kind}, options...)
+	return t.run(args...)
+}
+
+// ChangeRootQdisc changes the options of the root qdisc in place,
+// without dropping the queued packets.
+func (t *TcCmd) ChangeRootQdisc(name string, kind string, options ...string) error {
+	args := append([]string{"qdisc", "change", "dev", name, "root", kind}, options...)
+	return t.run(args...)
+}
+
+func (t *TcCmd) DelRootQdisc(name string) error {
+	return t.run("qdisc", "del", "dev", name, "root")
+}
+
+// Netem returns the options of a netem qdisc.
+func (q *Qdisc) Netem() (*NetemOptions, error) {
+	if q.Kind != "netem" {
+		return nil, fmt.Errorf("qdisc %s is not netem", q.Kind)
+	}
+
+	var options NetemOptions
+	if len(q.Options) == 0 {
+		return &options, nil
+	}
+	err := json.Unmarshal(q.Options, &options)
+	if err != nil {
+		return nil, err
+	}
+	return &options, nil
+}
+
+func unmarshalRootQdiscData(data string, name string) (*Qdisc, error) {
+	var qdiscs []Qdisc
+	err := json.Unmarshal([]byte(data), &qdiscs)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, q := range qdiscs {
+		if q.Root {
+			return &q, nil
+		}
+	}
+	return nil, &NotExistError{Msg: fmt.Sprintf("root qdisc of %s is not found", name)}
+}
@@ -0,0 +1,74 @@
+/*
+Copyright © 2024 buty4649
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package iproute2
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUnmarshalRootQdiscData(t *testing.T) {
+	data := `[{"kind":"netem","handle":"8001:","root":true,"refcnt":2,"options":{"limit":100,"delay":{"delay":0.1,"jitter":0.01,"correlation":0},"loss-random":{"loss":0.01,"correlation":0},"duplicate":{"duplicate":0.005,"correlation":0},"reorder":{"reorder":0.25,"correlation":0},"corrupt":{"corrupt":0.001,"correlation":0},"rate":{"rate":125000,"packetoverhead":0},"ecn":false,"gap":1}},` +
+		`{"kind":"pfifo","handle":"1:","parent":"8001:1","options":{"limit":1000}}]`
+
+	q, err := unmarshalRootQdiscData(data, "eth0")
+	if err != nil {
+		t.Fatalf("unmarshalRootQdiscData() error = %v", err)
+	}
+	if q.Kind != "netem" || q.Handle != "8001:" {
+		t.Errorf("unmarshalRootQdiscData() = %v", q)
+	}
+
+	got, err := q.Netem()
+	if err != nil {
+		t.Fatalf("Netem() error = %v", err)
+	}
+	expected := &NetemOptions{
+		Limit:     100,
+		Delay:     &NetemDelay{Delay: 0.1, Jitter: 0.01},
+		Loss:      map[string]float64{"loss": 0.01, "correlation": 0},
+		Duplicate: map[string]float64{"duplicate": 0.005, "correlation": 0},
+		Reorder:   map[string]float64{"reorder": 0.25, "correlation": 0},
+		Corrupt:   map[string]float64{"corrupt": 0.001, "correlation": 0},
+		Rate:      &NetemRate{Rate: 125000},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Netem() = %v, want %v", got, expected)
+	}
+
+	q, err = unmarshalRootQdiscData(`[{"kind":"noqueue","handle":"0:","root":true,"refcnt":2,"options":{}}]`, "lo")
+	if err != nil {
+		t.Fatalf("unmarshalRootQdiscData() error = %v", err)
+	}
+	if _, err := q.Netem(); err == nil {
+		t.Errorf("Netem() expected error for noqueue")
+	}
+
+	if _, err := unmarshalRootQdiscData(`[]`, "lo"); err == nil {
+		t.Errorf("unmarshalRootQdiscData() expected error for no root qdisc")
+	} else if _, ok := err.(*NotExistError); !ok {
+		t.Errorf("unmarshalRootQdiscData() error = %T, want *NotExistError", err)
+	}
+	if _, err := unmarshalRootQdiscData(`invalid JSON`, "lo"); err == nil {
+		t.Errorf("unmarshalRootQdiscData() expected error for invalid JSON")
+	}
+}